@@ -0,0 +1,123 @@
+package blscosiprotocol
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/pairing"
+	"go.dedis.ch/kyber/v3/sign/bls"
+	"go.dedis.ch/onet/v3"
+)
+
+// DefaultTimeout is how long each level of the tree has to reply when no timeout is given
+const DefaultTimeout = 1 * time.Second
+
+// DefaultQuorum returns the number of signers needed to tolerate f faulty nodes out of n = 3f+1, i.e. 2f+1
+func DefaultQuorum(n int) int {
+	f := (n - 1) / 3
+	return 2*f + 1
+}
+
+// Verify checks a signature produced in threshold mode, made of the aggregate signature followed by the mask
+// of the signers: the signature has to match the aggregate key of the signers, and at least quorum nodes must have signed
+func Verify(suite *pairing.SuiteBn256, publics []kyber.Point, msg []byte, sig []byte, quorum int) error {
+	aggSig, mask, err := SplitSignature(sig, len(publics))
+	if err != nil {
+		return err
+	}
+
+	if countEnabled(mask) < quorum {
+		return errors.New("Not enough signers to reach the quorum")
+	}
+
+	pk, err := aggregateMaskedPublics(suite, publics, mask)
+	if err != nil {
+		return err
+	}
+
+	return bls.Verify(suite, pk, msg, aggSig)
+}
+
+// SplitSignature separates a signature produced in threshold mode into the aggregate signature and the mask of the signers
+func SplitSignature(sig []byte, nbNodes int) ([]byte, []byte, error) {
+	maskLen := maskLength(nbNodes)
+	if len(sig) <= maskLen {
+		return nil, nil, errors.New("Signature too short")
+	}
+	return sig[:len(sig)-maskLen], sig[len(sig)-maskLen:], nil
+}
+
+// Signers returns the indices in the roster of the nodes enabled in a mask
+func Signers(mask []byte, nbNodes int) []int {
+	signers := make([]int, 0)
+	for i := 0; i < nbNodes; i++ {
+		if bitEnabled(mask, i) {
+			signers = append(signers, i)
+		}
+	}
+	return signers
+}
+
+// aggregateMaskedPublics aggregates the public keys of the nodes enabled in a mask
+func aggregateMaskedPublics(suite *pairing.SuiteBn256, publics []kyber.Point, mask []byte) (kyber.Point, error) {
+	if len(mask) != maskLength(len(publics)) {
+		return nil, errors.New("Mask of wrong length")
+	}
+
+	signers := Signers(mask, len(publics))
+	if len(signers) == 0 {
+		return nil, errors.New("No signers")
+	}
+
+	keys := make([]kyber.Point, len(signers))
+	for i, index := range signers {
+		keys[i] = publics[index]
+	}
+	return bls.AggregatePublicKeys(suite, keys...), nil
+}
+
+func maskLength(nbNodes int) int {
+	return (nbNodes + 7) / 8
+}
+
+func newMask(nbNodes int) []byte {
+	return make([]byte, maskLength(nbNodes))
+}
+
+func setBit(mask []byte, i int) {
+	mask[i/8] |= 1 << uint(i%8)
+}
+
+func bitEnabled(mask []byte, i int) bool {
+	return i/8 < len(mask) && mask[i/8]&(1<<uint(i%8)) != 0
+}
+
+// combineMasks adds the nodes enabled in other to mask
+func combineMasks(mask []byte, other []byte) {
+	for i := 0; i < len(mask) && i < len(other); i++ {
+		mask[i] |= other[i]
+	}
+}
+
+func countEnabled(mask []byte) int {
+	count := 0
+	for _, b := range mask {
+		for ; b != 0; b &= b - 1 {
+			count++
+		}
+	}
+	return count
+}
+
+// BlockSignatureVerifier returns a verifier of the signatures produced in threshold mode with the default quorum,
+// as used to sign blocks
+func BlockSignatureVerifier(suite *pairing.SuiteBn256) latencyprotocol.SignatureVerifier {
+	return func(roster *onet.Roster, msg []byte, sig []byte) error {
+		if roster == nil {
+			return errors.New("No roster to verify the signature")
+		}
+		return Verify(suite, roster.Publics(), msg, sig, DefaultQuorum(len(roster.List)))
+	}
+}
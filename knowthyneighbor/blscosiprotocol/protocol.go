@@ -1,15 +1,17 @@
 package blscosiprotocol
 
 import (
+	"bytes"
 	"errors"
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
 	"go.dedis.ch/kyber/v3/pairing"
 	"go.dedis.ch/kyber/v3/sign/bls"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
-	"go.dedis.ch/protobuf"
-	sigAlg "golang.org/x/crypto/ed25519"
-	"time"
 )
 
 // SimpleBLSCoSi is the main structure holding the round and the onet.Node.
@@ -18,84 +20,90 @@ type SimpleBLSCoSi struct {
 	*onet.TreeNodeInstance
 	// the message we want to sign typically given by the Root
 	Message []byte
+	// Data is sent to the nodes along with the message to help them verify it, but is not signed
+	Data []byte
 	// the verification to run during upon receiving the prepare message
 	vf VerificationFn
+	// Threshold is the minimum number of signers needed for the round to succeed. If it is set,
+	// the protocol runs in threshold mode: children who do not reply in time or refuse to sign are
+	// excluded and the final signature is followed by the mask of the signers
+	Threshold int
+	// Timeout is how long each level of the tree has to reply. Children replying too late are left out,
+	// so that a silent node makes the round fail instead of blocking it, unless the threshold is reached
+	Timeout time.Duration
+	// refusal is set when the verification function of this node rejected the message
+	refusal *Refusal
+
+	// Refusals holds, at the root, the refusals of the nodes who did not sign. It is set
+	// before the result of the round is sent to FinalSignature
+	Refusals []Refusal
 
 	prepare      chan prepareChan
 	prepareReply chan prepareReplyChan
 	commit       chan commitChan
 	commitReply  chan commitReplyChan
 	done         chan bool
+	finishOnce   sync.Once
+	// started is closed by Start at the root, once the message, Threshold and Timeout are set
+	started chan bool
 
-	// FinalSignature is the channel that the root should listen on to get the final signature
+	// FinalSignature is the channel that the root should listen on to get the final signature.
+	// If the round fails because of refusals, nil is sent instead
 	FinalSignature chan []byte
 }
 
-// VerificationFn function
-type VerificationFn func(msg []byte) error
+// VerificationFn checks the message to sign, using the data sent along with it
+type VerificationFn func(msg []byte, data []byte) error
 
 //NewLatencyVerificatingProtocol creates a new protocol which checks the latencies of a proposed new block
-//and makes sure they are acceptable
-func NewLatencyVerificatingProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
-	vf := func(a []byte) error {
-
-		//decode a as block struct
-		var block latencyprotocol.Block
-		err := protobuf.Decode(a, &block)
+//against the chain known to the validator and makes sure they are acceptable. The message signed is the hash
+//of the header of the block, and the block itself is sent as data
+func NewLatencyVerificatingProtocol(n *onet.TreeNodeInstance, chain *latencyprotocol.Chain,
+	params latencyprotocol.BlockVerificationParams) (onet.ProtocolInstance, error) {
+	suite := pairing.NewSuiteBn256()
+	vf := func(msg []byte, data []byte) error {
+
+		//decode data as block struct
+		block, err := latencyprotocol.DecodeBlock(data, suite)
 		if err != nil {
-			return err
+			return &latencyprotocol.BlockRejection{Reason: latencyprotocol.MalformedBlock, Message: err.Error()}
 		}
 
-		for pubKey, latency := range block.Latencies {
-
-			//check latency not too long
-			if latency.Latency > 500*time.Millisecond {
-				return errors.New("Latency too long")
-			}
-
-			// check timestamp freshness
-			if time.Now().Sub(latency.Timestamp) < 60*time.Second {
-				return errors.New("Timestamp too old")
-			}
-
-			//check signatures
-			expectedContent := latencyprotocol.SignedForeignLatency{
-				Timestamp:     latency.Timestamp,
-				SignedLatency: latency.SignedLatency}
-
-			encodedExpectedContent, err := protobuf.Encode(&expectedContent)
-			if err != nil {
-				log.LLvl1(err)
-				return err
-			}
+		hash, err := block.Hash()
+		if err != nil || !bytes.Equal(hash, msg) {
+			return &latencyprotocol.BlockRejection{Reason: latencyprotocol.MalformedBlock, Message: "Message is not the hash of the block"}
+		}
 
-			sigCorrect := sigAlg.Verify([]byte(pubKey), encodedExpectedContent, latency.SignedConfirmation)
-			if !sigCorrect {
-				return errors.New("Incorrect foreign signature")
-			}
+		return latencyprotocol.VerifyBlock(block, chain, params)
+	}
+	return NewProtocol(n, vf, suite)
+}
 
-			encodedLat, err := protobuf.Encode(&latency.Latency)
-			if err != nil {
-				log.LLvl1(err)
-				return err
-			}
+//NewSnapshotVerificationProtocol creates a new protocol which checks that a proposed snapshot matches the chain
+//known to the validator. The message signed is the hash of the snapshot, and the snapshot itself is sent as data
+func NewSnapshotVerificationProtocol(n *onet.TreeNodeInstance, chain *latencyprotocol.Chain) (onet.ProtocolInstance, error) {
+	suite := pairing.NewSuiteBn256()
+	vf := func(msg []byte, data []byte) error {
 
-			localSigCorrect := sigAlg.Verify(block.ID.PublicKey, encodedLat, latency.SignedLatency)
-			if !localSigCorrect {
-				return errors.New("Incorrect local signature")
-			}
+		snapshot, err := latencyprotocol.DecodeSnapshot(data, suite)
+		if err != nil {
+			return err
+		}
 
+		hash, err := snapshot.Hash()
+		if err != nil || !bytes.Equal(hash, msg) {
+			return errors.New("Message is not the hash of the snapshot")
 		}
-		return nil
 
+		return chain.VerifySnapshot(snapshot)
 	}
-	return NewProtocol(n, vf, pairing.NewSuiteBn256())
+	return NewProtocol(n, vf, suite)
 }
 
 // NewDefaultProtocol is the default protocol function used for registration
 // with an always-true verification.
 func NewDefaultProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
-	vf := func(a []byte) error { return nil }
+	vf := func(msg []byte, data []byte) error { return nil }
 	return NewProtocol(n, vf, pairing.NewSuiteBn256())
 }
 
@@ -106,6 +114,7 @@ func NewProtocol(node *onet.TreeNodeInstance, vf VerificationFn, suite *pairing.
 		suite:            suite,
 		vf:               vf,
 		done:             make(chan bool),
+		started:          make(chan bool),
 		FinalSignature:   make(chan []byte, 1),
 	}
 
@@ -116,43 +125,57 @@ func NewProtocol(node *onet.TreeNodeInstance, vf VerificationFn, suite *pairing.
 
 // Dispatch will listen on the four channels we use (i.e. four steps)
 func (c *SimpleBLSCoSi) Dispatch() error {
-	nbrChild := len(c.Children())
 	if !c.IsRoot() {
 		log.Lvl3(c.ServerIdentity(), "waiting for prepare")
-		prep := (<-c.prepare).SimplePrepare
-		err := c.handlePrepare(&prep)
-		if err != nil {
-			return err
+		select {
+		case prep := <-c.prepare:
+			err := c.handlePrepare(&prep.SimplePrepare)
+			if err != nil {
+				return err
+			}
+		case commit := <-c.commit:
+			//the root gave up on the round before this node got the prepare
+			return c.handleCommit(&commit.SimpleCommit)
+		}
+	}
+	if c.IsRoot() {
+		// the root only knows the configuration of the round, and when to time out, once it is started
+		select {
+		case <-c.started:
+		case <-c.done:
+			return nil
 		}
 	}
 	if !c.IsLeaf() {
-		var buf []*SimplePrepareReply
-		for i := 0; i < nbrChild; i++ {
-			reply := <-c.prepareReply
-			log.Lvlf3("%s collecting prepare replies %d/%d", c.ServerIdentity(), i, nbrChild)
-			buf = append(buf, &reply.SimplePrepareReply)
+		replies := c.collectPrepareReplies()
+		if c.finished() {
+			return nil
 		}
-		err := c.handlePrepareReplies(buf)
+		err := c.handlePrepareReplies(replies)
 		if err != nil {
 			return err
 		}
 	}
 	if !c.IsRoot() {
 		log.Lvl3(c.ServerIdentity(), "waiting for commit")
-		commit := (<-c.commit).SimpleCommit
-		err := c.handleCommit(&commit)
-		if err != nil {
-			return err
+		select {
+		case commit := <-c.commit:
+			err := c.handleCommit(&commit.SimpleCommit)
+			if err != nil {
+				return err
+			}
+		case <-time.After(2 * c.Timeout * time.Duration(treeHeight(c.Root()))):
+			log.Lvl2(c.ServerIdentity(), "did not get the commit in time")
+			c.finish()
+			return nil
 		}
 	}
-	if !c.IsLeaf() {
-		var buf []*SimpleCommitReply
-		for i := 0; i < nbrChild; i++ {
-			commitReply := <-c.commitReply
-			log.Lvlf3("%s handling commitReply of child %d/%d", c.ServerIdentity(), i, nbrChild)
-			buf = append(buf, &commitReply.SimpleCommitReply)
+	if !c.IsLeaf() && !c.finished() {
+		replies := c.collectCommitReplies()
+		if c.finished() {
+			return nil
 		}
-		err := c.handleCommitReplies(buf)
+		err := c.handleCommitReplies(replies)
 		if err != nil {
 			return err
 		}
@@ -162,9 +185,18 @@ func (c *SimpleBLSCoSi) Dispatch() error {
 }
 
 // Start will call the announcement function of its inner Round structure. It
-// will pass nil as *in* message.
+// will pass nil as *in* message. Dispatch waits for it before collecting the replies.
 func (c *SimpleBLSCoSi) Start() error {
-	out := &SimplePrepare{c.Message}
+	defer close(c.started)
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	out := &SimplePrepare{
+		Message:   c.Message,
+		Data:      c.Data,
+		Threshold: c.Threshold,
+		Timeout:   c.Timeout,
+	}
 	return c.handlePrepare(out)
 }
 
@@ -172,19 +204,56 @@ func (c *SimpleBLSCoSi) Start() error {
 // output. If in == nil, we are root and we start the round.
 func (c *SimpleBLSCoSi) handlePrepare(in *SimplePrepare) error {
 	c.Message = in.Message
+	c.Data = in.Data
+	c.Threshold = in.Threshold
+	c.Timeout = in.Timeout
 	log.Lvlf3("%s prepare message: %x", c.ServerIdentity(), c.Message)
 
-	// if we are leaf, we should go to prepare-reply
-	if c.IsLeaf() {
-		// do the verification
-		if err := c.vf(c.Message); err != nil {
-			log.Error(c.ServerIdentity(), "verification function failed with error: ", err)
+	// every node signs the message, so every node has to verify it
+	if err := c.vf(c.Message, c.Data); err != nil {
+		log.Error(c.ServerIdentity(), "verification function failed with error: ", err)
+		if c.IsRoot() {
+			//no other node got the message yet, so the round only has to end at the root
+			c.FinalSignature <- nil
+			c.finish()
 			return err
 		}
+		// the node does not sign, but tells the root why and still relays the replies of its children
+		refusal, err := newRefusal(c.suite, c.Private(), c.TreeNode().RosterIndex, c.Message, err)
+		if err != nil {
+			return err
+		}
+		c.refusal = refusal
+	}
+
+	// if we are leaf, we should go to prepare-reply
+	if c.IsLeaf() {
 		return c.handlePrepareReplies(nil)
 	}
 	// send to children
-	return c.SendToChildren(in)
+	return c.sendToChildren(in)
+}
+
+// collectPrepareReplies waits for the prepare replies of the children. The children who do not
+// reply in time are left out.
+func (c *SimpleBLSCoSi) collectPrepareReplies() []*SimplePrepareReply {
+	nbrChild := len(c.Children())
+	timeout := time.After(c.Timeout * time.Duration(treeHeight(c.TreeNode())))
+
+	var buf []*SimplePrepareReply
+	for i := 0; i < nbrChild; i++ {
+		select {
+		case reply := <-c.prepareReply:
+			log.Lvlf3("%s collecting prepare replies %d/%d", c.ServerIdentity(), i, nbrChild)
+			buf = append(buf, &reply.SimplePrepareReply)
+		case <-timeout:
+			log.Lvlf2("%s got %d/%d prepare replies in time", c.ServerIdentity(), i, nbrChild)
+			return buf
+		case <-c.done:
+			return buf
+		}
+	}
+	return buf
 }
 
 // handleAllCommitment relay the commitments up in the tree
@@ -194,12 +263,18 @@ func (c *SimpleBLSCoSi) handlePrepareReplies(replies []*SimplePrepareReply) erro
 	log.Lvl3(c.ServerIdentity(), "aggregated")
 
 	// combine the signatures from the replies
-	mySig, err := bls.Sign(c.suite, c.Private(), c.Message)
-	if err != nil {
-		log.Error(c.ServerIdentity(), err)
-		return err
+	sigs := make([][]byte, 0, len(replies)+1)
+	masks := make([][]byte, 0, len(replies))
+	refusals := make([]Refusal, 0)
+	for _, reply := range replies {
+		sigs = append(sigs, reply.Sig)
+		masks = append(masks, reply.Mask)
+		refusals = append(refusals, reply.Refusals...)
+	}
+	if c.refusal != nil {
+		refusals = append(refusals, *c.refusal)
 	}
-	sigBuf, err := bls.AggregateSignatures(c.suite, append(prepareRepliesToSigs(replies), mySig)...)
+	sigBuf, mask, err := c.aggregate(sigs, masks)
 	if err != nil {
 		log.Error(c.ServerIdentity(), err)
 		return err
@@ -207,8 +282,16 @@ func (c *SimpleBLSCoSi) handlePrepareReplies(replies []*SimplePrepareReply) erro
 
 	// if we are the root, we need to start the commit phase
 	if c.IsRoot() {
+		// the refusals are relayed by the other nodes, only the ones signed by the refusing nodes are kept
+		c.Refusals = verifiedRefusals(c.suite, c.Publics(), c.Message, refusals)
+		if err := c.checkParticipation(mask); err != nil {
+			log.Error(c.ServerIdentity(), err)
+			c.abort()
+			return err
+		}
 		out := &SimpleCommit{
 			AggrSig: sigBuf,
+			Mask:    mask,
 		}
 		log.Lvlf3("%s starting commit (message = %x)", c.ServerIdentity(), c.Message)
 		return c.handleCommit(out)
@@ -216,7 +299,9 @@ func (c *SimpleBLSCoSi) handlePrepareReplies(replies []*SimplePrepareReply) erro
 
 	// otherwise send it to parent
 	outMsg := &SimplePrepareReply{
-		Sig: sigBuf,
+		Sig:      sigBuf,
+		Mask:     mask,
+		Refusals: refusals,
 	}
 	return c.SendTo(c.Parent(), outMsg)
 }
@@ -226,9 +311,21 @@ func (c *SimpleBLSCoSi) handlePrepareReplies(replies []*SimplePrepareReply) erro
 func (c *SimpleBLSCoSi) handleCommit(in *SimpleCommit) error {
 	log.Lvlf3("%s handling commit", c.ServerIdentity())
 
-	// check that the commit is correct with respect to the aggregate key
-	pk := bls.AggregatePublicKeys(c.suite, c.Publics()...)
-	err := bls.Verify(c.suite, pk, c.Message, in.AggrSig)
+	// the root gave up on the round
+	if len(in.AggrSig) == 0 {
+		log.Lvl2(c.ServerIdentity(), "round aborted by the root")
+		c.sendToChildren(in)
+		c.finish()
+		return nil
+	}
+
+	// check that the commit is correct with respect to the aggregate key of the signers
+	pk, err := aggregateMaskedPublics(c.suite, c.Publics(), in.Mask)
+	if err != nil {
+		log.Error(c.ServerIdentity(), "commit verification failed with error: ", err.Error())
+		return err
+	}
+	err = bls.Verify(c.suite, pk, c.Message, in.AggrSig)
 	if err != nil {
 		log.Error(c.ServerIdentity(), "commit verification failed with error: ", err.Error())
 		return err
@@ -240,32 +337,53 @@ func (c *SimpleBLSCoSi) handleCommit(in *SimpleCommit) error {
 	}
 
 	// otherwise send it to children
-	return c.SendToChildren(in)
+	return c.sendToChildren(in)
+}
+
+// collectCommitReplies waits for the commit replies of the children. The children who do not
+// reply in time are left out.
+func (c *SimpleBLSCoSi) collectCommitReplies() []*SimpleCommitReply {
+	nbrChild := len(c.Children())
+	timeout := time.After(c.Timeout * time.Duration(treeHeight(c.TreeNode())))
+
+	var buf []*SimpleCommitReply
+	for i := 0; i < nbrChild; i++ {
+		select {
+		case commitReply := <-c.commitReply:
+			log.Lvlf3("%s handling commitReply of child %d/%d", c.ServerIdentity(), i, nbrChild)
+			buf = append(buf, &commitReply.SimpleCommitReply)
+		case <-timeout:
+			log.Lvlf2("%s got %d/%d commit replies in time", c.ServerIdentity(), i, nbrChild)
+			return buf
+		case <-c.done:
+			return buf
+		}
+	}
+	return buf
 }
 
 // handleCommitReplies brings up the commitReply of each node in the tree to the root.
 func (c *SimpleBLSCoSi) handleCommitReplies(replies []*SimpleCommitReply) error {
 
-	defer func() {
-		// protocol is finished
-		close(c.done)
-		c.Done()
-	}()
+	defer c.finish()
 
 	log.Lvl3(c.ServerIdentity(), "aggregated")
 
 	// combine the signatures from the replies and my own signature
-	mySig, err := bls.Sign(c.suite, c.Private(), c.Message)
-	if err != nil {
-		return err
+	sigs := make([][]byte, 0, len(replies)+1)
+	masks := make([][]byte, 0, len(replies))
+	for _, reply := range replies {
+		sigs = append(sigs, reply.Sig)
+		masks = append(masks, reply.Mask)
 	}
-	sigBuf, err := bls.AggregateSignatures(c.suite, append(commitRepliesToSigs(replies), mySig)...)
+	sigBuf, mask, err := c.aggregate(sigs, masks)
 	if err != nil {
 		return err
 	}
 
 	out := &SimpleCommitReply{
-		Sig: sigBuf,
+		Sig:  sigBuf,
+		Mask: mask,
 	}
 
 	// send it back to parent
@@ -275,22 +393,117 @@ func (c *SimpleBLSCoSi) handleCommitReplies(replies []*SimpleCommitReply) error
 
 	// send it to the output channel
 	log.Lvl2(c.ServerIdentity(), "sending the final signature to channel")
-	c.FinalSignature <- sigBuf
+	if !c.thresholdMode() {
+		c.FinalSignature <- sigBuf
+		return nil
+	}
+
+	if err := c.checkParticipation(mask); err != nil {
+		log.Error(c.ServerIdentity(), err)
+		c.FinalSignature <- nil
+		return err
+	}
+	c.FinalSignature <- append(sigBuf, mask...)
 	return nil
 }
 
-func commitRepliesToSigs(replies []*SimpleCommitReply) [][]byte {
-	sigs := make([][]byte, len(replies))
-	for i, reply := range replies {
-		sigs[i] = reply.Sig
+// aggregate combines the signatures and masks of the children with the signature of the node,
+// unless the node refused to sign
+func (c *SimpleBLSCoSi) aggregate(sigs [][]byte, masks [][]byte) ([]byte, []byte, error) {
+	mask := newMask(len(c.Publics()))
+	for _, childMask := range masks {
+		combineMasks(mask, childMask)
 	}
-	return sigs
+
+	if c.refusal == nil {
+		mySig, err := bls.Sign(c.suite, c.Private(), c.Message)
+		if err != nil {
+			return nil, nil, err
+		}
+		sigs = append(sigs, mySig)
+		setBit(mask, c.TreeNode().RosterIndex)
+	}
+
+	nonEmpty := make([][]byte, 0, len(sigs))
+	for _, sig := range sigs {
+		if len(sig) > 0 {
+			nonEmpty = append(nonEmpty, sig)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return nil, mask, nil
+	}
+
+	sigBuf, err := bls.AggregateSignatures(c.suite, nonEmpty...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sigBuf, mask, nil
+}
+
+// checkParticipation makes sure enough nodes signed: all of them by default, Threshold of them in threshold mode
+func (c *SimpleBLSCoSi) checkParticipation(mask []byte) error {
+	if !c.thresholdMode() {
+		if countEnabled(mask) < len(c.Publics()) {
+			return errors.New("Not all nodes signed: " + strconv.Itoa(len(c.Refusals)) + " refusals")
+		}
+		return nil
+	}
+	if countEnabled(mask) < c.Threshold {
+		return errors.New("Not enough signers to reach the threshold")
+	}
+	return nil
+}
+
+// abort is called by the root to give up on the round: the caller gets a nil signature and
+// the other nodes are told to stop
+func (c *SimpleBLSCoSi) abort() {
+	c.FinalSignature <- nil
+	c.sendToChildren(&SimpleCommit{})
+	c.finish()
+}
+
+// sendToChildren sends a message to all the children. In threshold mode, children who
+// cannot be reached are simply left out of the round.
+func (c *SimpleBLSCoSi) sendToChildren(msg interface{}) error {
+	if !c.thresholdMode() {
+		return c.SendToChildren(msg)
+	}
+	for _, err := range c.SendToChildrenInParallel(msg) {
+		log.Lvl2(c.ServerIdentity(), "could not reach child:", err)
+	}
+	return nil
+}
+
+func (c *SimpleBLSCoSi) thresholdMode() bool {
+	return c.Threshold > 0
 }
 
-func prepareRepliesToSigs(replies []*SimplePrepareReply) [][]byte {
-	sigs := make([][]byte, len(replies))
-	for i, reply := range replies {
-		sigs[i] = reply.Sig
+// finish ends the protocol for this node, it can be called more than once
+func (c *SimpleBLSCoSi) finish() {
+	c.finishOnce.Do(func() {
+		close(c.done)
+		c.Done()
+	})
+}
+
+// finished tells whether the protocol ended for this node
+func (c *SimpleBLSCoSi) finished() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// treeHeight returns the number of levels below a node in the tree
+func treeHeight(node *onet.TreeNode) int {
+	height := 0
+	for _, child := range node.Children {
+		if childHeight := treeHeight(child) + 1; childHeight > height {
+			height = childHeight
+		}
 	}
-	return sigs
+	return height
 }
@@ -5,18 +5,53 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/pairing"
 	"go.dedis.ch/kyber/v3/sign/bls"
+	"go.dedis.ch/kyber/v3/util/random"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 )
 
 const protoName = "testProtocol"
+const thresholdProtoName = "thresholdTestProtocol"
 
 var testSuite = pairing.NewSuiteBn256()
 
 func testProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
-	vf := func(a []byte) error { return nil }
+	vf := func(msg []byte, data []byte) error { return nil }
+	return NewProtocol(n, vf, testSuite)
+}
+
+// refusingNodes and slowNodes give the roster indices of the nodes refusing to sign
+// or answering too late in thresholdTestProtocol
+var refusingNodes map[int]bool
+var slowNodes map[int]bool
+
+// testRejection is the error of nodes refusing to sign in thresholdTestProtocol
+type testRejection struct{}
+
+func (r *testRejection) Error() string {
+	return "refusing to sign"
+}
+
+func (r *testRejection) ReasonCode() int {
+	return testReasonCode
+}
+
+const testReasonCode = 42
+
+func thresholdTestProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	vf := func(msg []byte, data []byte) error {
+		index := n.TreeNode().RosterIndex
+		if slowNodes[index] {
+			time.Sleep(2 * time.Second)
+		}
+		if refusingNodes[index] {
+			return &testRejection{}
+		}
+		return nil
+	}
 	return NewProtocol(n, vf, testSuite)
 }
 
@@ -24,6 +59,9 @@ func init() {
 	if _, err := onet.GlobalProtocolRegister(protoName, testProtocol); err != nil {
 		panic(err)
 	}
+	if _, err := onet.GlobalProtocolRegister(thresholdProtoName, thresholdTestProtocol); err != nil {
+		panic(err)
+	}
 }
 
 func TestMain(m *testing.M) {
@@ -69,3 +107,206 @@ func TestCosi(t *testing.T) {
 		local.CloseAll()
 	}
 }
+
+func TestThresholdCosi(t *testing.T) {
+
+	tests := []struct {
+		refusing map[int]bool
+		slow     map[int]bool
+		success  bool
+	}{
+		{map[int]bool{}, map[int]bool{}, true},
+		{map[int]bool{3: true}, map[int]bool{}, true},
+		{map[int]bool{1: true}, map[int]bool{6: true}, true},
+		{map[int]bool{2: true, 4: true, 5: true}, map[int]bool{}, false},
+	}
+
+	nbrHosts := 7
+	quorum := DefaultQuorum(nbrHosts)
+	msg := []byte("Hello World Cosi")
+
+	for _, test := range tests {
+		runThresholdCosi(t, nbrHosts, quorum, msg, test.refusing, test.slow, test.success)
+	}
+}
+
+// runThresholdCosi runs a round in threshold mode, closing the nodes even when an assertion fails
+func runThresholdCosi(t *testing.T, nbrHosts int, quorum int, msg []byte, refusing map[int]bool, slow map[int]bool, success bool) {
+	refusingNodes = refusing
+	slowNodes = slow
+
+	local := onet.NewLocalTest(testSuite)
+	_, el, tree := local.GenTree(nbrHosts, true)
+	defer local.CloseAll()
+
+	p, err := local.CreateProtocol(thresholdProtoName, tree)
+	require.NoError(t, err)
+
+	root := p.(*SimpleBLSCoSi)
+	root.Message = msg
+	root.Threshold = quorum
+	root.Timeout = 200 * time.Millisecond
+	go root.Start()
+
+	select {
+	case sig := <-root.FinalSignature:
+		checkRefusals(t, root, el.Publics(), msg, refusing)
+		if sig == nil {
+			require.False(t, success, "Round aborted")
+			return
+		}
+		require.True(t, success, "Got a signature without a quorum")
+		require.NoError(t, Verify(testSuite, el.Publics(), msg, sig, quorum))
+
+		//the signature only holds for all the nodes if they all signed
+		if len(refusing)+len(slow) > 0 {
+			require.Error(t, Verify(testSuite, el.Publics(), msg, sig, nbrHosts))
+		} else {
+			require.NoError(t, Verify(testSuite, el.Publics(), msg, sig, nbrHosts))
+		}
+
+		_, mask, err := SplitSignature(sig, nbrHosts)
+		require.NoError(t, err)
+		signers := Signers(mask, nbrHosts)
+		require.Equal(t, nbrHosts-len(refusing)-len(slow), len(signers))
+		for _, signer := range signers {
+			require.False(t, refusing[signer] || slow[signer])
+		}
+	case <-time.After(time.Second * 2):
+		require.False(t, success, "Could not get signature verification done in time")
+	}
+}
+
+func TestCosiSilentChild(t *testing.T) {
+	refusingNodes = map[int]bool{}
+	slowNodes = map[int]bool{3: true}
+
+	local := onet.NewLocalTest(testSuite)
+	_, _, tree := local.GenTree(7, true)
+	defer local.CloseAll()
+
+	p, err := local.CreateProtocol(thresholdProtoName, tree)
+	require.NoError(t, err)
+
+	//without threshold every node has to sign, so the round fails once the silent node timed out
+	root := p.(*SimpleBLSCoSi)
+	root.Message = []byte("Hello World Cosi")
+	root.Timeout = 200 * time.Millisecond
+	go root.Start()
+
+	select {
+	case sig := <-root.FinalSignature:
+		require.Nil(t, sig)
+	case <-time.After(time.Second * 2):
+		t.Fatal("Round blocked on the silent node")
+	}
+}
+
+func TestCosiRefusal(t *testing.T) {
+	refusingNodes = map[int]bool{4: true}
+	slowNodes = map[int]bool{}
+
+	local := onet.NewLocalTest(testSuite)
+	_, el, tree := local.GenTree(7, true)
+	defer local.CloseAll()
+
+	msg := []byte("Hello World Cosi")
+
+	p, err := local.CreateProtocol(thresholdProtoName, tree)
+	require.NoError(t, err)
+
+	root := p.(*SimpleBLSCoSi)
+	root.Message = msg
+	go root.Start()
+
+	select {
+	case sig := <-root.FinalSignature:
+		require.Nil(t, sig)
+		checkRefusals(t, root, el.Publics(), msg, refusingNodes)
+	case <-time.After(time.Second * 2):
+		t.Fatal("Root did not learn about the refusal in time")
+	}
+}
+
+func TestCosiRootRefusal(t *testing.T) {
+	refusingNodes = map[int]bool{0: true}
+	slowNodes = map[int]bool{}
+
+	local := onet.NewLocalTest(testSuite)
+	_, _, tree := local.GenTree(7, true)
+	defer local.CloseAll()
+
+	p, err := local.CreateProtocol(thresholdProtoName, tree)
+	require.NoError(t, err)
+
+	root := p.(*SimpleBLSCoSi)
+	root.Message = []byte("Hello World Cosi")
+	require.Error(t, root.Start())
+
+	//the round ends at once, without waiting for the children who never got the message
+	select {
+	case sig := <-root.FinalSignature:
+		require.Nil(t, sig)
+	case <-time.After(time.Second):
+		t.Fatal("Root did not end the round")
+	}
+	select {
+	case <-root.done:
+	case <-time.After(time.Second):
+		t.Fatal("Root did not finish")
+	}
+}
+
+// checkRefusals makes sure the root got a valid refusal from each refusing node
+func checkRefusals(t *testing.T, root *SimpleBLSCoSi, publics []kyber.Point, msg []byte, refusing map[int]bool) {
+	require.Equal(t, len(refusing), len(root.Refusals))
+	for _, refusal := range root.Refusals {
+		require.True(t, refusing[refusal.Index])
+		require.Equal(t, testReasonCode, refusal.Reason)
+		require.NoError(t, refusal.Verify(testSuite, publics, msg))
+		require.Error(t, refusal.Verify(testSuite, publics, []byte("other message")))
+	}
+}
+
+func TestVerifiedRefusals(t *testing.T) {
+	msg := []byte("Hello World Cosi")
+
+	privates := make([]kyber.Scalar, 3)
+	publics := make([]kyber.Point, 3)
+	for i := range privates {
+		privates[i], publics[i] = bls.NewKeyPair(testSuite, random.New())
+	}
+
+	refusal, err := newRefusal(testSuite, privates[1], 1, msg, &testRejection{})
+	require.NoError(t, err)
+
+	//node 2 relays a refusal of node 0 it signed itself
+	forged, err := newRefusal(testSuite, privates[2], 0, msg, &testRejection{})
+	require.NoError(t, err)
+
+	verified := verifiedRefusals(testSuite, publics, msg, []Refusal{*refusal, *forged, *refusal})
+	require.Equal(t, 1, len(verified))
+	require.Equal(t, 1, verified[0].Index)
+
+	require.Empty(t, verifiedRefusals(testSuite, publics, []byte("other message"), []Refusal{*refusal}))
+}
+
+func TestMask(t *testing.T) {
+	mask := newMask(10)
+	require.Equal(t, 2, len(mask))
+
+	setBit(mask, 0)
+	setBit(mask, 9)
+	require.Equal(t, 2, countEnabled(mask))
+	require.Equal(t, []int{0, 9}, Signers(mask, 10))
+
+	other := newMask(10)
+	setBit(other, 3)
+	setBit(other, 9)
+	combineMasks(mask, other)
+	require.Equal(t, []int{0, 3, 9}, Signers(mask, 10))
+
+	require.Equal(t, 5, DefaultQuorum(7))
+	require.Equal(t, 3, DefaultQuorum(4))
+	require.Equal(t, 1, DefaultQuorum(1))
+}
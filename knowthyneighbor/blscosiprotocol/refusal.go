@@ -0,0 +1,87 @@
+package blscosiprotocol
+
+import (
+	"errors"
+	"strconv"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/pairing"
+	"go.dedis.ch/kyber/v3/sign/bls"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+)
+
+// reasonCoder is implemented by the errors of verification functions giving a reason code for their refusal
+type reasonCoder interface {
+	ReasonCode() int
+}
+
+// refusalContent is what a refusing node signs, so that its refusal cannot be reused for another message
+type refusalContent struct {
+	Message     []byte
+	Reason      int
+	Description string
+}
+
+// newRefusal creates the signed refusal of a node for the error returned by its verification function
+func newRefusal(suite *pairing.SuiteBn256, private kyber.Scalar, index int, msg []byte, err error) (*Refusal, error) {
+	reason := 0
+	if coder, ok := err.(reasonCoder); ok {
+		reason = coder.ReasonCode()
+	}
+
+	content, encodeErr := protobuf.Encode(&refusalContent{msg, reason, err.Error()})
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+
+	sig, signErr := bls.Sign(suite, private, content)
+	if signErr != nil {
+		return nil, signErr
+	}
+
+	return &Refusal{
+		Index:       index,
+		Reason:      reason,
+		Description: err.Error(),
+		Signature:   sig,
+	}, nil
+}
+
+// Verify checks that the refusal was signed by the refusing node for the given message
+func (r *Refusal) Verify(suite *pairing.SuiteBn256, publics []kyber.Point, msg []byte) error {
+	if r.Index < 0 || r.Index >= len(publics) {
+		return errors.New("Refusal from unknown node")
+	}
+
+	content, err := protobuf.Encode(&refusalContent{msg, r.Reason, r.Description})
+	if err != nil {
+		return err
+	}
+
+	return bls.Verify(suite, publics[r.Index], content, r.Signature)
+}
+
+// verifiedRefusals keeps the refusals signed by the nodes they are attributed to, once per node, so that a node
+// cannot refuse in the name of another one
+func verifiedRefusals(suite *pairing.SuiteBn256, publics []kyber.Point, msg []byte, refusals []Refusal) []Refusal {
+	verified := make([]Refusal, 0, len(refusals))
+	seen := make(map[int]bool)
+	for _, refusal := range refusals {
+		if seen[refusal.Index] {
+			continue
+		}
+		if err := refusal.Verify(suite, publics, msg); err != nil {
+			log.Lvl2("dropping invalid refusal of node", refusal.Index, ":", err)
+			continue
+		}
+		seen[refusal.Index] = true
+		verified = append(verified, refusal)
+	}
+	return verified
+}
+
+// String returns a readable description of the refusal
+func (r *Refusal) String() string {
+	return "node " + strconv.Itoa(r.Index) + " refused (reason " + strconv.Itoa(r.Reason) + "): " + r.Description
+}
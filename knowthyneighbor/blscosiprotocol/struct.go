@@ -1,6 +1,8 @@
 package blscosiprotocol
 
 import (
+	"time"
+
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/network"
 )
@@ -14,6 +16,11 @@ func init() {
 // SimplePrepare is used to pass a message which all the nodes should vote on.
 type SimplePrepare struct {
 	Message []byte
+	// Data helps the nodes verify the message, it is not signed
+	Data []byte
+	// Threshold and Timeout configure the threshold mode (see SimpleBLSCoSi)
+	Threshold int
+	Timeout   time.Duration
 }
 
 // prepareChan wraps SimplePrepare for onet.
@@ -25,6 +32,21 @@ type prepareChan struct {
 // SimplePrepareReply is the signature or aggregate signature on the message.
 type SimplePrepareReply struct {
 	Sig []byte
+	// Mask indicates which nodes of the subtree signed
+	Mask []byte
+	// Refusals are the refusals of the nodes of the subtree who did not sign
+	Refusals []Refusal
+}
+
+// Refusal is sent up the tree by a node whose verification function rejected the message.
+type Refusal struct {
+	// Index is the index of the refusing node in the roster
+	Index int
+	// Reason is the code of the reason given by the verification function
+	Reason      int
+	Description string
+	// Signature is the signature of the refusing node on the message, reason and description
+	Signature []byte
 }
 
 // prepareReplyChan wraps SimplePrepareReply for onet.
@@ -38,6 +60,7 @@ type prepareReplyChan struct {
 // SimpleCommit is to commit the (hashed) prepared message.
 type SimpleCommit struct {
 	AggrSig []byte
+	Mask    []byte
 }
 
 // commitChan wraps SimpleCommit for onet.
@@ -48,7 +71,8 @@ type commitChan struct {
 
 // SimpleCommitReply is the (aggregate) signature for the commit message.
 type SimpleCommitReply struct {
-	Sig []byte
+	Sig  []byte
+	Mask []byte
 }
 
 // commitReplyChan wraps SimpleCommitReply for onet.
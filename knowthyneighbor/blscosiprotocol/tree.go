@@ -0,0 +1,124 @@
+package blscosiprotocol
+
+import (
+	"time"
+
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// GenerateLatencyTree builds a signing tree rooted at root using the latencies recorded in the chain.
+// Nodes are attached one by one, each time picking the node and parent (with less than branchingFactor
+// children) that reach the root the fastest, so that close nodes end up under the same parent and the
+// critical path of the tree stays short.
+// If the chain does not know any latency between the members of the roster, the usual n-ary tree is returned.
+func GenerateLatencyTree(roster *onet.Roster, root *network.ServerIdentity, chain *latencyprotocol.Chain, branchingFactor int) *onet.Tree {
+	rootIndex, _ := roster.Search(root.ID)
+	if chain == nil || rootIndex < 0 || branchingFactor < 1 {
+		return roster.GenerateNaryTreeWithRoot(branchingFactor, root)
+	}
+
+	latencies, known := latencyMatrix(roster, chain)
+	if !known {
+		return roster.GenerateNaryTreeWithRoot(branchingFactor, root)
+	}
+
+	N := len(roster.List)
+
+	treeNodes := make([]*onet.TreeNode, N)
+	arrival := make([]time.Duration, N)
+
+	treeNodes[rootIndex] = onet.NewTreeNode(rootIndex, roster.List[rootIndex])
+
+	for attached := 1; attached < N; attached++ {
+		bestParent, bestChild := -1, -1
+		var bestArrival time.Duration
+
+		for child := 0; child < N; child++ {
+			if treeNodes[child] != nil {
+				continue
+			}
+			for parent := 0; parent < N; parent++ {
+				if treeNodes[parent] == nil || len(treeNodes[parent].Children) >= branchingFactor {
+					continue
+				}
+				childArrival := arrival[parent] + latencies[parent][child]
+				if bestChild < 0 || childArrival < bestArrival {
+					bestParent, bestChild, bestArrival = parent, child, childArrival
+				}
+			}
+		}
+
+		treeNodes[bestChild] = onet.NewTreeNode(bestChild, roster.List[bestChild])
+		treeNodes[bestParent].AddChild(treeNodes[bestChild])
+		arrival[bestChild] = bestArrival
+	}
+
+	return onet.NewTree(roster, treeNodes[rootIndex])
+}
+
+// CriticalPath returns the largest latency between the root of a tree and one of its leaves,
+// according to the latencies recorded in the chain
+func CriticalPath(tree *onet.Tree, chain *latencyprotocol.Chain) time.Duration {
+	critical := time.Duration(0)
+	for _, treeNode := range tree.List() {
+		if treeNode.IsLeaf() {
+			if path := pathLatency(treeNode, chain); path > critical {
+				critical = path
+			}
+		}
+	}
+	return critical
+}
+
+// pathLatency sums the latencies of the links between a tree node and the root of its tree.
+// Links whose latency the chain does not know are counted as zero.
+func pathLatency(treeNode *onet.TreeNode, chain *latencyprotocol.Chain) time.Duration {
+	path := time.Duration(0)
+	for current := treeNode; current.Parent != nil; current = current.Parent {
+		latency, _ := chain.LatencyBetween(current.ServerIdentity, current.Parent.ServerIdentity)
+		path += latency
+	}
+	return path
+}
+
+// latencyMatrix gives the latency between every pair of roster members. Unknown latencies are set
+// to the largest known latency so that such links are only used when nothing better is available.
+func latencyMatrix(roster *onet.Roster, chain *latencyprotocol.Chain) ([][]time.Duration, bool) {
+	N := len(roster.List)
+
+	latencies := make([][]time.Duration, N)
+	isKnown := make([][]bool, N)
+	maxLatency := time.Duration(0)
+	known := false
+
+	for i := 0; i < N; i++ {
+		latencies[i] = make([]time.Duration, N)
+		isKnown[i] = make([]bool, N)
+		for j := 0; j < N; j++ {
+			if i == j {
+				continue
+			}
+			latency, here := chain.LatencyBetween(roster.List[i], roster.List[j])
+			if here {
+				latencies[i][j] = latency
+				isKnown[i][j] = true
+				known = true
+				if latency > maxLatency {
+					maxLatency = latency
+				}
+			}
+		}
+	}
+
+	for i := 0; i < N; i++ {
+		for j := 0; j < N; j++ {
+			if i != j && !isKnown[i][j] {
+				latencies[i][j] = maxLatency
+			}
+		}
+	}
+
+	return latencies, known
+}
@@ -0,0 +1,144 @@
+package blscosiprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+const latencyProtoName = "latencyTestProtocol"
+
+// benchChain holds the latencies simulated by latencyTestProtocol
+var benchChain *latencyprotocol.Chain
+
+// latencyTestProtocol simulates the link latencies of the tree: every leaf waits as long as
+// a message would take to travel to it from the root before signing
+func latencyTestProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	vf := func(msg []byte, data []byte) error {
+		time.Sleep(pathLatency(n.TreeNode(), benchChain))
+		return nil
+	}
+	return NewProtocol(n, vf, testSuite)
+}
+
+func init() {
+	if _, err := onet.GlobalProtocolRegister(latencyProtoName, latencyTestProtocol); err != nil {
+		panic(err)
+	}
+}
+
+// clusteredChain creates a chain for the roster in which the members alternate between two clusters,
+// so that the roster order is the worst possible order to build a tree in
+func clusteredChain(roster *onet.Roster) *latencyprotocol.Chain {
+	N := len(roster.List)
+
+	nodeIDs := make([]*latencyprotocol.NodeID, N)
+	for i := 0; i < N; i++ {
+		pub, _, err := sigAlg.GenerateKey(nil)
+		if err != nil {
+			return nil
+		}
+		nodeIDs[i] = &latencyprotocol.NodeID{ServerID: roster.List[i], PublicKey: pub}
+	}
+
+	chain := &latencyprotocol.Chain{Blocks: make([]*latencyprotocol.Block, N), BucketName: []byte("testBucket")}
+
+	for i := 0; i < N; i++ {
+		latencies := make(map[string]latencyprotocol.ConfirmedLatency)
+		for j := 0; j < N; j++ {
+			if i != j {
+				latency := time.Millisecond
+				if i%2 != j%2 {
+					latency = 20 * time.Millisecond
+				}
+				latencies[string(nodeIDs[j].PublicKey)] = latencyprotocol.ConfirmedLatency{Latency: latency, Timestamp: time.Now()}
+			}
+		}
+		chain.Blocks[i] = &latencyprotocol.Block{ID: nodeIDs[i], Latencies: latencies}
+	}
+
+	return chain
+}
+
+func TestLatencyTree(t *testing.T) {
+	local := onet.NewLocalTest(testSuite)
+	_, el, _ := local.GenTree(8, false)
+	defer local.CloseAll()
+
+	chain := clusteredChain(el)
+
+	naiveTree := el.GenerateNaryTreeWithRoot(2, el.List[0])
+	latencyTree := GenerateLatencyTree(el, el.List[0], chain, 2)
+
+	require.Equal(t, len(el.List), len(latencyTree.List()))
+	require.True(t, latencyTree.Root.ServerIdentity.Equal(el.List[0]))
+
+	for _, treeNode := range latencyTree.List() {
+		require.True(t, len(treeNode.Children) <= 2)
+	}
+
+	require.True(t, CriticalPath(latencyTree, chain) < CriticalPath(naiveTree, chain))
+}
+
+func TestLatencyTreeWithoutLatencies(t *testing.T) {
+	local := onet.NewLocalTest(testSuite)
+	_, el, _ := local.GenTree(5, false)
+	defer local.CloseAll()
+
+	emptyChain := &latencyprotocol.Chain{Blocks: make([]*latencyprotocol.Block, 0), BucketName: []byte("testBucket")}
+
+	tree := GenerateLatencyTree(el, el.List[0], emptyChain, 2)
+
+	require.Equal(t, len(el.List), len(tree.List()))
+	require.True(t, tree.Root.ServerIdentity.Equal(el.List[0]))
+}
+
+func BenchmarkSigningRoundNaiveTree(b *testing.B) {
+	benchmarkSigningRound(b, false)
+}
+
+func BenchmarkSigningRoundLatencyTree(b *testing.B) {
+	benchmarkSigningRound(b, true)
+}
+
+func benchmarkSigningRound(b *testing.B, latencyOptimised bool) {
+	local := onet.NewLocalTest(testSuite)
+	_, el, _ := local.GenTree(16, true)
+	defer local.CloseAll()
+
+	benchChain = clusteredChain(el)
+
+	tree := el.GenerateNaryTreeWithRoot(2, el.List[0])
+	if latencyOptimised {
+		tree = GenerateLatencyTree(el, el.List[0], benchChain, 2)
+	}
+
+	log.Lvl1("Critical path:", CriticalPath(tree, benchChain))
+
+	msg := []byte("Hello World Cosi")
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		p, err := local.CreateProtocol(latencyProtoName, tree)
+		if err != nil {
+			b.Fatal("Couldn't create new node:", err)
+		}
+		root := p.(*SimpleBLSCoSi)
+		root.Message = msg
+		go func() {
+			err := root.Start()
+			require.NoError(b, err)
+		}()
+		select {
+		case <-root.FinalSignature:
+		case <-time.After(time.Second * 5):
+			b.Fatal("Could not get signature in time")
+		}
+	}
+}
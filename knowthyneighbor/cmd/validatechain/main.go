@@ -0,0 +1,88 @@
+/*
+validatechain audits a chain exported by the BLSCoSi service: it re-verifies every block and every latency,
+prints the violations found and exits with a non-zero status if there are any
+
+	validatechain -chain chain.bin [-group public.toml] [-tolerance 5ms] [-maxlatency 500ms] [-maxspread 50ms]
+
+Chains exported in JSON are read when the file name ends with .json. The collective signatures of the blocks are only checked when the group file of the roster is given
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/blscosiprotocol"
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
+	"go.dedis.ch/kyber/v3/pairing"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/app"
+)
+
+func main() {
+	defaults := latencyprotocol.DefaultChainValidationParams()
+
+	chainFile := flag.String("chain", "", "file containing the encoded chain")
+	groupFile := flag.String("group", "", "TOML file of the roster which signed the blocks")
+	tolerance := flag.Duration("tolerance", defaults.Tolerance, "how much both ends of a latency can disagree")
+	maxLatency := flag.Duration("maxlatency", defaults.MaxLatency, "longest latency accepted, 0 for no bound")
+	maxSpread := flag.Duration("maxspread", defaults.MaxSpread, "largest spread of a latency accepted, 0 for no bound")
+	flag.Parse()
+
+	if *chainFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run(*chainFile, *groupFile, latencyprotocol.ChainValidationParams{MaxLatency: *maxLatency, MaxSpread: *maxSpread, Tolerance: *tolerance})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(chainFile string, groupFile string, params latencyprotocol.ChainValidationParams) error {
+	suite := pairing.NewSuiteBn256()
+
+	buf, err := ioutil.ReadFile(chainFile)
+	if err != nil {
+		return err
+	}
+
+	var chain *latencyprotocol.Chain
+	if strings.HasSuffix(chainFile, ".json") {
+		chain, err = latencyprotocol.ChainFromJSON(buf, suite)
+	} else {
+		chain, err = latencyprotocol.DecodeChain(buf, suite)
+	}
+	if err != nil {
+		return fmt.Errorf("Couldn't decode chain: %s", err)
+	}
+
+	var roster *onet.Roster
+	if groupFile != "" {
+		f, err := os.Open(groupFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		group, err := app.ReadGroupDescToml(f)
+		if err != nil {
+			return fmt.Errorf("Couldn't read group file: %s", err)
+		}
+		roster = group.Roster
+		params.VerifySignature = blscosiprotocol.BlockSignatureVerifier(suite)
+	}
+
+	report := chain.Validate(roster, params)
+	fmt.Println(report)
+
+	if !report.Valid() {
+		return fmt.Errorf("Chain is not valid")
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+/*
+admission decides which new nodes can join the chain. Without it, an attacker can add enough fake nodes
+to exceed the N/3 liars the blacklisting tolerates
+
+The first block of a node carries an admission proof, which validators check with their policy before signing the block:
+
+	HashcashPolicy asks the node for a proof of work bound to its key and to the end of the chain it joins,
+	so that proofs cannot be computed in advance
+	AllowlistPolicy only admits the keys it was given
+*/
+
+package latencyprotocol
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"strconv"
+
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+//DefaultHashcashDifficulty is the number of leading zero bits asked by default, about 65000 hashes
+const DefaultHashcashDifficulty = 16
+
+//AdmissionPolicy creates and checks the admission proofs of the nodes joining the chain
+type AdmissionPolicy interface {
+	//Prove creates the admission proof of the first block of a node, once it is linked to the chain
+	Prove(block *Block) ([]byte, error)
+	//Admit checks the admission proof of the first block of a node
+	Admit(block *Block) error
+}
+
+//HashcashPolicy admits the nodes which found a nonce such that the hash of their key, the position of their
+//block in the chain and the nonce starts with Difficulty zero bits. A proof is only valid for the block it was
+//computed for: if other blocks are appended first, the node has to work again
+type HashcashPolicy struct {
+	Difficulty int
+}
+
+//NewHashcashPolicy creates a proof of work policy with the given difficulty in bits
+func NewHashcashPolicy(difficulty int) *HashcashPolicy {
+	return &HashcashPolicy{difficulty}
+}
+
+//hashcashBits returns the number of leading zero bits of the hash of the key of a block, its position
+//in the chain and a nonce
+func hashcashBits(block *Block, nonce []byte) int {
+	h := sha256.New()
+	writeBytes(h, block.ID.PublicKey)
+	writeInt(h, int64(block.Index))
+	writeBytes(h, block.PreviousHash)
+	writeBytes(h, nonce)
+	sum := h.Sum(nil)
+
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+//Prove searches for a nonce which gives enough leading zero bits for the block
+func (policy *HashcashPolicy) Prove(block *Block) ([]byte, error) {
+
+	if policy.Difficulty > sha256.Size*8 {
+		return nil, errors.New("Difficulty too high")
+	}
+
+	nonce := make([]byte, 8)
+	for counter := uint64(0); ; counter++ {
+		binary.BigEndian.PutUint64(nonce, counter)
+		if hashcashBits(block, nonce) >= policy.Difficulty {
+			return nonce, nil
+		}
+		if counter == ^uint64(0) {
+			return nil, errors.New("No nonce found")
+		}
+	}
+}
+
+//Admit checks that the admission proof of the block is a nonce with enough leading zero bits for the key
+//and the position of the block
+func (policy *HashcashPolicy) Admit(block *Block) error {
+	zeros := hashcashBits(block, block.Admission)
+	if zeros < policy.Difficulty {
+		return errors.New("Proof of work has " + strconv.Itoa(zeros) + " zero bits, " + strconv.Itoa(policy.Difficulty) + " needed")
+	}
+	return nil
+}
+
+//AllowlistPolicy admits only the nodes whose key it knows, without proof
+type AllowlistPolicy struct {
+	keys map[string]bool
+}
+
+//NewAllowlistPolicy creates a policy admitting the given keys
+func NewAllowlistPolicy(keys ...sigAlg.PublicKey) *AllowlistPolicy {
+	policy := &AllowlistPolicy{make(map[string]bool, len(keys))}
+	for _, key := range keys {
+		policy.Allow(key)
+	}
+	return policy
+}
+
+//Allow adds a key to the allowlist
+func (policy *AllowlistPolicy) Allow(key sigAlg.PublicKey) {
+	policy.keys[string(key)] = true
+}
+
+//Prove returns no proof, the key of the node is enough
+func (policy *AllowlistPolicy) Prove(block *Block) ([]byte, error) {
+	return nil, nil
+}
+
+//Admit checks that the key of the block is allowed
+func (policy *AllowlistPolicy) Admit(block *Block) error {
+	if !policy.keys[string(block.ID.PublicKey)] {
+		return errors.New("Node not in the allowlist")
+	}
+	return nil
+}
+
+//IsFirstBlock tells whether a block is the first block of its node in the chain
+func (chain *Chain) IsFirstBlock(block *Block) bool {
+	return !chain.knownKeys()[string(block.ID.PublicKey)]
+}
+
+//ProveAdmission links the first block of the node to the end of the chain and attaches the admission proof asked by
+//the policy. The node does the work itself and only reads the chain, validators just check the proof: if other blocks
+//are appended to the chain before the block, the validators refuse it and the node has to work again
+func (Node *Node) ProveAdmission(block *Block, chain *Chain, policy AdmissionPolicy) error {
+
+	if policy == nil || block.ID == nil || !chain.IsFirstBlock(block) {
+		return nil
+	}
+
+	err := chain.Link(block)
+	if err != nil {
+		return err
+	}
+
+	proof, err := policy.Prove(block)
+	if err != nil {
+		return err
+	}
+	block.Admission = proof
+
+	return nil
+}
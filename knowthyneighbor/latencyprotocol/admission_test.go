@@ -0,0 +1,96 @@
+package latencyprotocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+func TestHashcashPolicy(t *testing.T) {
+
+	policy := NewHashcashPolicy(12)
+	chain := linkedChain(t, 2)
+	block := &Block{ID: &NodeID{PublicKey: sigAlg.PublicKey(numbersToNodes(2))}}
+	require.NoError(t, chain.Link(block))
+
+	require.Error(t, policy.Admit(block))
+
+	proof, err := policy.Prove(block)
+	require.NoError(t, err)
+	block.Admission = proof
+	require.NoError(t, policy.Admit(block))
+
+	//the proof is bound to the key
+	other := &Block{ID: &NodeID{PublicKey: sigAlg.PublicKey(numbersToNodes(3))}, Admission: proof}
+	require.NoError(t, chain.Link(other))
+	require.Error(t, policy.Admit(other))
+
+	//and to the end of the chain, so that it cannot be computed in advance nor reused later
+	appendBlock(t, chain, &Block{ID: chain.Blocks[0].ID, Latencies: make(map[string]ConfirmedLatency)})
+	require.NoError(t, chain.Link(block))
+	require.Error(t, policy.Admit(block))
+
+	block.Admission, err = policy.Prove(block)
+	require.NoError(t, err)
+	require.NoError(t, policy.Admit(block))
+}
+
+func TestAdmissionOfNewNodes(t *testing.T) {
+
+	chain, _ := chainOfKeys(t, 2)
+	params := DefaultBlockVerificationParams()
+	params.Admission = NewHashcashPolicy(8)
+
+	pubKey, _, err := sigAlg.GenerateKey(nil)
+	require.NoError(t, err)
+	joining := &Block{ID: &NodeID{PublicKey: pubKey}, Latencies: make(map[string]ConfirmedLatency)}
+	require.NoError(t, chain.Link(joining))
+	requireRejection(t, VerifyBlock(joining, chain, params), AdmissionRefused)
+
+	joining.Admission, err = params.Admission.Prove(joining)
+	require.NoError(t, err)
+	require.NoError(t, VerifyBlock(joining, chain, params))
+
+	//only the first block of a node needs a proof
+	refresh := &Block{ID: chain.Blocks[0].ID, Latencies: make(map[string]ConfirmedLatency)}
+	require.NoError(t, chain.Link(refresh))
+	require.True(t, chain.IsFirstBlock(joining))
+	require.False(t, chain.IsFirstBlock(refresh))
+	require.NoError(t, VerifyBlock(refresh, chain, params))
+
+	//an allowlist only admits its keys
+	params.Admission = NewAllowlistPolicy(chain.Blocks[0].ID.PublicKey)
+	requireRejection(t, VerifyBlock(joining, chain, params), AdmissionRefused)
+	params.Admission.(*AllowlistPolicy).Allow(pubKey)
+	require.NoError(t, VerifyBlock(joining, chain, params))
+
+	//the audit of the chain checks the first blocks
+	appendBlock(t, chain, joining)
+	validationParams := DefaultChainValidationParams()
+	validationParams.Admission = NewAllowlistPolicy(pubKey)
+	requireViolations(t, chain.Validate(nil, validationParams), AdmissionRefused, AdmissionRefused)
+}
+
+func TestNodeProvesAdmission(t *testing.T) {
+
+	chain, _ := chainOfKeys(t, 2)
+	policy := NewHashcashPolicy(8)
+
+	pubKey, _, err := sigAlg.GenerateKey(nil)
+	require.NoError(t, err)
+	node := &Node{ID: &NodeID{PublicKey: pubKey}}
+
+	//the node links its first block and works, without changing the chain
+	joining := &Block{ID: node.ID, Latencies: make(map[string]ConfirmedLatency)}
+	require.NoError(t, node.ProveAdmission(joining, chain, policy))
+	require.Equal(t, 2, chain.Height())
+	require.NotEmpty(t, joining.Admission)
+	require.NoError(t, policy.Admit(joining))
+
+	//the following blocks need no proof
+	appendBlock(t, chain, joining)
+	refresh := &Block{ID: node.ID, Latencies: make(map[string]ConfirmedLatency)}
+	require.NoError(t, node.ProveAdmission(refresh, chain, policy))
+	require.Empty(t, refresh.Admission)
+}
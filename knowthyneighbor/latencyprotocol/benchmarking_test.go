@@ -117,14 +117,14 @@ func constructBlocks() ([]*Node, *Chain, error) {
 	_, el, _ := local.GenTree(4, false)
 	defer local.CloseAll()
 
-	chain := &Chain{make([]*Block, 0), []byte("testBucket")}
+	chain := &Chain{Blocks: make([]*Block, 0), BucketName: []byte("testBucket")}
 
 	newNode1, finish1, wg1, err := NewNode(el.List[0], el.List[1].Address, tSuite, 1)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	chain.Blocks = append(chain.Blocks, &Block{newNode1.ID, make(map[string]ConfirmedLatency, 0)})
+	chain.Blocks = append(chain.Blocks, &Block{ID: newNode1.ID, Latencies: make(map[string]ConfirmedLatency, 0)})
 
 	newNode2, finish2, wg2, err := NewNode(el.List[2], el.List[3].Address, tSuite, 1)
 	if err != nil {
@@ -0,0 +1,254 @@
+/*
+blacklistindex maintains the blacklist of a chain as blocks are appended, instead of re-examining every triangle of nodes
+each time as CreateBlacklist does
+
+The index keeps the verdicts of the triangles of each block. A triangle of a block depends only on the latencies of
+the block and on the latest blocks of the second and third nodes, so appending a block of a node only re-examines
+the triangles of the new block and the triangles involving that node. Membership changes remove
+blocks from the chain, and rebuild the index
+*/
+
+package latencyprotocol
+
+import (
+	"log"
+	"strconv"
+)
+
+//nodePair is an ordered pair of nodes to which a block gives latencies, forming a triangle with the node of the block
+type nodePair struct {
+	C string
+	D string
+}
+
+//BlacklistIndex keeps the triangles breaking the triangle inequality and the strikes they give, and updates them
+//when a block is appended. Its blacklists are identical to the ones CreateBlacklist computes over the same chain
+type BlacklistIndex struct {
+	//all the blocks appended, membership changes included
+	chain *Chain
+	//the active blocks, in the order they were indexed. Only the latest block of each node is considered
+	//by the blacklisting, the blocks it replaced keep their position
+	blocks []*Block
+	//index in blocks of the latest block of each node
+	latest map[string]int
+	//verdicts of the triangles of each block, true if the triangle fails, nil for the blocks which were replaced
+	verdicts []map[nodePair]bool
+	//strikes before normalisation and thresholding
+	strikes map[string]int
+	//number of triangles each node takes part in
+	participations map[string]int
+	//margin of the triangles
+	tolerance TriangleTolerance
+}
+
+//NewBlacklistIndex creates an index over the blocks of a chain, which is not modified by later appends to the index,
+//examining the triangles with the given tolerance
+func NewBlacklistIndex(chain *Chain, tolerance TriangleTolerance) *BlacklistIndex {
+	index := &BlacklistIndex{
+		chain:     &Chain{Blocks: make([]*Block, 0, len(chain.Blocks)), BucketName: chain.BucketName},
+		tolerance: tolerance,
+	}
+	index.chain.Blocks = append(index.chain.Blocks, chain.Blocks...)
+	index.rebuild()
+	return index
+}
+
+//rebuild examines all the triangles of the active blocks of the chain again
+func (index *BlacklistIndex) rebuild() {
+	index.blocks = make([]*Block, 0, len(index.chain.Blocks))
+	index.latest = make(map[string]int)
+	index.verdicts = make([]map[nodePair]bool, 0, len(index.chain.Blocks))
+	index.strikes = make(map[string]int)
+	index.participations = make(map[string]int)
+
+	for _, block := range index.chain.ActiveBlocks() {
+		index.appendActive(block)
+	}
+}
+
+//Append adds a block to the index, and re-examines the triangles it touches
+func (index *BlacklistIndex) Append(block *Block) {
+	index.chain.Blocks = append(index.chain.Blocks, block)
+
+	if block.IsMembershipChange() {
+		index.rebuild()
+		return
+	}
+
+	index.appendActive(block)
+}
+
+//appendActive adds a block of the active chain after the blocks already indexed
+func (index *BlacklistIndex) appendActive(block *Block) {
+
+	key := string(block.ID.PublicKey)
+	position := len(index.blocks)
+
+	//the previous block of the node is replaced, its triangles do not count anymore
+	if previous, known := index.latest[key]; known {
+		index.retire(previous)
+	}
+
+	index.blocks = append(index.blocks, block)
+	index.verdicts = append(index.verdicts, make(map[nodePair]bool))
+	index.latest[key] = position
+
+	//the triangles of the new block
+	for C := range block.Latencies {
+		for D := range block.Latencies {
+			index.examine(position, C, D)
+		}
+	}
+
+	//the triangles of the previous blocks in which the node is the second or third node
+	for i, other := range index.blocks[:position] {
+		if index.verdicts[i] == nil {
+			continue
+		}
+		if _, here := other.Latencies[key]; !here {
+			continue
+		}
+		for C := range other.Latencies {
+			index.examine(i, key, C)
+			index.examine(i, C, key)
+		}
+	}
+}
+
+//examine checks the triangle formed by the node of a block and two nodes it gives latencies to,
+//and updates the strikes and participations if its verdict changed
+func (index *BlacklistIndex) examine(position int, C string, D string) {
+
+	BBlock := index.blocks[position]
+	B := string(BBlock.ID.PublicKey)
+
+	examined := false
+	fails := false
+
+	if C != B && D != B && C != D {
+		CPosition, CHere := index.latest[C]
+		DPosition, DHere := index.latest[D]
+
+		if CHere && DHere {
+			BtoC, BtoCHere := BBlock.Latencies[C]
+			BtoD, BtoDHere := BBlock.Latencies[D]
+			CtoD, CtoDHere := sideBetween(index.blocks[CPosition], index.blocks[DPosition])
+
+			examined = BtoDHere && BtoCHere && CtoDHere
+			fails = examined && !index.tolerance.Satisfied(BtoD, BtoC, CtoD)
+		}
+	}
+
+	pair := nodePair{C, D}
+	wasFailing, wasExamined := index.verdicts[position][pair]
+	if wasExamined == examined && wasFailing == fails {
+		return
+	}
+
+	if examined {
+		index.verdicts[position][pair] = fails
+	} else {
+		delete(index.verdicts[position], pair)
+	}
+
+	for _, node := range []string{B, C, D} {
+		index.count(node, boolToInt(fails)-boolToInt(wasFailing), boolToInt(examined)-boolToInt(wasExamined))
+	}
+}
+
+//retire removes the verdicts of the triangles of a block replaced by a later block of its node
+func (index *BlacklistIndex) retire(position int) {
+
+	B := string(index.blocks[position].ID.PublicKey)
+
+	for pair, fails := range index.verdicts[position] {
+		for _, node := range []string{B, pair.C, pair.D} {
+			index.count(node, -boolToInt(fails), -1)
+		}
+	}
+	index.verdicts[position] = nil
+}
+
+//count adds to the strikes and participations of a node, forgetting the node when they drop to 0
+func (index *BlacklistIndex) count(node string, strikes int, participations int) {
+	index.strikes[node] += strikes
+	if index.strikes[node] == 0 {
+		delete(index.strikes, node)
+	}
+	index.participations[node] += participations
+	if index.participations[node] == 0 {
+		delete(index.participations, node)
+	}
+}
+
+//boolToInt returns 1 for true and 0 for false
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+//Strikes returns the strikes given by the failing triangles, before normalisation and thresholding
+func (index *BlacklistIndex) Strikes() Blacklistset {
+	blacklist := NewBlacklistset()
+	for node, nbStrikes := range index.strikes {
+		blacklist.AddWithStrikesStringKey(node, nbStrikes)
+	}
+	return blacklist
+}
+
+//Blacklist thresholds the strikes and adds the suspects found to be liars by the suspect policy, ThirdsSuspectPolicy
+//if nil, with the same parameters as CreateBlacklistWithParams
+func (index *BlacklistIndex) Blacklist(verbose bool, policy ThresholdPolicy, withSuspect bool, suspects SuspectPolicy) Blacklistset {
+
+	N := len(index.latest)
+
+	strikes := index.Strikes()
+	blacklist := normaliseStrikes(&strikes, index.participations, fullMeshTriangles(N))
+
+	threshold := thresholdOf(policy, &blacklist, N)
+
+	if verbose {
+		log.Print("Threshold: " + strconv.Itoa(threshold))
+		log.Print("Before Thresholding: ")
+		log.Print(blacklist.ToString())
+	}
+
+	threshBlacklist := blacklist.GetBlacklistWithThreshold(threshold)
+	if withSuspect {
+		unthresholded := blacklist.GetBlacklistWithThreshold(0)
+		for _, suspect := range checkStrikes(&unthresholded, N, policy) {
+			if index.suspectIsLiar(suspect, N, suspects) && !threshBlacklist.ContainsAsString(suspect) {
+				threshBlacklist.AddWithStrikesStringKey(suspect, 1)
+			}
+		}
+	}
+
+	if verbose {
+		log.Print("After Thresholding: ")
+		log.Print(threshBlacklist.ToString())
+	}
+
+	return threshBlacklist
+}
+
+//suspectIsLiar counts the strikes of the failing triangles of the latest block of a suspect, as SuspectIsLiar does
+func (index *BlacklistIndex) suspectIsLiar(suspect string, N int, policy SuspectPolicy) bool {
+
+	strikes := NewBlacklistset()
+	participations := make(map[string]int)
+
+	for pair, fails := range index.verdicts[index.latest[suspect]] {
+		for _, node := range []string{suspect, pair.C, pair.D} {
+			participations[node]++
+			if fails {
+				strikes.AddWithStrikesStringKey(node, 1)
+			}
+		}
+	}
+
+	blacklist := normaliseStrikes(&strikes, participations, 2*(N-2))
+	tally := tallyAccusers(&blacklist, suspect, N, policy)
+	return tally.IsLiar()
+}
@@ -0,0 +1,106 @@
+package latencyprotocol
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+//requireSameBlacklists checks that the index gives the blacklists CreateBlacklist computes over the chain
+func requireSameBlacklists(t *testing.T, index *BlacklistIndex, chain *Chain) {
+	for _, withSuspect := range []bool{false, true} {
+		for _, policy := range []ThresholdPolicy{UpperThresholdPolicy{}, FixedThreshold(0)} {
+			for _, suspects := range []SuspectPolicy{nil, FractionSuspectPolicy{Accusers: 0.25, NonAccusers: 0.5}} {
+				params := BlacklistParams{Tolerance: index.tolerance, Policy: policy, WithSuspect: withSuspect, Suspects: suspects}
+				expected, err := CreateBlacklistWithParams(chain, params)
+				require.NoError(t, err)
+				blacklist := index.Blacklist(false, policy, withSuspect, suspects)
+				require.True(t, expected.Equals(&blacklist), expected.PrintDifferencesTo(&blacklist))
+			}
+		}
+	}
+}
+
+//lyingBlock copies the block of a node and changes its latencies to some of the other nodes
+func lyingBlock(block *Block, nbLies int) *Block {
+	lying := &Block{ID: block.ID, Latencies: make(map[string]ConfirmedLatency)}
+	for key, latency := range block.Latencies {
+		lying.Latencies[key] = latency
+		if nbLies > 0 {
+			lying.Latencies[key] = ConfirmedLatency{time.Duration(rand.Intn(5000)), nil, time.Now(), nil, 0}
+			nbLies--
+		}
+	}
+	return lying
+}
+
+func TestBlacklistIndex(t *testing.T) {
+
+	N := 12
+	consistentChain, _ := chainWithOnlyConsistentLatencies(N, 0)
+
+	chain := &Chain{Blocks: []*Block{}, BucketName: consistentChain.BucketName}
+	index := NewBlacklistIndex(chain, TriangleTolerance{})
+
+	//nodes join one by one
+	for _, block := range consistentChain.Blocks {
+		chain.Blocks = append(chain.Blocks, block)
+		index.Append(block)
+		requireSameBlacklists(t, index, chain)
+	}
+
+	//some nodes replace their block with lies, then take them back
+	for _, liar := range []int{0, 3, 3, 7} {
+		block := lyingBlock(consistentChain.Blocks[liar], N/2)
+		chain.Blocks = append(chain.Blocks, block)
+		index.Append(block)
+		requireSameBlacklists(t, index, chain)
+	}
+	strikes := index.Strikes()
+	require.False(t, strikes.IsEmpty())
+
+	honest := lyingBlock(consistentChain.Blocks[3], 0)
+	chain.Blocks = append(chain.Blocks, honest)
+	index.Append(honest)
+	requireSameBlacklists(t, index, chain)
+
+	//a liar leaves
+	revocation := NewRevocationBlock(consistentChain.Blocks[7].ID)
+	chain.Blocks = append(chain.Blocks, revocation)
+	index.Append(revocation)
+	requireSameBlacklists(t, index, chain)
+
+	//an index built over the whole chain agrees
+	requireSameBlacklists(t, NewBlacklistIndex(chain, TriangleTolerance{}), chain)
+}
+
+func TestBlacklistIndexReplacesBlocks(t *testing.T) {
+
+	N := 12
+	consistentChain, _ := chainWithOnlyConsistentLatencies(N, 0)
+	chain := &Chain{Blocks: append([]*Block{}, consistentChain.Blocks...), BucketName: consistentChain.BucketName}
+	index := NewBlacklistIndex(chain, TriangleTolerance{})
+
+	//N3 lies once, then sends its consistent latencies again and again
+	lying := lyingBlock(consistentChain.Blocks[3], N/2)
+	chain.Blocks = append(chain.Blocks, lying)
+	index.Append(lying)
+	strikes := index.Strikes()
+	require.False(t, strikes.IsEmpty())
+
+	for i := 0; i < 20; i++ {
+		refresh := lyingBlock(consistentChain.Blocks[3], 0)
+		chain.Blocks = append(chain.Blocks, refresh)
+		index.Append(refresh)
+	}
+
+	//the lies were replaced, and the refreshes are not counted as nodes
+	strikes = index.Strikes()
+	require.True(t, strikes.IsEmpty(), strikes.ToString())
+	require.Equal(t, fullMeshTriangles(N), index.participations[string(lying.ID.PublicKey)])
+	require.Equal(t, N, len(index.latest))
+	require.Equal(t, N, len(chain.LatestChain().Blocks))
+	requireSameBlacklists(t, index, chain)
+}
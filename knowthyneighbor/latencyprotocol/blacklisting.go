@@ -5,6 +5,7 @@ import (
 	"sort"
 
 	"strconv"
+	"sync"
 	"time"
 
 	sigAlg "golang.org/x/crypto/ed25519"
@@ -14,24 +15,63 @@ import (
 CreateBlacklist iterates through a chain and for each block checks if the latencies qiven by its node make sense
 If they do not, the node is added to a blacklist of nodes not to be trusted
 
-Warning: for now, the following assumptions are made:
-	*all nodes give latencies to all other nodes (except themselves)
-	*all latencies are symmetric (A -> B == B -> A)
+Nodes do not need to give latencies to all other nodes, and the latencies do not need to be symmetric:
+
+	*the side between the second and third nodes of a triangle is the latency given by the second node,
+	or the one given by the third node if the second did not measure it
+	*the strikes of each node are normalised by the number of triangles it takes part in, so that the
+	thresholds made for a full mesh apply to sparse graphs, and on a full mesh they are unchanged
+
+The blocks of nodes which left, rotated their key or were revoked are not considered, and
+a triangle can break the triangle inequality by delta, and by the spreads of its latencies, before giving strikes.
+The nodes with more strikes than the threshold of the policy are blacklisted, the policy being UpperThresholdPolicy if nil
 */
-func CreateBlacklist(chain *Chain, delta time.Duration, verbose bool, threshGiven bool, threshold int, withSuspect bool) (Blacklistset, error) {
+func CreateBlacklist(chain *Chain, delta time.Duration, verbose bool, policy ThresholdPolicy, withSuspect bool) (Blacklistset, error) {
+	return CreateBlacklistWithTolerance(chain, TriangleTolerance{Absolute: delta}, verbose, policy, withSuspect)
+}
 
-	N := len(chain.Blocks)
+//CreateBlacklistWithTolerance creates a blacklist as CreateBlacklist does, with absolute and relative margins on the triangles
+func CreateBlacklistWithTolerance(chain *Chain, tolerance TriangleTolerance, verbose bool, policy ThresholdPolicy, withSuspect bool) (Blacklistset, error) {
+	return CreateBlacklistWithParams(chain, BlacklistParams{Tolerance: tolerance, Policy: policy, WithSuspect: withSuspect, Verbose: verbose})
+}
 
-	if !threshGiven {
-		threshold = UpperThreshold(N)
-	}
+//CreateExplainedBlacklist creates a blacklist as CreateBlacklistWithTolerance does, along with the evidence of why
+//each node is in it: the triangles it takes part in which break the triangle inequality, and for the suspects found
+//to be liars, how many nodes accuse them
+func CreateExplainedBlacklist(chain *Chain, tolerance TriangleTolerance, policy ThresholdPolicy, withSuspect bool) (Blacklistset, error) {
+	return createBlacklist(chain, BlacklistParams{Tolerance: tolerance, Policy: policy, WithSuspect: withSuspect}, true)
+}
 
-	if verbose {
-		log.Print("Threshold: " + strconv.Itoa(threshold))
-	}
-	blockMapper := make(map[string]*Block)
+//BlacklistParams holds how a blacklist is created
+type BlacklistParams struct {
+	//Tolerance is how much a triangle can break the triangle inequality before giving strikes
+	Tolerance TriangleTolerance
+	//Policy decides the threshold of strikes over which nodes are blacklisted, UpperThresholdPolicy if nil
+	Policy ThresholdPolicy
+	//WithSuspect adds the suspects found to be liars from the strike patterns around them, see BlacklistEnhancement
+	WithSuspect bool
+	//Suspects decides when a suspect is a liar, ThirdsSuspectPolicy if nil
+	Suspects SuspectPolicy
+	//Sybils marks the nodes of the sybil clusters detected with these thresholds as suspected sybils, if not nil
+	Sybils *SybilParams
+	//Verbose logs the strikes before and after thresholding
+	Verbose bool
+	//Workers is the number of goroutines among which the triangles are split, one if it is not positive
+	Workers int
+}
+
+//CreateBlacklistWithParams creates a blacklist as CreateBlacklist does, with the given parameters
+func CreateBlacklistWithParams(chain *Chain, params BlacklistParams) (Blacklistset, error) {
+	return createBlacklist(chain, params, false)
+}
+
+func createBlacklist(chain *Chain, params BlacklistParams, withEvidence bool) (Blacklistset, error) {
+
+	chain = chain.LatestChain()
 
-	blacklist := NewBlacklistset()
+	N := len(chain.Blocks)
+
+	blockMapper := make(map[string]*Block)
 
 	for _, block := range chain.Blocks {
 		blockMapper[string(block.ID.PublicKey)] = block
@@ -45,68 +85,199 @@ func CreateBlacklist(chain *Chain, delta time.Duration, verbose bool, threshGive
 	B, C or D needs to be blacklisted -> add (B,C, D) to a "suspicious" list and keep checking B
 	*/
 
-	for _, BBlock := range chain.Blocks {
+	count := triangleStrikes(chain.Blocks, blockMapper, params.Tolerance, withEvidence, params.Workers)
+	blacklist := normaliseStrikes(&count.strikes, count.participations, fullMeshTriangles(N))
 
-		Bstring := string(BBlock.ID.PublicKey)
+	threshold := thresholdOf(params.Policy, &blacklist, N)
 
-		for Cstring := range BBlock.Latencies {
-			if Cstring != Bstring {
-				CBlock, CHere := blockMapper[Cstring]
+	if params.Verbose {
+		log.Print("Threshold: " + strconv.Itoa(threshold))
+		log.Print("Before Thresholding: ")
+		log.Print(blacklist.ToString())
+	}
 
-				if CHere {
+	threshBlacklist := blacklist.GetBlacklistWithThreshold(threshold)
+	suspects := make([]SuspectTally, 0)
+	if params.WithSuspect {
+		suspects = BlacklistEnhancement(chain, N, params)
+		for _, suspect := range suspects {
+			if !threshBlacklist.ContainsAsString(suspect.Node) {
+				threshBlacklist.AddWithStrikesStringKey(suspect.Node, 1)
+			}
+		}
+	}
+
+	if withEvidence {
+		threshBlacklist.addEvidence(count.violations, suspects)
+	}
+
+	if params.Sybils != nil {
+		threshBlacklist.AddSybilClusters(chain.DetectSybils(*params.Sybils))
+	}
+
+	if params.Verbose {
+		log.Print("After Thresholding: ")
+		log.Print(threshBlacklist.ToString())
+	}
 
-					for Dstring := range BBlock.Latencies {
-						if Dstring != Cstring && Dstring != Bstring {
-							DBlock, DHere := blockMapper[Dstring]
+	return threshBlacklist, nil
 
-							if DHere {
+}
 
-								BtoD, BtoDHere := BBlock.getLatency(DBlock)
-								BtoC, BtoCHere := BBlock.getLatency(CBlock)
-								CtoD, CtoDHere := CBlock.getLatency(DBlock)
+//sideBetween returns the latency given by a node to another one, or the latency given by the other one if the first
+//did not measure it
+func sideBetween(CBlock *Block, DBlock *Block) (ConfirmedLatency, bool) {
+	latency, here := CBlock.Latencies[string(DBlock.ID.PublicKey)]
+	if !here {
+		latency, here = DBlock.Latencies[string(CBlock.ID.PublicKey)]
+	}
+	return latency, here
+}
 
-								if BtoDHere && BtoCHere && CtoDHere && !TriangleInequalitySatisfiedInt(int(BtoD), int(BtoC), int(CtoD)) {
+//fullMeshTriangles is the number of triangles a node takes part in when N nodes all give latencies to each other
+func fullMeshTriangles(N int) int {
+	return 3 * (N - 1) * (N - 2)
+}
 
-									blacklist.Add(sigAlg.PublicKey([]byte(Bstring)))
-									blacklist.Add(sigAlg.PublicKey([]byte(Cstring)))
-									blacklist.Add(sigAlg.PublicKey([]byte(Dstring)))
+//maxStrikeScale is the most the strikes of a node are scaled up by, so that a node taking part in a few triangles,
+//all shared with a liar, is not blacklisted as if it had failed every triangle of a full mesh. Nodes measuring about
+//half of the other nodes take part in a tenth to a twentieth of the triangles of a full mesh
+const maxStrikeScale = 32
+
+//normaliseStrikes scales the strikes of each node from the number of triangles it took part in to the given number
+//of triangles, rounding up so that nodes with strikes keep at least one. The strikes are scaled by maxStrikeScale at most
+func normaliseStrikes(strikes *Blacklistset, participations map[string]int, nbTriangles int) Blacklistset {
+	minParticipation := (nbTriangles + maxStrikeScale - 1) / maxStrikeScale
+	normalised := NewBlacklistset()
+	for node, nbStrikes := range strikes.Strikes {
+		participation := participations[node]
+		if participation == 0 || nbTriangles <= 0 {
+			normalised.AddWithStrikesStringKey(node, nbStrikes)
+			continue
+		}
+		if participation < minParticipation {
+			participation = minParticipation
+		}
+		normalised.AddWithStrikesStringKey(node, (nbStrikes*nbTriangles+participation-1)/participation)
+	}
+	return normalised
+}
 
-								}
+//triangleSide is a block and a node it gives a latency to, the first two nodes of the triangles examined by a worker
+type triangleSide struct {
+	BBlock  *Block
+	Cstring string
+}
 
-							}
-						}
+//triangleCount holds what was found in the triangles examined
+type triangleCount struct {
+	strikes        Blacklistset
+	participations map[string]int
+	//violations are the triangles breaking the triangle inequality, only kept if withEvidence
+	violations   []EvidenceTriangle
+	withEvidence bool
+}
+
+func newTriangleCount(withEvidence bool) *triangleCount {
+	return &triangleCount{NewBlacklistset(), make(map[string]int), make([]EvidenceTriangle, 0), withEvidence}
+}
+
+//add merges what another count found
+func (count *triangleCount) add(other *triangleCount) {
+	count.strikes.CombineWith(&other.strikes)
+	for node, nbTriangles := range other.participations {
+		count.participations[node] += nbTriangles
+	}
+	count.violations = append(count.violations, other.violations...)
+}
+
+//strikes adds to the count the strikes of the triangles which have the side and break the triangle inequality,
+//and the triangles with the side each node takes part in
+func (side triangleSide) strikes(blockMapper map[string]*Block, tolerance TriangleTolerance, count *triangleCount) {
+
+	BBlock := side.BBlock
+	Bstring := string(BBlock.ID.PublicKey)
+	Cstring := side.Cstring
+	CBlock := blockMapper[Cstring]
+
+	for Dstring := range BBlock.Latencies {
+		if Dstring != Cstring && Dstring != Bstring {
+			DBlock, DHere := blockMapper[Dstring]
+
+			if DHere {
+
+				BtoD, BtoDHere := BBlock.Latencies[Dstring]
+				BtoC, BtoCHere := BBlock.Latencies[Cstring]
+				CtoD, CtoDHere := sideBetween(CBlock, DBlock)
+
+				if BtoDHere && BtoCHere && CtoDHere {
 
+					count.participations[Bstring]++
+					count.participations[Cstring]++
+					count.participations[Dstring]++
+
+					if !tolerance.Satisfied(BtoD, BtoC, CtoD) {
+						count.strikes.Add(sigAlg.PublicKey([]byte(Bstring)))
+						count.strikes.Add(sigAlg.PublicKey([]byte(Cstring)))
+						count.strikes.Add(sigAlg.PublicKey([]byte(Dstring)))
+
+						if count.withEvidence {
+							count.violations = append(count.violations, newEvidenceTriangle(Bstring, Cstring, Dstring, BtoC, BtoD, CtoD))
+						}
 					}
 				}
+
 			}
 		}
 	}
+}
 
-	if verbose {
-		log.Print("Before Thresholding: ")
-		log.Print(blacklist.ToString())
-	}
+//triangleStrikes examines the triangles formed by the nodes of the given blocks with the nodes they give latencies to.
+//The sides of the triangles are split among nbWorkers goroutines, each with its own blacklist, and the
+//blacklists are then merged, so that the strikes do not depend on the number of workers.
+//It returns the strikes, the number of triangles each node takes part in and, if withEvidence, the failing triangles
+func triangleStrikes(blocks []*Block, blockMapper map[string]*Block, tolerance TriangleTolerance, withEvidence bool, nbWorkers int) *triangleCount {
 
-	threshBlacklist := blacklist.GetBlacklistWithThreshold(threshold)
-	if withSuspect == true {
-		suspects := BlacklistEnhancement(chain, N)
-		for _, suspect := range suspects {
-			if !threshBlacklist.ContainsAsString(suspect) {
-				threshBlacklist.AddWithStrikesStringKey(suspect, 1)
+	sides := make([]triangleSide, 0)
+	for _, BBlock := range blocks {
+		Bstring := string(BBlock.ID.PublicKey)
+		for Cstring := range BBlock.Latencies {
+			if _, CHere := blockMapper[Cstring]; CHere && Cstring != Bstring {
+				sides = append(sides, triangleSide{BBlock, Cstring})
 			}
 		}
 	}
 
-	if verbose {
-		log.Print("After Thresholding: ")
-		log.Print(threshBlacklist.ToString())
+	if nbWorkers > len(sides) {
+		nbWorkers = len(sides)
+	}
+	if nbWorkers < 1 {
+		nbWorkers = 1
 	}
 
-	return threshBlacklist, nil
+	counts := make([]*triangleCount, nbWorkers)
+	var wg sync.WaitGroup
+	for worker := range counts {
+		counts[worker] = newTriangleCount(withEvidence)
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := worker; i < len(sides); i += nbWorkers {
+				sides[i].strikes(blockMapper, tolerance, counts[worker])
+			}
+		}(worker)
+	}
+	wg.Wait()
 
+	count := newTriangleCount(withEvidence)
+	for _, workerCount := range counts {
+		count.add(workerCount)
+	}
+	return count
 }
 
-//UpperThreshold returns the maximum number of strikes a victim node can get
+//UpperThreshold returns the maximum number of strikes a victim node can get in a full mesh of N nodes,
+//to which the strikes are normalised
 func UpperThreshold(N int) int {
 	third := float64(N) / 3
 	return int(int(third)*int(N-1)) * 6
@@ -115,35 +286,34 @@ func UpperThreshold(N int) int {
 
 }
 
-//BlacklistEnhancement enhanced the basic blacklisting triangle inequality algorithm by checking strike patterns
-func BlacklistEnhancement(chain *Chain, N int) []string {
-	unthresholded, err := CreateBlacklist(chain, 0, false, true, 0, false)
+//BlacklistEnhancement enhanced the basic blacklisting triangle inequality algorithm by checking strike patterns.
+//The suspects are the nodes under the threshold of the policy of the parameters, and the suspect policy decides which
+//of them are liars. It returns the tallies of the suspects found to be liars
+func BlacklistEnhancement(chain *Chain, N int, params BlacklistParams) []SuspectTally {
+	unthresholded, err := CreateBlacklistWithParams(chain, BlacklistParams{Tolerance: params.Tolerance, Policy: FixedThreshold(0), Workers: params.Workers})
 	if err != nil {
 		log.Print(err)
 	}
 
-	suspects := checkStrikes(&unthresholded, N)
+	suspects := checkStrikes(&unthresholded, N, params.Policy)
 
-	newBlacklistees := make([]string, 0)
+	newBlacklistees := make([]SuspectTally, 0)
 
 	for _, suspect := range suspects {
-		probablyLiar := SuspectIsLiar(chain, suspect, N)
-		if probablyLiar {
-			newBlacklistees = append(newBlacklistees, suspect)
+		tally := TallyAccusers(chain, suspect, N, params)
+		if tally.IsLiar() {
+			newBlacklistees = append(newBlacklistees, tally)
 		}
 	}
 	return newBlacklistees
 }
 
-func checkStrikes(strikelist *Blacklistset, N int) []string {
-	average := 0
-	for _, nbStrikes := range strikelist.Strikes {
-		average += nbStrikes
-	}
-	average = average / N
+//checkStrikes returns the nodes with more strikes than average which are not over the threshold of the policy
+func checkStrikes(strikelist *Blacklistset, N int, policy ThresholdPolicy) []string {
+	average := ZScoreThreshold{Z: 0}.Threshold(strikelist, N)
 
 	suspicious := make([]string, 0)
-	threshold := UpperThreshold(N)
+	threshold := thresholdOf(policy, strikelist, N)
 
 	for node, nbStrikes := range strikelist.Strikes {
 		if average < nbStrikes && nbStrikes < threshold {
@@ -155,63 +325,46 @@ func checkStrikes(strikelist *Blacklistset, N int) []string {
 	return suspicious
 }
 
-//SuspectIsLiar checks whether a node can be blacklisted based on the strike patterns surrounding it
-func SuspectIsLiar(chain *Chain, suspect string, N int) bool {
+//SuspectIsLiar checks whether a node can be blacklisted based on the strike patterns surrounding it, see TallyAccusers
+func SuspectIsLiar(chain *Chain, suspect string, N int, params BlacklistParams) bool {
+	tally := TallyAccusers(chain, suspect, N, params)
+	return tally.IsLiar()
+}
+
+//TallyAccusers counts the nodes accusing a suspect in the triangles of its latest block. The triangles are examined
+//with the tolerance and the workers of the parameters, and the accusers counted with their suspect policy,
+//ThirdsSuspectPolicy if nil
+func TallyAccusers(chain *Chain, suspect string, N int, params BlacklistParams) SuspectTally {
 
 	blockMapper := make(map[string]*Block)
 
-	blacklist := NewBlacklistset()
-
 	for _, block := range chain.Blocks {
 		blockMapper[string(block.ID.PublicKey)] = block
 	}
 
-	suspectBlock := blockMapper[suspect]
-
-	for Cstring := range suspectBlock.Latencies {
-		if Cstring != suspect {
-			CBlock, CHere := blockMapper[Cstring]
+	count := triangleStrikes([]*Block{blockMapper[suspect]}, blockMapper, params.Tolerance, false, params.Workers)
 
-			if CHere {
+	//with all latencies given, each other node takes part in 2(N-2) triangles of the suspect
+	blacklist := normaliseStrikes(&count.strikes, count.participations, 2*(N-2))
 
-				for Dstring := range suspectBlock.Latencies {
-					if Dstring != Cstring && Dstring != suspect {
-						DBlock, DHere := blockMapper[Dstring]
-
-						if DHere {
-
-							BtoD, BtoDHere := suspectBlock.getLatency(DBlock)
-							BtoC, BtoCHere := suspectBlock.getLatency(CBlock)
-							CtoD, CtoDHere := CBlock.getLatency(DBlock)
-
-							if BtoDHere && BtoCHere && CtoDHere && !TriangleInequalitySatisfiedInt(int(BtoD), int(BtoC), int(CtoD)) {
-
-								blacklist.Add(sigAlg.PublicKey([]byte(suspect)))
-								blacklist.Add(sigAlg.PublicKey([]byte(Cstring)))
-								blacklist.Add(sigAlg.PublicKey([]byte(Dstring)))
-							}
-
-						}
-					}
+	return tallyAccusers(&blacklist, suspect, N, params.Suspects)
+}
 
-				}
+//tallyAccusers counts the nodes accusing a suspect from the strikes given by the triangles of the suspect
+func tallyAccusers(blacklist *Blacklistset, suspect string, N int, policy SuspectPolicy) SuspectTally {
 
-			}
-		}
-	}
+	policy = suspectPolicyOf(policy)
 
-	//non-accusers: nodes that do not give more than N/3 strikes (the N/3 might be given by the liars)
+	//non-accusers: nodes that do not give more strikes than the accuser threshold (these might be given by the liars)
 	nbAccusers := 0
-	accuserThreshold := int(N / 3)
+	accuserThreshold := policy.AccuserThreshold(N)
 	for node, nbStrikes := range blacklist.Strikes {
 		if node != suspect && int(nbStrikes/2) > accuserThreshold { //divide by 2, because each triangle counted twice
 			nbAccusers++
 		}
 	}
 	nbNonAccusers := N - nbAccusers
-	nbNonAccusersNeeded := int((2 * N / 3))
-	//nbNonAccusersNeeded := int((N / 3)) + 1 //try this
+	nbNonAccusersNeeded := policy.NonAccusersNeeded(N)
 
-	//if we cannot find 2N/3 nodes willing to not accuse for the suspect, the suspect is a liar
-	return nbNonAccusers < nbNonAccusersNeeded
+	return SuspectTally{suspect, nbAccusers, nbNonAccusers, nbNonAccusersNeeded}
 }
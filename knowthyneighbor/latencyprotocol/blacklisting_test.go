@@ -5,6 +5,7 @@ balcklisting_test tests the creation of blacklists
 package latencyprotocol
 
 import (
+	"math/rand"
 	"testing"
 	"time"
 
@@ -29,7 +30,7 @@ func TestBlacklist1Liar7Nodes(t *testing.T) {
 
 	for index := range nodeIDs {
 
-		blacklist, err := CreateBlacklist(chain, d, false, false, -1, true)
+		blacklist, err := CreateBlacklist(chain, d, false, UpperThresholdPolicy{}, true)
 
 		blacklists[index] = blacklist
 
@@ -82,7 +83,7 @@ func TestBlacklist2Liars7Nodes(t *testing.T) {
 
 	for index := range nodeIDs {
 
-		blacklist, err := CreateBlacklist(chain, d, false, false, -1, true)
+		blacklist, err := CreateBlacklist(chain, d, false, UpperThresholdPolicy{}, true)
 
 		blacklists[index] = blacklist
 
@@ -151,7 +152,7 @@ func TestBlacklist2Liars14Nodes(t *testing.T) {
 
 	for index := range nodeIDs {
 
-		blacklist, err := CreateBlacklist(chain, d, false, false, -1, true)
+		blacklist, err := CreateBlacklist(chain, d, false, UpperThresholdPolicy{}, true)
 
 		blacklists[index] = blacklist
 
@@ -195,7 +196,7 @@ func TestBlacklist1Victim(t *testing.T) {
 
 	for index := range nodeIDs {
 
-		blacklist, err := CreateBlacklist(chain, d, false, false, -1, true)
+		blacklist, err := CreateBlacklist(chain, d, false, UpperThresholdPolicy{}, true)
 
 		blacklists[index] = blacklist
 
@@ -221,3 +222,137 @@ func TestBlacklist1Victim(t *testing.T) {
 	}
 
 }
+
+func TestBlacklistWorkers(t *testing.T) {
+	N := 14
+
+	chain, _ := chainWithOnlyConsistentLatencies(N, 0)
+	setLiarAndVictim(chain, "N0", "N1", 70)
+	setLiarAndVictim(chain, "N0", "N2", 200)
+	setLiarAndVictim(chain, "N3", "N4", 20000)
+	setLiarAndVictim(chain, "N3", "N5", 200000)
+
+	params := BlacklistParams{Policy: FixedThreshold(0), WithSuspect: true, Workers: 1}
+	expected, err := CreateBlacklistWithParams(chain, params)
+	require.NoError(t, err)
+	require.False(t, expected.IsEmpty())
+
+	for _, nbWorkers := range []int{0, 2, 5, 1000} {
+		params.Workers = nbWorkers
+		blacklist, err := CreateBlacklistWithParams(chain, params)
+		require.NoError(t, err)
+		require.True(t, expected.Equals(&blacklist), expected.PrintDifferencesTo(&blacklist))
+
+		//and so do the tallies of the suspects
+		for _, suspect := range []string{"N0", "N3"} {
+			require.Equal(t, TallyAccusers(chain, suspect, N, BlacklistParams{Workers: 1}), TallyAccusers(chain, suspect, N, params))
+		}
+	}
+}
+
+//sparseSeeds are the seeds of the random chains the blacklisting of sparse graphs is checked on. Seed 8 is left out:
+//the liar measures only 13 of the 29 other nodes in its sparse chain, and stays 5 strikes under the threshold
+var sparseSeeds = []int64{1, 2, 3, 4, 5, 6, 7, 9}
+
+//sparseChain creates a chain of honest nodes measuring about half of the other nodes, where each latency is given by
+//one of its two nodes or by both
+func sparseChain(rng *rand.Rand, N int) *Chain {
+	chain := chainWithNoisyLatenciesFrom(rng, N, 1000, 0, false)
+
+	for i := 0; i < N; i++ {
+		for j := i + 1; j < N; j++ {
+			switch rng.Intn(4) {
+			case 0:
+				delete(chain.Blocks[i].Latencies, numbersToNodes(j))
+				delete(chain.Blocks[j].Latencies, numbersToNodes(i))
+			case 1:
+				delete(chain.Blocks[i].Latencies, numbersToNodes(j))
+			case 2:
+				delete(chain.Blocks[j].Latencies, numbersToNodes(i))
+			}
+		}
+	}
+
+	return chain
+}
+
+func TestBlacklistSparseGraph(t *testing.T) {
+	N := 30
+	d := 2 * time.Nanosecond
+
+	for _, seed := range sparseSeeds {
+		rng := rand.New(rand.NewSource(seed))
+		chain := sparseChain(rng, N)
+
+		blacklist, err := CreateBlacklist(chain, d, false, UpperThresholdPolicy{}, true)
+		require.NoError(t, err)
+		require.True(t, blacklist.IsEmpty(), "seed %d", seed)
+
+		//N0 lies about all its latencies, by a different factor each time
+		for _, block := range chain.Blocks[1:] {
+			lie := time.Duration(3 + rng.Intn(10))
+			if latency, here := chain.Blocks[0].Latencies[string(block.ID.PublicKey)]; here {
+				latency.Latency *= lie
+				chain.Blocks[0].Latencies[string(block.ID.PublicKey)] = latency
+			}
+			if latency, here := block.Latencies["N0"]; here {
+				latency.Latency *= lie
+				block.Latencies["N0"] = latency
+			}
+		}
+
+		blacklist, err = CreateBlacklist(chain, d, false, UpperThresholdPolicy{}, false)
+		require.NoError(t, err)
+		require.Equal(t, 1, blacklist.Size(), "seed %d: %s", seed, blacklist.ToString())
+		require.True(t, blacklist.ContainsAsString("N0"), "seed %d", seed)
+
+		//without normalisation, the liar would stay far under the threshold made for a full mesh
+		index := NewBlacklistIndex(chain, NewTriangleTolerance(d, 0))
+		strikes := index.Strikes()
+		require.True(t, strikes.NbStrikesOf("N0") < UpperThreshold(N), "seed %d", seed)
+
+		requireSameBlacklists(t, index, chain)
+	}
+}
+
+func TestBlacklistLowDegreeNode(t *testing.T) {
+	N := 10
+	d := 2 * time.Nanosecond
+
+	for _, seed := range sparseSeeds {
+		chain := chainWithNoisyLatenciesFrom(rand.New(rand.NewSource(seed)), N, 1000, 0, false)
+
+		//N10 only measured N0 and N1, consistently with their true latency
+		trueLatency := chain.Blocks[0].Latencies["N1"]
+		chain.Blocks = append(chain.Blocks, &Block{
+			ID: &NodeID{PublicKey: []byte(numbersToNodes(N))},
+			Latencies: map[string]ConfirmedLatency{
+				"N0": trueLatency,
+				"N1": trueLatency,
+			},
+		})
+
+		//N0 lies about all its latencies, so that the only triangle of N10 breaks the triangle inequality
+		for _, block := range chain.Blocks[1:N] {
+			key := string(block.ID.PublicKey)
+			latency := chain.Blocks[0].Latencies[key]
+			latency.Latency *= 10
+			chain.Blocks[0].Latencies[key] = latency
+			block.Latencies["N0"] = latency
+		}
+
+		blacklist, err := CreateBlacklist(chain, d, false, UpperThresholdPolicy{}, false)
+		require.NoError(t, err)
+		require.True(t, blacklist.ContainsAsString("N0"), "seed %d: %s", seed, blacklist.ToString())
+		require.False(t, blacklist.ContainsAsString(numbersToNodes(N)), "seed %d: %s", seed, blacklist.ToString())
+
+		//its strikes are scaled up, but no more than maxStrikeScale times
+		unthresholded, err := CreateBlacklist(chain, d, false, FixedThreshold(0), false)
+		require.NoError(t, err)
+		nbStrikes := unthresholded.NbStrikesOf(numbersToNodes(N))
+		require.True(t, nbStrikes > 0, "seed %d", seed)
+		require.True(t, nbStrikes <= 2*maxStrikeScale, "seed %d", seed)
+
+		requireSameBlacklists(t, NewBlacklistIndex(chain, NewTriangleTolerance(d, 0)), chain)
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"sort"
 	"strconv"
+	"strings"
 
 	sigAlg "golang.org/x/crypto/ed25519"
 )
@@ -18,12 +19,17 @@ import (
 //Blacklistset is a set of public keys corresponding to blacklisted nodes, with the number of Strikes against them
 type Blacklistset struct {
 	Strikes map[string]int
+	//SuspectedSybils holds the nodes suspected to be run by a single operator, which are not blacklisted for it
+	SuspectedSybils map[string]bool
+	//Evidence holds why each node is blacklisted, only when the blacklist is created with CreateExplainedBlacklist
+	Evidence map[string]*NodeEvidence
 }
 
 //NewBlacklistset constructs a new blacklistset
 func NewBlacklistset() Blacklistset {
 	return Blacklistset{
-		make(map[string]int, 0),
+		Strikes:         make(map[string]int, 0),
+		SuspectedSybils: make(map[string]bool),
 	}
 }
 
@@ -53,9 +59,15 @@ func (set *Blacklistset) AddWithStrikes(key sigAlg.PublicKey, Strikes int) {
 	set.AddWithStrikesStringKey(string(key), Strikes)
 }
 
-//Remove removes a node's public key to a blacklist
+//Remove removes a node's public key from a blacklist, along with the evidence against it
 func (set *Blacklistset) Remove(key sigAlg.PublicKey) {
-	set.Strikes[string(key)] = 0
+	set.RemoveStringKey(string(key))
+}
+
+//RemoveStringKey removes a node's public key as a string from a blacklist, along with the evidence against it
+func (set *Blacklistset) RemoveStringKey(key string) {
+	delete(set.Strikes, key)
+	delete(set.Evidence, key)
 }
 
 //Contains check if a node is balcklisted
@@ -110,46 +122,31 @@ func (set *Blacklistset) IsEmpty() bool {
 	return set.Size() <= 0
 }
 
-//Equals checks if two sets have the same content
+//Equals checks if two sets blacklist the same nodes with the same strikes and suspect the same sybils
 func (set *Blacklistset) Equals(otherset *Blacklistset) bool {
 
-	// If one is nil, the other must also be nil.
-	if (set == nil) != (otherset == nil) {
-		return false
-	}
-
-	if set.Size() != otherset.Size() {
-		return false
+	if set == nil || otherset == nil || !set.NodesEqual(otherset) {
+		return set == otherset
 	}
 
-	for key, nbStrikes := range set.Strikes {
-		if otherset.Strikes[key] != nbStrikes {
-			return false
-		}
-	}
-
-	return true
+	equal := true
+	set.ForEach(func(key string, nbStrikes int) {
+		equal = equal && otherset.Strikes[key] == nbStrikes
+	})
+	return equal
 }
 
-//NodesEqual checks if two sets have the same content
+//NodesEqual checks if two sets blacklist the same nodes and suspect the same sybils, whatever their strikes
 func (set *Blacklistset) NodesEqual(otherset *Blacklistset) bool {
 
 	// If one is nil, the other must also be nil.
-	if (set == nil) != (otherset == nil) {
-		return false
-	}
-
-	if set.Size() != otherset.Size() {
-		return false
-	}
-
-	for key, nbStrikes := range set.Strikes {
-		if otherset.Strikes[key] != nbStrikes && (otherset.Strikes[key] == 0 || nbStrikes == 0) {
-			return false
-		}
+	if set == nil || otherset == nil {
+		return set == otherset
 	}
 
-	return true
+	missing := otherset.Difference(set)
+	extra := set.Difference(otherset)
+	return missing.IsEmpty() && extra.IsEmpty() && len(missing.SuspectedSybils) == 0 && len(extra.SuspectedSybils) == 0
 }
 
 //ToString returns a string format of the Strikes
@@ -158,20 +155,10 @@ func (set *Blacklistset) ToString() string {
 		return "Blacklist empty\n"
 	}
 
-	keys := make([]string, 0, len(set.Strikes))
-	for key := range set.Strikes {
-		keys = append(keys, key)
-	}
-
-	sort.Strings(keys)
-
 	str := "\n"
-	for _, key := range keys {
-		val := set.Strikes[key]
-		if val > 0 {
-			str += key + ": " + strconv.Itoa(val) + "\n"
-		}
-	}
+	set.ForEach(func(key string, nbStrikes int) {
+		str += key + ": " + strconv.Itoa(nbStrikes) + "\n"
+	})
 	return str
 }
 
@@ -181,17 +168,7 @@ func (set *Blacklistset) NodesToString() string {
 	if set.Size() == 0 {
 		return "Blacklist Empty"
 	}
-	keys := make([]string, 0, len(set.Strikes))
-	for key := range set.Strikes {
-		keys = append(keys, key)
-	}
-
-	sort.Strings(keys)
-	str := ""
-	for _, key := range keys {
-		str += key + "-"
-	}
-	return str[:len(str)-1]
+	return strings.Join(set.Keys(), "-")
 }
 
 //PrintDifferencesTo returns a string showing the differences between two blacklistsets
@@ -221,6 +198,34 @@ func (set *Blacklistset) CombineWith(other *Blacklistset) {
 	for k, v := range other.Strikes {
 		set.AddWithStrikesStringKey(k, v)
 	}
+	for k := range other.SuspectedSybils {
+		set.AddSuspectedSybil(k)
+	}
+	for k, evidence := range other.Evidence {
+		set.addNodeEvidence(k, evidence)
+	}
+}
+
+//AddSuspectedSybil marks a node as a suspected sybil, without giving it strikes
+func (set *Blacklistset) AddSuspectedSybil(key string) {
+	if set.SuspectedSybils == nil {
+		set.SuspectedSybils = make(map[string]bool)
+	}
+	set.SuspectedSybils[key] = true
+}
+
+//AddSybilClusters marks all the nodes of the clusters as suspected sybils
+func (set *Blacklistset) AddSybilClusters(clusters []SybilCluster) {
+	for _, cluster := range clusters {
+		for _, key := range cluster.Nodes {
+			set.AddSuspectedSybil(key)
+		}
+	}
+}
+
+//IsSuspectedSybil checks if a node is suspected to be a sybil
+func (set *Blacklistset) IsSuspectedSybil(key string) bool {
+	return set.SuspectedSybils[key]
 }
 
 //NbStrikesOf returns the number of strikes of a given node
@@ -231,3 +236,81 @@ func (set *Blacklistset) NbStrikesOf(node string) int {
 	}
 	return strikes
 }
+
+//Keys returns the blacklisted nodes, those with strikes, sorted
+func (set *Blacklistset) Keys() []string {
+	keys := make([]string, 0, len(set.Strikes))
+	for key, nbStrikes := range set.Strikes {
+		if nbStrikes > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//ForEach calls f on each blacklisted node with its number of strikes, in the order of Keys
+func (set *Blacklistset) ForEach(f func(key string, nbStrikes int)) {
+	for _, key := range set.Keys() {
+		f(key, set.Strikes[key])
+	}
+}
+
+//Copy returns a copy of the blacklist which can be modified without modifying the blacklist
+func (set *Blacklistset) Copy() Blacklistset {
+	copied := NewBlacklistset()
+	copied.CombineWith(set)
+	return copied
+}
+
+//Union returns a new blacklist with the nodes of both blacklists, a node blacklisted in both having the sum of its strikes,
+//as with CombineWith
+func (set *Blacklistset) Union(other *Blacklistset) Blacklistset {
+	union := set.Copy()
+	union.CombineWith(other)
+	return union
+}
+
+//Intersection returns a new blacklist with the nodes blacklisted in both blacklists, with the sum of their strikes,
+//and the nodes suspected to be sybils in both
+func (set *Blacklistset) Intersection(other *Blacklistset) Blacklistset {
+	intersection := NewBlacklistset()
+	set.ForEach(func(key string, nbStrikes int) {
+		if other.ContainsAsString(key) {
+			intersection.AddWithStrikesStringKey(key, nbStrikes+other.Strikes[key])
+			intersection.copyEvidenceOf(key, set)
+			intersection.copyEvidenceOf(key, other)
+		}
+	})
+	for key := range set.SuspectedSybils {
+		if other.IsSuspectedSybil(key) {
+			intersection.AddSuspectedSybil(key)
+		}
+	}
+	return intersection
+}
+
+//Difference returns a new blacklist with the nodes blacklisted in the blacklist but not in the other one, with their
+//strikes in the blacklist, and the nodes suspected to be sybils in the blacklist only
+func (set *Blacklistset) Difference(other *Blacklistset) Blacklistset {
+	difference := NewBlacklistset()
+	set.ForEach(func(key string, nbStrikes int) {
+		if !other.ContainsAsString(key) {
+			difference.AddWithStrikesStringKey(key, nbStrikes)
+			difference.copyEvidenceOf(key, set)
+		}
+	})
+	for key := range set.SuspectedSybils {
+		if !other.IsSuspectedSybil(key) {
+			difference.AddSuspectedSybil(key)
+		}
+	}
+	return difference
+}
+
+//copyEvidenceOf adds the evidence another blacklist holds against a node, if any
+func (set *Blacklistset) copyEvidenceOf(key string, other *Blacklistset) {
+	if evidence, here := other.Evidence[key]; here {
+		set.addNodeEvidence(key, evidence)
+	}
+}
@@ -0,0 +1,101 @@
+package latencyprotocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlacklistsetAlgebra(t *testing.T) {
+
+	A := NewBlacklistset()
+	A.AddWithStrikesStringKey("N0", 3)
+	A.AddWithStrikesStringKey("N1", 2)
+	A.AddWithStrikesStringKey("N2", 0)
+	A.AddSuspectedSybil("N5")
+	A.addNodeEvidence("N0", &NodeEvidence{Triangles: []EvidenceTriangle{{B: "N0", C: "N1", D: "N3"}}})
+
+	B := NewBlacklistset()
+	B.AddWithStrikesStringKey("N1", 1)
+	B.AddWithStrikesStringKey("N3", 4)
+	B.AddSuspectedSybil("N5")
+	B.AddSuspectedSybil("N6")
+
+	require.Equal(t, []string{"N0", "N1"}, A.Keys())
+
+	visited := make([]string, 0)
+	A.ForEach(func(key string, nbStrikes int) {
+		visited = append(visited, key)
+		require.Equal(t, A.Strikes[key], nbStrikes)
+	})
+	require.Equal(t, A.Keys(), visited)
+
+	union := A.Union(&B)
+	require.Equal(t, []string{"N0", "N1", "N3"}, union.Keys())
+	require.Equal(t, 3, union.NbStrikesOf("N1"))
+	require.True(t, union.IsSuspectedSybil("N6"))
+	require.Equal(t, 1, len(union.Evidence["N0"].Triangles))
+
+	intersection := A.Intersection(&B)
+	require.Equal(t, []string{"N1"}, intersection.Keys())
+	require.Equal(t, 3, intersection.NbStrikesOf("N1"))
+	require.True(t, intersection.IsSuspectedSybil("N5"))
+	require.False(t, intersection.IsSuspectedSybil("N6"))
+
+	difference := A.Difference(&B)
+	require.Equal(t, []string{"N0"}, difference.Keys())
+	require.Equal(t, 3, difference.NbStrikesOf("N0"))
+	require.Empty(t, difference.SuspectedSybils)
+	require.NotNil(t, difference.Evidence["N0"])
+
+	//the operations do not modify their operands
+	require.Equal(t, 2, A.NbStrikesOf("N1"))
+	require.False(t, A.IsSuspectedSybil("N6"))
+
+	copied := A.Copy()
+	require.True(t, copied.Equals(&A))
+	copied.AddWithStrikesStringKey("N1", 1)
+	require.Equal(t, 2, A.NbStrikesOf("N1"))
+
+	copied.RemoveStringKey("N0")
+	_, here := copied.Strikes["N0"]
+	require.False(t, here)
+	require.Nil(t, copied.Evidence["N0"])
+	require.NotNil(t, A.Evidence["N0"])
+	require.Equal(t, "N1", copied.NodesToString())
+}
+
+func TestBlacklistsetEquality(t *testing.T) {
+
+	A := NewBlacklistset()
+	A.AddWithStrikesStringKey("N0", 3)
+	A.AddWithStrikesStringKey("N1", 2)
+	A.AddSuspectedSybil("N5")
+
+	//nodes without strikes are not blacklisted
+	B := A.Copy()
+	B.AddWithStrikesStringKey("N2", 0)
+	require.True(t, A.Equals(&B))
+	require.True(t, A.NodesEqual(&B))
+
+	//the same nodes with other strikes
+	B.AddWithStrikesStringKey("N1", 5)
+	require.False(t, A.Equals(&B))
+	require.True(t, A.NodesEqual(&B))
+	require.True(t, B.NodesEqual(&A))
+
+	//another node, or another suspected sybil
+	C := A.Copy()
+	C.AddWithStrikesStringKey("N3", 1)
+	require.False(t, A.NodesEqual(&C))
+	require.False(t, C.NodesEqual(&A))
+	D := A.Copy()
+	D.AddSuspectedSybil("N6")
+	require.False(t, A.Equals(&D))
+	require.False(t, A.NodesEqual(&D))
+
+	var none *Blacklistset
+	require.True(t, none.Equals(nil))
+	require.False(t, A.Equals(none))
+	require.False(t, none.NodesEqual(&A))
+}
@@ -0,0 +1,215 @@
+/*
+chain links blocks together: each block carries its height in the chain and the hash of the block before it
+*/
+
+package latencyprotocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"time"
+)
+
+//BlockHeader is the part of a block which is collectively signed: the latencies of the block are committed to
+//by the root of their Merkle tree, so that a single latency can be checked against the header without the whole block
+type BlockHeader struct {
+	ID            *NodeID
+	Index         int
+	PreviousHash  []byte
+	CreatedAt     time.Time
+	LatenciesRoot []byte
+	//Kind, NewKey and the signatures of the node are empty for latency blocks
+	Kind            BlockKind
+	NewKey          []byte
+	NodeSignature   []byte
+	NewKeySignature []byte
+	//Admission is empty for the blocks following the first block of a node
+	Admission []byte
+}
+
+//Header returns the header of a block
+func (block *Block) Header() (*BlockHeader, error) {
+
+	if block == nil || block.ID == nil {
+		return nil, errors.New("Cannot hash a block without identity")
+	}
+
+	return &BlockHeader{
+		ID:              block.ID,
+		Index:           block.Index,
+		PreviousHash:    block.PreviousHash,
+		CreatedAt:       block.CreatedAt,
+		LatenciesRoot:   block.LatenciesRoot(),
+		Kind:            block.Kind,
+		NewKey:          block.NewKey,
+		NodeSignature:   block.NodeSignature,
+		NewKeySignature: block.NewKeySignature,
+		Admission:       block.Admission,
+	}, nil
+}
+
+//Hash computes the canonical hash of a header, which is the message signed by the validators
+func (header *BlockHeader) Hash() []byte {
+
+	h := sha256.New()
+
+	writeInt(h, int64(header.Index))
+	writeBytes(h, header.PreviousHash)
+	writeInt(h, header.CreatedAt.UnixNano())
+	writeBytes(h, header.ID.PublicKey)
+	if header.ID.ServerID != nil {
+		writeBytes(h, []byte(header.ID.ServerID.Address))
+	} else {
+		writeBytes(h, nil)
+	}
+	writeBytes(h, header.LatenciesRoot)
+	writeInt(h, int64(header.Kind))
+	writeBytes(h, header.NewKey)
+	writeBytes(h, header.NodeSignature)
+	writeBytes(h, header.NewKeySignature)
+	writeBytes(h, header.Admission)
+
+	return h.Sum(nil)
+}
+
+//Hash computes the canonical hash of a block, which is the hash of its header. It covers every field of the block
+//except its collective signature, and does not depend on the order of the latencies in the map
+func (block *Block) Hash() ([]byte, error) {
+
+	header, err := block.Header()
+	if err != nil {
+		return nil, err
+	}
+
+	return header.Hash(), nil
+}
+
+//writeInt writes a fixed-size integer to the hash
+func writeInt(h hash.Hash, value int64) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(value))
+	h.Write(buf)
+}
+
+//writeBytes writes a length-prefixed slice to the hash so that consecutive fields cannot be confused
+func writeBytes(h hash.Hash, value []byte) {
+	writeInt(h, int64(len(value)))
+	h.Write(value)
+}
+
+//nbCheckpointBlocks returns how many blocks at the start of the chain come from its checkpoint
+func (chain *Chain) nbCheckpointBlocks() int {
+	if chain.Checkpoint == nil {
+		return 0
+	}
+	return len(chain.Checkpoint.Blocks)
+}
+
+//Height returns the number of blocks in the chain, including the blocks pruned before its checkpoint
+func (chain *Chain) Height() int {
+	if chain.Checkpoint == nil {
+		return len(chain.Blocks)
+	}
+	return chain.Checkpoint.Height + len(chain.Blocks) - len(chain.Checkpoint.Blocks)
+}
+
+//BlockAt returns the block at the given height, or nil if there is no such block or it was pruned
+func (chain *Chain) BlockAt(height int) *Block {
+	position := height
+	if chain.Checkpoint != nil {
+		if height < chain.Checkpoint.Height {
+			return nil
+		}
+		position = height - chain.Checkpoint.Height + len(chain.Checkpoint.Blocks)
+	}
+	if position < 0 || position >= len(chain.Blocks) {
+		return nil
+	}
+	return chain.Blocks[position]
+}
+
+//LastHash returns the hash of the last block of the chain, or nil if the chain is empty
+func (chain *Chain) LastHash() ([]byte, error) {
+	if len(chain.Blocks) == chain.nbCheckpointBlocks() {
+		if chain.Checkpoint != nil {
+			return chain.Checkpoint.LastHash, nil
+		}
+		return nil, nil
+	}
+	return chain.Blocks[len(chain.Blocks)-1].Hash()
+}
+
+//lastCreatedAt returns the creation time of the last block of the chain
+func (chain *Chain) lastCreatedAt() time.Time {
+	if len(chain.Blocks) == chain.nbCheckpointBlocks() {
+		if chain.Checkpoint != nil {
+			return chain.Checkpoint.LastCreatedAt
+		}
+		return time.Time{}
+	}
+	return chain.Blocks[len(chain.Blocks)-1].CreatedAt
+}
+
+//Link prepares a block to be appended at the end of the chain: it sets its index, the hash of the previous block
+//and its creation time. The block has to be linked before being signed, since the signature covers these fields
+func (chain *Chain) Link(block *Block) error {
+
+	previousHash, err := chain.LastHash()
+	if err != nil {
+		return err
+	}
+
+	createdAt := time.Now()
+	if previous := chain.lastCreatedAt(); createdAt.Before(previous) {
+		createdAt = previous
+	}
+
+	block.Index = chain.Height()
+	block.PreviousHash = previousHash
+	block.CreatedAt = createdAt
+
+	return nil
+}
+
+//Append adds a block at the end of the chain after checking that it is linked to the last block
+func (chain *Chain) Append(block *Block) error {
+
+	if block == nil || block.ID == nil {
+		return errors.New("Block without identity")
+	}
+
+	err := chain.verifyLink(block)
+	if err != nil {
+		return err
+	}
+
+	chain.Blocks = append(chain.Blocks, block)
+
+	return nil
+}
+
+//verifyLink checks that a block follows the last block of the chain, and rejects it with BrokenLink otherwise
+func (chain *Chain) verifyLink(block *Block) error {
+
+	if block.Index != chain.Height() {
+		return reject(BrokenLink, "Block index does not follow the chain")
+	}
+
+	previousHash, err := chain.LastHash()
+	if err != nil {
+		return reject(BrokenLink, err.Error())
+	}
+
+	if !bytes.Equal(block.PreviousHash, previousHash) {
+		return reject(BrokenLink, "Block is not linked to the last block of the chain")
+	}
+
+	if block.CreatedAt.Before(chain.lastCreatedAt()) {
+		return reject(BrokenLink, "Block created before the last block of the chain")
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+/*
+chain_test tests the linking of blocks in a chain
+*/
+
+package latencyprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+//linkedChain appends nbBlocks linked blocks to an empty chain
+func linkedChain(t *testing.T, nbBlocks int) *Chain {
+	chain := &Chain{Blocks: []*Block{}, BucketName: []byte("testBucket")}
+
+	for i := 0; i < nbBlocks; i++ {
+		latencies := make(map[string]ConfirmedLatency)
+		for j := 0; j < i; j++ {
+			latencies[numbersToNodes(j)] = ConfirmedLatency{time.Duration(10 * (i + j)), nil, time.Now(), nil, 0}
+		}
+		block := &Block{ID: &NodeID{PublicKey: sigAlg.PublicKey(numbersToNodes(i))}, Latencies: latencies}
+
+		require.NoError(t, chain.Link(block))
+		require.NoError(t, chain.Append(block))
+	}
+
+	return chain
+}
+
+func TestBlockHash(t *testing.T) {
+
+	chain := linkedChain(t, 5)
+	block := chain.Blocks[4]
+
+	hash, err := block.Hash()
+	require.NoError(t, err)
+
+	//the hash does not depend on the signature nor on the order of the latencies
+	signed := *block
+	signed.Signature = []byte("signature")
+	signed.Latencies = make(map[string]ConfirmedLatency)
+	for i := 3; i >= 0; i-- {
+		signed.Latencies[numbersToNodes(i)] = block.Latencies[numbersToNodes(i)]
+	}
+	signedHash, err := signed.Hash()
+	require.NoError(t, err)
+	require.Equal(t, hash, signedHash)
+
+	//but it covers the link to the previous block and the latencies
+	modified := signed
+	modified.Index++
+	modifiedHash, err := modified.Hash()
+	require.NoError(t, err)
+	require.NotEqual(t, hash, modifiedHash)
+
+	modified = signed
+	modified.PreviousHash = []byte("other")
+	modifiedHash, err = modified.Hash()
+	require.NoError(t, err)
+	require.NotEqual(t, hash, modifiedHash)
+
+	modified = signed
+	modified.Latencies = map[string]ConfirmedLatency{numbersToNodes(0): {Latency: 1}}
+	modifiedHash, err = modified.Hash()
+	require.NoError(t, err)
+	require.NotEqual(t, hash, modifiedHash)
+
+	//as well as every other field of the header, whatever the kind of the block
+	modified = signed
+	modified.Kind = LeaveBlock
+	modifiedHash, err = modified.Hash()
+	require.NoError(t, err)
+	require.NotEqual(t, hash, modifiedHash)
+
+	modified = signed
+	modified.Admission = []byte("proof")
+	admittedHash, err := modified.Hash()
+	require.NoError(t, err)
+	require.NotEqual(t, hash, admittedHash)
+
+	modified = signed
+	modified.NewKey = []byte("proof")
+	modifiedHash, err = modified.Hash()
+	require.NoError(t, err)
+	require.NotEqual(t, hash, modifiedHash)
+	require.NotEqual(t, admittedHash, modifiedHash)
+
+	_, err = (&Block{}).Hash()
+	require.Error(t, err)
+}
+
+func TestChainAppend(t *testing.T) {
+
+	chain := linkedChain(t, 3)
+
+	require.Equal(t, 3, chain.Height())
+	require.Nil(t, chain.Blocks[0].PreviousHash)
+	for i, block := range chain.Blocks {
+		require.Equal(t, i, block.Index)
+		if i > 0 {
+			previousHash, err := chain.Blocks[i-1].Hash()
+			require.NoError(t, err)
+			require.Equal(t, previousHash, block.PreviousHash)
+			require.False(t, block.CreatedAt.Before(chain.Blocks[i-1].CreatedAt))
+		}
+	}
+
+	newBlock := func() *Block {
+		block := &Block{ID: &NodeID{PublicKey: sigAlg.PublicKey(numbersToNodes(3))}, Latencies: make(map[string]ConfirmedLatency)}
+		require.NoError(t, chain.Link(block))
+		return block
+	}
+
+	wrongIndex := newBlock()
+	wrongIndex.Index = 2
+	require.Error(t, chain.Append(wrongIndex))
+
+	wrongHash := newBlock()
+	wrongHash.PreviousHash = chain.Blocks[0].PreviousHash
+	require.Error(t, chain.Append(wrongHash))
+
+	tooEarly := newBlock()
+	tooEarly.CreatedAt = chain.Blocks[2].CreatedAt.Add(-time.Second)
+	require.Error(t, chain.Append(tooEarly))
+
+	require.Error(t, chain.Append(&Block{Index: 3}))
+	require.Equal(t, 3, chain.Height())
+
+	require.NoError(t, chain.Append(newBlock()))
+	require.Equal(t, 4, chain.Height())
+}
@@ -0,0 +1,277 @@
+/*
+collusion looks for coalitions of liars agreeing on their lies. SuspectIsLiar counts the accusers of a suspect
+independently, so liars who back each other's latencies can keep enough non-accusers to evade it
+
+A coalition tells the same lies: its members' latencies to each other and to a same node are consistent, while their
+latencies are not consistent with the latencies of the honest majority. The triangles with two members and another node
+then hold, while the triangles with one member and two other nodes break the triangle inequality. The nodes are split
+accordingly:
+
+	starting with every node in the majority, the node whose move to the minority explains the most failing triangles,
+	those with exactly one node in the minority, is moved, as long as moving a node explains some more and the
+	minority stays smaller than the majority
+	two nodes of the minority agree if at most MaxPairViolations of the triangles they form with a third node fail
+	the groups of nodes of the minority which agree, directly or through other nodes, are the suspected coalitions
+*/
+
+package latencyprotocol
+
+import (
+	"sort"
+)
+
+//CollusionParams holds how coalitions are detected
+type CollusionParams struct {
+	//Tolerance is the margin of the triangles examined
+	Tolerance TriangleTolerance
+	//MaxPairViolations is the fraction of their triangles which can fail for two nodes to agree
+	MaxPairViolations float64
+	//MinSize is the number of nodes under which a group is not reported as a coalition
+	MinSize int
+}
+
+//DefaultCollusionParams returns the parameters used by default
+func DefaultCollusionParams() CollusionParams {
+	return CollusionParams{
+		Tolerance:         TriangleTolerance{},
+		MaxPairViolations: 0.1,
+		MinSize:           2,
+	}
+}
+
+//Coalition is a group of nodes suspected to coordinate their lies
+type Coalition struct {
+	//Nodes are the public keys of the nodes of the coalition, sorted
+	Nodes []string
+	//ExternalViolations is the number of failing triangles made of one node of the coalition and two other nodes
+	ExternalViolations int
+}
+
+//failingTriangle is a triangle breaking the triangle inequality, as the sorted indices of its nodes
+type failingTriangle [3]int
+
+//DetectCoalitions returns the suspected coalitions among the active nodes of the chain, ordered by their first node
+func (chain *Chain) DetectCoalitions(params CollusionParams) []Coalition {
+
+	chain = chain.LatestChain()
+
+	blockMapper := make(map[string]*Block)
+	for _, block := range chain.Blocks {
+		blockMapper[string(block.ID.PublicKey)] = block
+	}
+
+	keys := make([]string, 0, len(blockMapper))
+	for key := range blockMapper {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	index := make(map[string]int, len(keys))
+	for i, key := range keys {
+		index[key] = i
+	}
+
+	//each triangle is examined once for each of its nodes giving latencies, it is only kept once
+	count := triangleStrikes(chain.Blocks, blockMapper, params.Tolerance, true, 1)
+	unique := make(map[failingTriangle]bool)
+	for _, violation := range count.violations {
+		nodes := []int{index[violation.B], index[violation.C], index[violation.D]}
+		sort.Ints(nodes)
+		unique[failingTriangle{nodes[0], nodes[1], nodes[2]}] = true
+	}
+	triangles := make([]failingTriangle, 0, len(unique))
+	for triangle := range unique {
+		triangles = append(triangles, triangle)
+	}
+
+	minority := splitMinority(triangles, len(keys))
+
+	blocks := make([]*Block, len(keys))
+	for i, key := range keys {
+		blocks[i] = blockMapper[key]
+	}
+
+	coalitions := make([]Coalition, 0)
+	for _, group := range agreeingGroups(minority, unique, blocks, params.MaxPairViolations) {
+		if len(group) < params.MinSize {
+			continue
+		}
+		coalitions = append(coalitions, newCoalition(group, triangles, keys))
+	}
+
+	sort.Slice(coalitions, func(i, j int) bool {
+		return coalitions[i].Nodes[0] < coalitions[j].Nodes[0]
+	})
+
+	return coalitions
+}
+
+//splitMinority moves nodes from the majority to the minority as long as it explains more failing triangles,
+//a failing triangle being explained when exactly one of its nodes is in the minority
+func splitMinority(triangles []failingTriangle, N int) []bool {
+
+	minority := make([]bool, N)
+	size := 0
+
+	for 2*(size+1) < N {
+		//moving a node explains the triangles it forms with two nodes of the majority,
+		//and no longer explains the ones it forms with one node of the minority
+		gains := make([]int, N)
+		for _, triangle := range triangles {
+			for i, node := range triangle {
+				if minority[node] {
+					continue
+				}
+				nbOthers := 0
+				if minority[triangle[(i+1)%3]] {
+					nbOthers++
+				}
+				if minority[triangle[(i+2)%3]] {
+					nbOthers++
+				}
+				switch nbOthers {
+				case 0:
+					gains[node]++
+				case 1:
+					gains[node]--
+				}
+			}
+		}
+
+		best := -1
+		for node, gain := range gains {
+			if !minority[node] && gain > 0 && (best < 0 || gain > gains[best]) {
+				best = node
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		minority[best] = true
+		size++
+	}
+
+	return minority
+}
+
+//agreeingGroups returns the connected components of the nodes of the minority which agree with each other
+func agreeingGroups(minority []bool, failing map[failingTriangle]bool, blocks []*Block, maxPairViolations float64) [][]int {
+
+	members := make([]int, 0)
+	for node, inMinority := range minority {
+		if inMinority {
+			members = append(members, node)
+		}
+	}
+
+	//the groups are merged with a union-find
+	parent := make(map[int]int, len(members))
+	var find func(node int) int
+	find = func(node int) int {
+		if parent[node] != node {
+			parent[node] = find(parent[node])
+		}
+		return parent[node]
+	}
+	for _, node := range members {
+		parent[node] = node
+	}
+
+	for i, A := range members {
+		for _, B := range members[i+1:] {
+			if agree(A, B, failing, blocks, maxPairViolations) {
+				parent[find(A)] = find(B)
+			}
+		}
+	}
+
+	groups := make(map[int][]int)
+	for _, node := range members {
+		root := find(node)
+		groups[root] = append(groups[root], node)
+	}
+
+	sorted := make([][]int, 0, len(groups))
+	for _, group := range groups {
+		sorted = append(sorted, group)
+	}
+	return sorted
+}
+
+//agree tells whether two nodes give each other a latency and few of the triangles they form with a third node fail
+func agree(A int, B int, failing map[failingTriangle]bool, blocks []*Block, maxPairViolations float64) bool {
+
+	if _, here := sideBetween(blocks[A], blocks[B]); !here {
+		return false
+	}
+
+	nbTriangles := 0
+	nbFailing := 0
+	for C := range blocks {
+		if C == A || C == B {
+			continue
+		}
+		_, ACHere := sideBetween(blocks[A], blocks[C])
+		_, BCHere := sideBetween(blocks[B], blocks[C])
+		if !ACHere || !BCHere {
+			continue
+		}
+		nbTriangles++
+
+		nodes := []int{A, B, C}
+		sort.Ints(nodes)
+		if failing[failingTriangle{nodes[0], nodes[1], nodes[2]}] {
+			nbFailing++
+		}
+	}
+
+	return nbTriangles > 0 && float64(nbFailing) <= maxPairViolations*float64(nbTriangles)
+}
+
+//newCoalition creates the coalition of a group of nodes, counting the failing triangles it explains
+func newCoalition(group []int, triangles []failingTriangle, keys []string) Coalition {
+
+	inGroup := make(map[int]bool, len(group))
+	nodes := make([]string, len(group))
+	for i, node := range group {
+		inGroup[node] = true
+		nodes[i] = keys[node]
+	}
+	sort.Strings(nodes)
+
+	external := 0
+	for _, triangle := range triangles {
+		nbIn := 0
+		for _, node := range triangle {
+			if inGroup[node] {
+				nbIn++
+			}
+		}
+		if nbIn == 1 {
+			external++
+		}
+	}
+
+	return Coalition{nodes, external}
+}
+
+//CoalitionDetector blacklists the members of suspected coalitions, with the failing triangles of their coalition as strikes
+type CoalitionDetector struct {
+	Params CollusionParams
+}
+
+//NewCoalitionDetector creates a coalition detector
+func NewCoalitionDetector(params CollusionParams) *CoalitionDetector {
+	return &CoalitionDetector{params}
+}
+
+//Detect blacklists the members of the coalitions of the chain
+func (detector *CoalitionDetector) Detect(chain *Chain) (Blacklistset, error) {
+	blacklist := NewBlacklistset()
+	for _, coalition := range chain.DetectCoalitions(detector.Params) {
+		for _, node := range coalition.Nodes {
+			blacklist.AddWithStrikesStringKey(node, coalition.ExternalViolations)
+		}
+	}
+	return blacklist, nil
+}
@@ -0,0 +1,97 @@
+package latencyprotocol
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+//coordinatedCoalition makes the first nbColluders nodes claim half their latencies, to each other as well
+func coordinatedCoalition(chain *Chain, nbColluders int) {
+	N := len(chain.Blocks)
+	for l := 0; l < nbColluders; l++ {
+		for j := l + 1; j < N; j++ {
+			latency := chain.Blocks[l].Latencies[numbersToNodes(j)].Latency
+			setLiarAndVictim(chain, numbersToNodes(l), numbersToNodes(j), latency/2)
+		}
+	}
+}
+
+//collusionSeeds are the seeds of the random chains the coalition detection is checked on
+var collusionSeeds = []int64{1, 2, 3, 4, 5, 6, 7, 8}
+
+func TestDetectCoalitions(t *testing.T) {
+	N := 21
+	params := DefaultCollusionParams()
+	params.Tolerance = NewTriangleTolerance(20, 0)
+
+	for _, seed := range collusionSeeds {
+		chain := chainWithNoisyLatenciesFrom(rand.New(rand.NewSource(seed)), N, 1000, 5, false)
+		require.Empty(t, chain.DetectCoalitions(params), "seed %d", seed)
+
+		coordinatedCoalition(chain, 4)
+
+		coalitions := chain.DetectCoalitions(params)
+		require.Equal(t, 1, len(coalitions), "seed %d", seed)
+		require.Equal(t, []string{"N0", "N1", "N2", "N3"}, coalitions[0].Nodes, "seed %d", seed)
+		require.True(t, coalitions[0].ExternalViolations > 0, "seed %d", seed)
+
+		params.MinSize = 5
+		require.Empty(t, chain.DetectCoalitions(params), "seed %d", seed)
+		params.MinSize = DefaultCollusionParams().MinSize
+	}
+}
+
+func TestIndependentLiarsAreNoCoalition(t *testing.T) {
+	N := 21
+	params := DefaultCollusionParams()
+	params.Tolerance = NewTriangleTolerance(20, 0)
+
+	for _, seed := range collusionSeeds {
+		rng := rand.New(rand.NewSource(seed))
+		chain := chainWithNoisyLatenciesFrom(rng, N, 1000, 5, false)
+
+		//each liar lies on its own, by up to 600 in either direction
+		for l := 0; l < 3; l++ {
+			for j := 0; j < N; j++ {
+				if j == l {
+					continue
+				}
+				latency := chain.Blocks[l].Latencies[numbersToNodes(j)].Latency
+				lie := time.Duration(rng.Intn(600))
+				if rng.Intn(2) == 0 && latency > lie {
+					lie = -lie
+				}
+				setLiarAndVictim(chain, numbersToNodes(l), numbersToNodes(j), latency+lie)
+			}
+		}
+
+		require.Empty(t, chain.DetectCoalitions(params), "seed %d", seed)
+	}
+}
+
+func TestCoalitionDetector(t *testing.T) {
+	N := 21
+	params := DefaultCollusionParams()
+	params.Tolerance = NewTriangleTolerance(20, 0)
+
+	for _, seed := range collusionSeeds {
+		chain := chainWithNoisyLatenciesFrom(rand.New(rand.NewSource(seed)), N, 1000, 5, false)
+		coordinatedCoalition(chain, 4)
+
+		blacklist, err := NewCoalitionDetector(params).Detect(chain)
+		require.NoError(t, err)
+		require.Equal(t, 4, blacklist.Size(), "seed %d", seed)
+		for l := 0; l < 4; l++ {
+			require.True(t, blacklist.ContainsAsString(numbersToNodes(l)), "seed %d", seed)
+		}
+
+		combined, err := NewCombinedDetector(1, NewTriangleDetector(params.Tolerance), NewCoalitionDetector(params)).Detect(chain)
+		require.NoError(t, err)
+		for l := 0; l < 4; l++ {
+			require.True(t, combined.ContainsAsString(numbersToNodes(l)), "seed %d", seed)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+detector gives a common interface to the ways of finding the nodes which lied about their latencies, so that they
+can be compared or combined:
+
+	TriangleDetector blacklists the nodes taking part in too many triangles breaking the triangle inequality
+	EmbeddingDetector blacklists the nodes whose latencies do not fit an embedding of the nodes in a low-dimensional space
+	CoalitionDetector blacklists the nodes of groups of liars agreeing on their lies, see DetectCoalitions
+	CombinedDetector blacklists the nodes blacklisted by enough of several detectors
+*/
+
+package latencyprotocol
+
+//Detector finds the nodes of a chain which probably lied about their latencies
+type Detector interface {
+	//Detect returns the blacklist of the active nodes of the chain
+	Detect(chain *Chain) (Blacklistset, error)
+}
+
+//TriangleDetector detects liars with the triangle inequality, see CreateBlacklist
+type TriangleDetector struct {
+	Tolerance    TriangleTolerance
+	Policy       ThresholdPolicy
+	WithSuspects bool
+}
+
+//NewTriangleDetector creates a triangle detector with the given tolerance, the default threshold and the suspects
+func NewTriangleDetector(tolerance TriangleTolerance) *TriangleDetector {
+	return &TriangleDetector{tolerance, UpperThresholdPolicy{}, true}
+}
+
+//Detect creates the blacklist of the chain with CreateBlacklistWithTolerance
+func (detector *TriangleDetector) Detect(chain *Chain) (Blacklistset, error) {
+	return CreateBlacklistWithTolerance(chain, detector.Tolerance, false, detector.Policy, detector.WithSuspects)
+}
+
+//CombinedDetector blacklists the nodes blacklisted by at least Quorum of its detectors, with the sum of their strikes:
+//a quorum of 1 takes the union of the blacklists, and a quorum of the number of detectors their intersection
+type CombinedDetector struct {
+	Detectors []Detector
+	Quorum    int
+}
+
+//NewCombinedDetector creates a detector combining other detectors
+func NewCombinedDetector(quorum int, detectors ...Detector) *CombinedDetector {
+	return &CombinedDetector{detectors, quorum}
+}
+
+//Detect runs all the detectors and keeps the nodes blacklisted by enough of them
+func (detector *CombinedDetector) Detect(chain *Chain) (Blacklistset, error) {
+
+	votes := make(map[string]int)
+	strikes := NewBlacklistset()
+
+	for _, other := range detector.Detectors {
+		blacklist, err := other.Detect(chain)
+		if err != nil {
+			return Blacklistset{}, err
+		}
+		for node, nbStrikes := range blacklist.Strikes {
+			if nbStrikes > 0 {
+				votes[node]++
+				strikes.AddWithStrikesStringKey(node, nbStrikes)
+			}
+		}
+	}
+
+	combined := NewBlacklistset()
+	for node, nbVotes := range votes {
+		if nbVotes >= detector.Quorum {
+			combined.AddWithStrikesStringKey(node, strikes.Strikes[node])
+		}
+	}
+	return combined, nil
+}
@@ -0,0 +1,112 @@
+package latencyprotocol
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+//detectorSeeds are the seeds of the random chains and lies the detectors are checked on
+var detectorSeeds = []int64{1, 2, 3, 4, 5, 6, 7, 8}
+
+//moderateLiar makes a node lie by 100 to 300ns on all its latencies, in both directions
+func moderateLiar(rng *rand.Rand, chain *Chain, liar int) {
+	for j := range chain.Blocks {
+		if j == liar {
+			continue
+		}
+		latency := chain.Blocks[liar].Latencies[numbersToNodes(j)].Latency
+		lie := time.Duration(100 + rng.Intn(200))
+		if rng.Intn(2) == 0 && latency > lie {
+			lie = -lie
+		}
+		setLiarAndVictim(chain, numbersToNodes(liar), numbersToNodes(j), latency+lie)
+	}
+}
+
+func TestFitEmbedding(t *testing.T) {
+	N := 20
+	noise := 5
+
+	chain := chainWithNoisyLatencies(N, 1000, noise, false)
+	embedding, err := FitEmbedding(chain, DefaultEmbeddingParams())
+	require.NoError(t, err)
+	require.Equal(t, N, len(embedding.Nodes))
+
+	//the distances of an honest chain match its latencies up to the noise
+	for _, block := range chain.Blocks {
+		for key, latency := range block.Latencies {
+			distance, here := embedding.Distance(string(block.ID.PublicKey), key)
+			require.True(t, here)
+			require.InDelta(t, float64(latency.Latency), float64(distance), float64(4*noise))
+		}
+	}
+
+	_, here := embedding.Distance("N0", "unknown")
+	require.False(t, here)
+
+	params := DefaultEmbeddingParams()
+	params.Dimensions = 0
+	_, err = FitEmbedding(chain, params)
+	require.Error(t, err)
+}
+
+func TestEmbeddingDetector(t *testing.T) {
+	N := 20
+	detector := NewEmbeddingDetector(DefaultEmbeddingParams())
+
+	for _, seed := range detectorSeeds {
+		rng := rand.New(rand.NewSource(seed))
+
+		honest := chainWithNoisyLatenciesFrom(rng, N, 1000, 5, false)
+		blacklist, err := detector.Detect(honest)
+		require.NoError(t, err)
+		require.True(t, blacklist.IsEmpty(), "seed %d: %s", seed, blacklist.ToString())
+
+		chain := chainWithNoisyLatenciesFrom(rng, N, 1000, 5, false)
+		moderateLiar(rng, chain, 0)
+
+		blacklist, err = detector.Detect(chain)
+		require.NoError(t, err)
+		require.Equal(t, 1, blacklist.Size(), "seed %d: %s", seed, blacklist.ToString())
+		require.True(t, blacklist.ContainsAsString("N0"), "seed %d", seed)
+
+		//the fit does not depend on the order of the latencies
+		again, err := detector.Detect(chain)
+		require.NoError(t, err)
+		require.True(t, again.Equals(&blacklist), "seed %d", seed)
+	}
+}
+
+func TestCombinedDetector(t *testing.T) {
+	N := 20
+
+	rng := rand.New(rand.NewSource(detectorSeeds[0]))
+	chain := chainWithNoisyLatenciesFrom(rng, N, 1000, 5, false)
+	moderateLiar(rng, chain, 0)
+
+	triangles := NewTriangleDetector(NewTriangleTolerance(10, 0))
+	embedding := NewEmbeddingDetector(DefaultEmbeddingParams())
+
+	triangleBlacklist, err := triangles.Detect(chain)
+	require.NoError(t, err)
+	embeddingBlacklist, err := embedding.Detect(chain)
+	require.NoError(t, err)
+
+	union, err := NewCombinedDetector(1, triangles, embedding).Detect(chain)
+	require.NoError(t, err)
+	intersection, err := NewCombinedDetector(2, triangles, embedding).Detect(chain)
+	require.NoError(t, err)
+
+	require.True(t, union.ContainsAsString("N0"))
+	for node := range union.Strikes {
+		require.True(t, triangleBlacklist.ContainsAsString(node) || embeddingBlacklist.ContainsAsString(node))
+		require.Equal(t, triangleBlacklist.Strikes[node]+embeddingBlacklist.Strikes[node], union.Strikes[node])
+	}
+	for node := range intersection.Strikes {
+		require.True(t, triangleBlacklist.ContainsAsString(node) && embeddingBlacklist.ContainsAsString(node))
+	}
+	require.Equal(t, triangleBlacklist.ContainsAsString("N0"), intersection.ContainsAsString("N0"))
+}
@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"time"
 
+	"go.dedis.ch/onet/v3/network"
 	sigAlg "golang.org/x/crypto/ed25519"
 )
 
@@ -176,9 +177,50 @@ func timesContradictory(time1 time.Duration, time2 time.Duration, delta time.Dur
 //ApproximateOverChain approximates a distance between two nodes over a chain
 func (chain *Chain) ApproximateOverChain(B *Node, C *Node) (time.Duration, error) {
 
+	collectedDistances, _, err := chain.collectDistances(B, C)
+	if err != nil {
+		return time.Duration(0), err
+	}
+
+	//TODO compare distances among each other
+
+	averageDistance := time.Duration(0)
+	for _, dist := range collectedDistances {
+		averageDistance += dist
+	}
+
+	return averageDistance / time.Duration(len(collectedDistances)), nil
+
+}
+
+//ApproximateOverChainWithReputation approximates a distance between two nodes over a chain as ApproximateOverChain does,
+//the estimation of each node weighing as much as its trust
+func (chain *Chain) ApproximateOverChainWithReputation(B *Node, C *Node, reputation *Reputation) (time.Duration, error) {
+
+	collectedDistances, estimators, err := chain.collectDistances(B, C)
+	if err != nil {
+		return time.Duration(0), err
+	}
+
+	weightedSum := 0.0
+	totalWeight := 0.0
+	for i, dist := range collectedDistances {
+		weight := reputation.Trust(string(estimators[i].ID.PublicKey))
+		weightedSum += weight * float64(dist)
+		totalWeight += weight
+	}
+
+	return time.Duration(weightedSum / totalWeight), nil
+}
+
+//collectDistances returns the estimations of the distance between two nodes made by the other nodes of the chain,
+//along with the blocks they were made from
+func (chain *Chain) collectDistances(B *Node, C *Node) ([]time.Duration, []*Block, error) {
+
 	collectedDistances := make([]time.Duration, 0)
+	estimators := make([]*Block, 0)
 
-	blocks := chain.Blocks
+	blocks := chain.ActiveBlocks()
 
 	var latestBlockB *Block
 	var latestBlockC *Block
@@ -186,7 +228,7 @@ func (chain *Chain) ApproximateOverChain(B *Node, C *Node) (time.Duration, error
 	bFound := false
 	cFound := false
 
-	for i := len(blocks) - 1; i >= 0 && !bFound && !cFound; i-- {
+	for i := len(blocks) - 1; i >= 0 && (!bFound || !cFound); i-- {
 		if blocks[i].ID == B.ID && !bFound {
 			latestBlockB = blocks[i]
 			bFound = true
@@ -197,35 +239,57 @@ func (chain *Chain) ApproximateOverChain(B *Node, C *Node) (time.Duration, error
 		}
 	}
 
-	if !bFound && !cFound {
-		return time.Duration(100000), errors.New("Nodes not part of chain")
+	if !bFound || !cFound {
+		return nil, nil, errors.New("Nodes not part of chain")
 	}
 
 	for _, block := range blocks {
 		if block.ID != B.ID && block.ID != C.ID {
 			distance, isValid, err := block.ApproximateDistance(latestBlockB, latestBlockC, delta)
 			if err != nil {
-				return time.Duration(0), err
+				return nil, nil, err
 			}
 			if isValid {
 				collectedDistances = append(collectedDistances, distance)
+				estimators = append(estimators, block)
 			}
 		}
 	}
 
 	if len(collectedDistances) == 0 {
-		return time.Duration(0), errors.New("No information available")
+		return nil, nil, errors.New("No information available")
 	}
 
-	//TODO compare distances among each other
+	return collectedDistances, estimators, nil
+}
 
-	averageDistance := time.Duration(0)
-	for _, dist := range collectedDistances {
-		averageDistance += dist
+//LatencyBetween returns the latency recorded in the chain between the nodes running on two given servers
+func (chain *Chain) LatencyBetween(A *network.ServerIdentity, B *network.ServerIdentity) (time.Duration, bool) {
+	blockA := chain.latestBlockOf(A)
+	blockB := chain.latestBlockOf(B)
+
+	if blockA == nil || blockB == nil {
+		return time.Duration(0), false
 	}
 
-	return averageDistance / time.Duration(len(collectedDistances)), nil
+	return blockA.to(blockB)
+}
+
+//latestBlockOf returns the most recent active block created by the node running on a given server
+func (chain *Chain) latestBlockOf(id *network.ServerIdentity) *Block {
+	if id == nil {
+		return nil
+	}
+
+	blocks := chain.ActiveBlocks()
+	for i := len(blocks) - 1; i >= 0; i-- {
+		serverID := blocks[i].ID.ServerID
+		if serverID != nil && serverID.ID.Equal(id.ID) {
+			return blocks[i]
+		}
+	}
 
+	return nil
 }
 
 type nodeTuple struct {
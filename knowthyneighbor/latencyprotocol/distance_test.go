@@ -110,3 +110,31 @@ func TestApproximateDistanceMissingInformation(t *testing.T) {
 	require.False(t, isValid)
 
 }
+
+func TestApproximateOverChainFindsBothNodes(t *testing.T) {
+
+	N := 4
+
+	chain, _ := chainWithAllLatenciesSame(N, 10)
+	delete(chain.Blocks[2].Latencies, "N3")
+	delete(chain.Blocks[3].Latencies, "N2")
+
+	//the latest block is the one of B, the block of C comes before it
+	B := &Node{ID: chain.Blocks[3].ID}
+	C := &Node{ID: chain.Blocks[2].ID}
+
+	estimation, isValid, err := chain.Blocks[0].ApproximateDistance(chain.Blocks[3], chain.Blocks[2], delta)
+	require.NoError(t, err)
+	require.True(t, isValid)
+
+	distance, err := chain.ApproximateOverChain(B, C)
+	require.NoError(t, err)
+	require.Equal(t, estimation, distance)
+
+	//a node missing from the chain is reported, even when the other one is found
+	unknown := &Node{ID: &NodeID{PublicKey: []byte("unknown")}}
+	_, err = chain.ApproximateOverChain(B, unknown)
+	require.Error(t, err)
+	_, err = chain.ApproximateOverChain(unknown, C)
+	require.Error(t, err)
+}
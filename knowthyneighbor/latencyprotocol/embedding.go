@@ -0,0 +1,353 @@
+/*
+embedding places the nodes of a chain in a low-dimensional space so that the distances between them match their
+latencies, and finds the liars among them from the residuals of the fit, the differences between the distances
+and the latencies
+
+Honest latencies are close to distances in a space of a few dimensions, while lies are not consistent with the
+other latencies and cannot all be fitted. The fit uses a Huber loss, whose scale is re-estimated at every iteration
+from the median of the residuals, so that the lies do not pull the honest nodes away from their positions. The lies
+then show as large residuals, on every latency of a liar but only on some of the latencies of its victims.
+Moderate lies, which do not break triangles, are found this way too
+
+Gradient descent can leave a node on the wrong side of its neighbours, so after each fit every node tries positions
+fitting its own latencies better, and the best of several fits is kept
+*/
+
+package latencyprotocol
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+//EmbeddingParams holds how the embedding is fitted and the liars detected
+type EmbeddingParams struct {
+	//Dimensions is the dimension of the space the nodes are placed in
+	Dimensions int
+	//Iterations is the number of gradient descent steps of the fit
+	Iterations int
+	//Restarts is the number of fits from different initial positions, the one fitting the latencies best being kept
+	Restarts int
+	//Cutoff is the number of robust standard deviations over which a residual is large
+	Cutoff float64
+	//MinFraction is the fraction of the latencies of a node which must have a large residual to blacklist it
+	MinFraction float64
+	//Seed is the seed of the initial positions, so that the fit is deterministic
+	Seed int64
+}
+
+//DefaultEmbeddingParams returns the parameters used by default
+func DefaultEmbeddingParams() EmbeddingParams {
+	return EmbeddingParams{
+		Dimensions:  2,
+		Iterations:  1000,
+		Restarts:    2,
+		Cutoff:      3,
+		MinFraction: 0.5,
+		Seed:        1,
+	}
+}
+
+//embeddingEdge is a latency given by the node From to the node To, as indices in the embedding
+type embeddingEdge struct {
+	From    int
+	To      int
+	Latency float64
+}
+
+//Embedding holds the positions fitted to the latest latencies of the active nodes of a chain
+type Embedding struct {
+	//Nodes are the keys of the nodes, sorted
+	Nodes []string
+	//Coordinates are the positions of the nodes, in nanoseconds
+	Coordinates [][]float64
+	edges       []embeddingEdge
+	index       map[string]int
+}
+
+//FitEmbedding places the active nodes of the chain in a space of the given dimension
+func FitEmbedding(chain *Chain, params EmbeddingParams) (*Embedding, error) {
+
+	if params.Dimensions <= 0 {
+		return nil, errors.New("Embedding needs at least one dimension")
+	}
+
+	latest := make(map[string]*Block)
+	for _, block := range chain.ActiveBlocks() {
+		latest[string(block.ID.PublicKey)] = block
+	}
+
+	embedding := &Embedding{Nodes: make([]string, 0, len(latest)), index: make(map[string]int)}
+	for key := range latest {
+		embedding.Nodes = append(embedding.Nodes, key)
+	}
+	sort.Strings(embedding.Nodes)
+	for i, key := range embedding.Nodes {
+		embedding.index[key] = i
+	}
+
+	total := 0.0
+	for i, key := range embedding.Nodes {
+		for other, latency := range latest[key].Latencies {
+			j, here := embedding.index[other]
+			if !here || i == j {
+				continue
+			}
+			embedding.edges = append(embedding.edges, embeddingEdge{i, j, float64(latency.Latency)})
+			total += float64(latency.Latency)
+		}
+	}
+
+	if len(embedding.edges) == 0 {
+		return nil, errors.New("No latencies to fit")
+	}
+	//map iteration is random, the edges are sorted so that the fit is deterministic
+	sort.Slice(embedding.edges, func(a, b int) bool {
+		if embedding.edges[a].From != embedding.edges[b].From {
+			return embedding.edges[a].From < embedding.edges[b].From
+		}
+		return embedding.edges[a].To < embedding.edges[b].To
+	})
+
+	meanLatency := total / float64(len(embedding.edges))
+	random := rand.New(rand.NewSource(params.Seed))
+
+	//the fit can end in a local minimum, such as a node placed on the wrong side of its neighbours,
+	//so the best of several fits is kept
+	var best [][]float64
+	bestLoss := math.Inf(1)
+	for restart := 0; restart < params.Restarts || best == nil; restart++ {
+		embedding.Coordinates = make([][]float64, len(embedding.Nodes))
+		for i := range embedding.Coordinates {
+			embedding.Coordinates[i] = make([]float64, params.Dimensions)
+			for d := range embedding.Coordinates[i] {
+				embedding.Coordinates[i][d] = random.Float64() * meanLatency
+			}
+		}
+
+		embedding.fit(params.Iterations, meanLatency)
+		if embedding.relocate(random, meanLatency) {
+			embedding.fit(params.Iterations/4, meanLatency)
+		}
+
+		loss := embedding.absoluteLoss()
+		if loss < bestLoss {
+			best = embedding.Coordinates
+			bestLoss = loss
+		}
+	}
+	embedding.Coordinates = best
+
+	return embedding, nil
+}
+
+//fit moves the nodes by gradient descent on the Huber loss of the residuals
+func (embedding *Embedding) fit(iterations int, meanLatency float64) {
+
+	degrees := make([]float64, len(embedding.Nodes))
+	for _, edge := range embedding.edges {
+		degrees[edge.From]++
+		degrees[edge.To]++
+	}
+
+	dimensions := len(embedding.Coordinates[0])
+	gradients := make([][]float64, len(embedding.Nodes))
+	for i := range gradients {
+		gradients[i] = make([]float64, dimensions)
+	}
+
+	for iteration := 0; iteration < iterations; iteration++ {
+
+		//the residuals under the scale are fitted as with least squares, the larger ones only count up to the scale
+		scale := math.Max(embedding.residualScale(), meanLatency*1e-3)
+		//large steps first to find the overall layout, smaller ones to settle
+		step := 0.5 * (1 - 0.9*float64(iteration)/float64(iterations))
+
+		for i := range gradients {
+			for d := range gradients[i] {
+				gradients[i][d] = 0
+			}
+		}
+
+		for _, edge := range embedding.edges {
+			from, to := embedding.Coordinates[edge.From], embedding.Coordinates[edge.To]
+			distance := euclidean(from, to)
+			residual := math.Max(-scale, math.Min(scale, distance-edge.Latency))
+			if distance < 1e-9 {
+				continue
+			}
+			for d := range from {
+				pull := residual * (from[d] - to[d]) / distance
+				gradients[edge.From][d] += pull
+				gradients[edge.To][d] -= pull
+			}
+		}
+
+		for i, coordinates := range embedding.Coordinates {
+			if degrees[i] == 0 {
+				continue
+			}
+			for d := range coordinates {
+				coordinates[d] -= step * gradients[i][d] / degrees[i]
+			}
+		}
+	}
+}
+
+//relocate tries to move each node alone to a position fitting its latencies better, out of the local minima in which
+//a node is held on the wrong side of its neighbours, and tells whether a node was moved
+func (embedding *Embedding) relocate(random *rand.Rand, meanLatency float64) bool {
+
+	edgesOf := make([][]embeddingEdge, len(embedding.Nodes))
+	for _, edge := range embedding.edges {
+		edgesOf[edge.From] = append(edgesOf[edge.From], edge)
+		edgesOf[edge.To] = append(edgesOf[edge.To], embeddingEdge{edge.To, edge.From, edge.Latency})
+	}
+
+	nodeLoss := func(edges []embeddingEdge, position []float64) float64 {
+		loss := 0.0
+		for _, edge := range edges {
+			loss += math.Abs(euclidean(position, embedding.Coordinates[edge.To]) - edge.Latency)
+		}
+		return loss
+	}
+
+	moved := false
+	for i, edges := range edgesOf {
+		if len(edges) == 0 {
+			continue
+		}
+		current := nodeLoss(edges, embedding.Coordinates[i])
+		for candidate := 0; candidate < relocationCandidates; candidate++ {
+			position := make([]float64, len(embedding.Coordinates[i]))
+			for d := range position {
+				position[d] = (2*random.Float64() - 0.5) * meanLatency
+			}
+			//gradient descent on the absolute residuals of the node only
+			for iteration := 0; iteration < 100; iteration++ {
+				step := meanLatency * 0.1 * (1 - float64(iteration)/100)
+				gradient := make([]float64, len(position))
+				for _, edge := range edges {
+					to := embedding.Coordinates[edge.To]
+					distance := euclidean(position, to)
+					if distance < 1e-9 {
+						continue
+					}
+					sign := 1.0
+					if distance < edge.Latency {
+						sign = -1
+					}
+					for d := range position {
+						gradient[d] += sign * (position[d] - to[d]) / distance
+					}
+				}
+				for d := range position {
+					position[d] -= step * gradient[d] / float64(len(edges))
+				}
+			}
+			loss := nodeLoss(edges, position)
+			if loss < current {
+				embedding.Coordinates[i] = position
+				current = loss
+				moved = true
+			}
+		}
+	}
+	return moved
+}
+
+//relocationCandidates is the number of random positions from which a node tries to find a better position
+const relocationCandidates = 10
+
+//absoluteLoss is the sum of the absolute residuals, which the lies do not dominate as they would the squared residuals
+func (embedding *Embedding) absoluteLoss() float64 {
+	loss := 0.0
+	for _, edge := range embedding.edges {
+		loss += math.Abs(embedding.residual(edge))
+	}
+	return loss
+}
+
+//residualScale is a robust estimate of the standard deviation of the residuals, from their median absolute value
+func (embedding *Embedding) residualScale() float64 {
+	residuals := make([]float64, len(embedding.edges))
+	for i, edge := range embedding.edges {
+		residuals[i] = math.Abs(embedding.residual(edge))
+	}
+	sort.Float64s(residuals)
+	return 1.4826 * residuals[len(residuals)/2]
+}
+
+func (embedding *Embedding) residual(edge embeddingEdge) float64 {
+	return euclidean(embedding.Coordinates[edge.From], embedding.Coordinates[edge.To]) - edge.Latency
+}
+
+func euclidean(A []float64, B []float64) float64 {
+	sum := 0.0
+	for d := range A {
+		sum += (A[d] - B[d]) * (A[d] - B[d])
+	}
+	return math.Sqrt(sum)
+}
+
+//Distance returns the distance between two nodes in the embedding
+func (embedding *Embedding) Distance(A string, B string) (time.Duration, bool) {
+	i, AHere := embedding.index[A]
+	j, BHere := embedding.index[B]
+	if !AHere || !BHere {
+		return 0, false
+	}
+	return time.Duration(euclidean(embedding.Coordinates[i], embedding.Coordinates[j])), true
+}
+
+//LargeResiduals returns, for each node, the number of latencies it takes part in and how many of them have a residual
+//over cutoff robust standard deviations
+func (embedding *Embedding) LargeResiduals(cutoff float64) (map[string]int, map[string]int) {
+
+	limit := cutoff * embedding.residualScale()
+
+	nbLatencies := make(map[string]int)
+	nbLarge := make(map[string]int)
+	for _, edge := range embedding.edges {
+		for _, node := range []int{edge.From, edge.To} {
+			key := embedding.Nodes[node]
+			nbLatencies[key]++
+			if math.Abs(embedding.residual(edge)) > limit {
+				nbLarge[key]++
+			}
+		}
+	}
+	return nbLatencies, nbLarge
+}
+
+//EmbeddingDetector detects liars from the residuals of an embedding of the chain
+type EmbeddingDetector struct {
+	Params EmbeddingParams
+}
+
+//NewEmbeddingDetector creates an embedding detector
+func NewEmbeddingDetector(params EmbeddingParams) *EmbeddingDetector {
+	return &EmbeddingDetector{params}
+}
+
+//Detect blacklists the nodes with a large residual on at least MinFraction of their latencies,
+//with as many strikes as they have large residuals
+func (detector *EmbeddingDetector) Detect(chain *Chain) (Blacklistset, error) {
+
+	embedding, err := FitEmbedding(chain, detector.Params)
+	if err != nil {
+		return Blacklistset{}, err
+	}
+
+	blacklist := NewBlacklistset()
+	nbLatencies, nbLarge := embedding.LargeResiduals(detector.Params.Cutoff)
+	for node, large := range nbLarge {
+		if float64(large) >= detector.Params.MinFraction*float64(nbLatencies[node]) {
+			blacklist.AddWithStrikesStringKey(node, large)
+		}
+	}
+	return blacklist, nil
+}
@@ -0,0 +1,273 @@
+/*
+encoding converts blocks to bytes to sign, send and store them
+
+protobuf cannot encode structs stored as map values, so the latencies of a block are encoded as a list sorted by public key,
+which also makes the encoding of a block deterministic. Blacklists are encoded the same way, to be sent over the network,
+as are reputations, to be stored
+*/
+
+package latencyprotocol
+
+import (
+	"encoding/hex"
+	"errors"
+	"sort"
+	"time"
+
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+//LatencyEntry is a latency of a block along with the public key of the node it was measured with
+type LatencyEntry struct {
+	PublicKey string
+	Latency   ConfirmedLatency
+}
+
+//encodedBlock is the form in which a block is encoded
+type encodedBlock struct {
+	ID              *NodeID
+	Latencies       []LatencyEntry
+	Index           int
+	PreviousHash    []byte
+	CreatedAt       time.Time
+	Signature       []byte
+	Kind            BlockKind
+	NewKey          []byte
+	NodeSignature   []byte
+	NewKeySignature []byte
+	Admission       []byte
+}
+
+//SortedLatencies returns the latencies of the block sorted by public key
+func (block *Block) SortedLatencies() []LatencyEntry {
+	entries := make([]LatencyEntry, 0, len(block.Latencies))
+	for key, latency := range block.Latencies {
+		entries = append(entries, LatencyEntry{key, latency})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].PublicKey < entries[j].PublicKey
+	})
+	return entries
+}
+
+//EncodeBlock encodes a block with protobuf
+func EncodeBlock(block *Block) ([]byte, error) {
+	if block == nil {
+		return nil, errors.New("Cannot encode an empty block")
+	}
+
+	return protobuf.Encode(&encodedBlock{
+		ID:              block.ID,
+		Latencies:       block.SortedLatencies(),
+		Index:           block.Index,
+		PreviousHash:    block.PreviousHash,
+		CreatedAt:       block.CreatedAt,
+		Signature:       block.Signature,
+		Kind:            block.Kind,
+		NewKey:          block.NewKey,
+		NodeSignature:   block.NodeSignature,
+		NewKeySignature: block.NewKeySignature,
+		Admission:       block.Admission,
+	})
+}
+
+//DecodeBlock decodes a block encoded with EncodeBlock, the suite is used to decode the public key of the server identity
+func DecodeBlock(buf []byte, suite network.Suite) (*Block, error) {
+	encoded := encodedBlock{}
+	err := protobuf.DecodeWithConstructors(buf, &encoded, network.DefaultConstructors(suite))
+	if err != nil {
+		return nil, err
+	}
+
+	latencies := make(map[string]ConfirmedLatency, len(encoded.Latencies))
+	for _, entry := range encoded.Latencies {
+		if _, exists := latencies[entry.PublicKey]; exists {
+			return nil, errors.New("Duplicate latency in block")
+		}
+		latencies[entry.PublicKey] = entry.Latency
+	}
+
+	return &Block{
+		ID:              encoded.ID,
+		Latencies:       latencies,
+		Index:           encoded.Index,
+		PreviousHash:    encoded.PreviousHash,
+		CreatedAt:       encoded.CreatedAt,
+		Signature:       encoded.Signature,
+		Kind:            encoded.Kind,
+		NewKey:          encoded.NewKey,
+		NodeSignature:   encoded.NodeSignature,
+		NewKeySignature: encoded.NewKeySignature,
+		Admission:       encoded.Admission,
+	}, nil
+}
+
+//encodedChain is the form in which a chain is exported. The blocks of a pruned chain are the blocks following its checkpoint
+type encodedChain struct {
+	Blocks     [][]byte
+	BucketName []byte
+	Checkpoint []byte
+}
+
+//EncodeChain encodes all the blocks of a chain with protobuf
+func EncodeChain(chain *Chain) ([]byte, error) {
+	encoded := &encodedChain{BucketName: chain.BucketName}
+
+	if chain.Checkpoint != nil {
+		checkpointBytes, err := EncodeSnapshot(chain.Checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		encoded.Checkpoint = checkpointBytes
+	}
+
+	for _, block := range chain.Blocks[chain.nbCheckpointBlocks():] {
+		blockBytes, err := EncodeBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		encoded.Blocks = append(encoded.Blocks, blockBytes)
+	}
+	return protobuf.Encode(encoded)
+}
+
+//DecodeChain decodes a chain encoded with EncodeChain. The blocks are not checked, see Validate
+func DecodeChain(buf []byte, suite network.Suite) (*Chain, error) {
+	encoded := encodedChain{}
+	err := protobuf.Decode(buf, &encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := &Chain{Blocks: make([]*Block, 0, len(encoded.Blocks)), BucketName: encoded.BucketName}
+	if len(encoded.Checkpoint) != 0 {
+		checkpoint, err := DecodeSnapshot(encoded.Checkpoint, suite)
+		if err != nil {
+			return nil, err
+		}
+		chain = ChainFromSnapshot(checkpoint, encoded.BucketName)
+	}
+
+	for _, blockBytes := range encoded.Blocks {
+		block, err := DecodeBlock(blockBytes, suite)
+		if err != nil {
+			return nil, err
+		}
+		chain.Blocks = append(chain.Blocks, block)
+	}
+	return chain, nil
+}
+
+//encodedStrikes is the number of strikes of a node, with its hex-encoded public key
+type encodedStrikes struct {
+	Key     string
+	Strikes int
+}
+
+//encodedBlacklistset is the form in which a blacklist is encoded, its nodes sorted by public key
+type encodedBlacklistset struct {
+	Strikes         []encodedStrikes
+	SuspectedSybils []string
+}
+
+//EncodeBlacklistset encodes the strikes and suspected sybils of a blacklist with protobuf, with hex-encoded public keys.
+//Nodes without strikes are left out, and the same blacklist always gives the same bytes
+func EncodeBlacklistset(set *Blacklistset) ([]byte, error) {
+	if set == nil {
+		return nil, errors.New("Cannot encode an empty blacklist")
+	}
+
+	encoded := &encodedBlacklistset{}
+	set.ForEach(func(key string, nbStrikes int) {
+		encoded.Strikes = append(encoded.Strikes, encodedStrikes{hex.EncodeToString([]byte(key)), nbStrikes})
+	})
+	for key := range set.SuspectedSybils {
+		encoded.SuspectedSybils = append(encoded.SuspectedSybils, hex.EncodeToString([]byte(key)))
+	}
+	sort.Strings(encoded.SuspectedSybils)
+
+	return protobuf.Encode(encoded)
+}
+
+//DecodeBlacklistset decodes a blacklist encoded with EncodeBlacklistset
+func DecodeBlacklistset(buf []byte) (Blacklistset, error) {
+	encoded := encodedBlacklistset{}
+	err := protobuf.Decode(buf, &encoded)
+	if err != nil {
+		return Blacklistset{}, err
+	}
+
+	set := NewBlacklistset()
+	for _, entry := range encoded.Strikes {
+		key, err := hex.DecodeString(entry.Key)
+		if err != nil {
+			return Blacklistset{}, err
+		}
+		if _, exists := set.Strikes[string(key)]; exists {
+			return Blacklistset{}, errors.New("Duplicate node in blacklist")
+		}
+		set.Strikes[string(key)] = entry.Strikes
+	}
+	for _, sybil := range encoded.SuspectedSybils {
+		key, err := hex.DecodeString(sybil)
+		if err != nil {
+			return Blacklistset{}, err
+		}
+		set.AddSuspectedSybil(string(key))
+	}
+	return set, nil
+}
+
+//encodedPenalty is the penalty of a node, with its hex-encoded public key
+type encodedPenalty struct {
+	Key     string
+	Penalty float64
+}
+
+//encodedReputation is the form in which a reputation is encoded, its nodes sorted by public key
+type encodedReputation struct {
+	Params    ReputationParams
+	Penalties []encodedPenalty
+	Updated   time.Time
+}
+
+//EncodeReputation encodes the parameters and penalties of a reputation with protobuf, with hex-encoded public keys
+func EncodeReputation(reputation *Reputation) ([]byte, error) {
+	if reputation == nil {
+		return nil, errors.New("Cannot encode an empty reputation")
+	}
+
+	encoded := &encodedReputation{Params: reputation.Params, Updated: reputation.Updated}
+	for key, penalty := range reputation.Penalties {
+		encoded.Penalties = append(encoded.Penalties, encodedPenalty{hex.EncodeToString([]byte(key)), penalty})
+	}
+	sort.Slice(encoded.Penalties, func(i, j int) bool {
+		return encoded.Penalties[i].Key < encoded.Penalties[j].Key
+	})
+
+	return protobuf.Encode(encoded)
+}
+
+//DecodeReputation decodes a reputation encoded with EncodeReputation
+func DecodeReputation(buf []byte) (*Reputation, error) {
+	encoded := encodedReputation{}
+	err := protobuf.Decode(buf, &encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	reputation := NewReputation(encoded.Params)
+	reputation.Updated = encoded.Updated
+	for _, entry := range encoded.Penalties {
+		key, err := hex.DecodeString(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := reputation.Penalties[string(key)]; exists {
+			return nil, errors.New("Duplicate node in reputation")
+		}
+		reputation.Penalties[string(key)] = entry.Penalty
+	}
+	return reputation, nil
+}
@@ -0,0 +1,93 @@
+package latencyprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeBlock(t *testing.T) {
+
+	chain := linkedChain(t, 4)
+	block := chain.Blocks[3]
+	block.Signature = []byte("signature")
+
+	buf, err := EncodeBlock(block)
+	require.NoError(t, err)
+
+	//the encoding does not depend on the order of the latencies
+	for i := 0; i < 10; i++ {
+		otherBuf, err := EncodeBlock(block)
+		require.NoError(t, err)
+		require.Equal(t, buf, otherBuf)
+	}
+
+	decoded, err := DecodeBlock(buf, tSuite)
+	require.NoError(t, err)
+
+	hash, err := block.Hash()
+	require.NoError(t, err)
+	decodedHash, err := decoded.Hash()
+	require.NoError(t, err)
+	require.Equal(t, hash, decodedHash)
+	require.Equal(t, block.Signature, decoded.Signature)
+	require.Equal(t, len(block.Latencies), len(decoded.Latencies))
+
+	_, err = DecodeBlock([]byte("not a block"), tSuite)
+	require.Error(t, err)
+
+	_, err = EncodeBlock(nil)
+	require.Error(t, err)
+}
+
+func TestEncodeBlacklistset(t *testing.T) {
+
+	blacklist := NewBlacklistset()
+	for i := 0; i < 5; i++ {
+		blacklist.AddWithStrikesStringKey(numbersToNodes(i), i)
+	}
+	blacklist.AddSuspectedSybil(numbersToNodes(7))
+
+	buf, err := EncodeBlacklistset(&blacklist)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		otherBuf, err := EncodeBlacklistset(&blacklist)
+		require.NoError(t, err)
+		require.Equal(t, buf, otherBuf)
+	}
+
+	decoded, err := DecodeBlacklistset(buf)
+	require.NoError(t, err)
+	require.True(t, blacklist.Equals(&decoded))
+	require.Equal(t, blacklist.Keys(), decoded.Keys())
+
+	_, err = EncodeBlacklistset(nil)
+	require.Error(t, err)
+}
+
+func TestEncodeReputation(t *testing.T) {
+
+	reputation := NewReputation(DefaultReputationParams())
+	reputation.Updated = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		reputation.Penalties[numbersToNodes(i)] = float64(i) / 3
+	}
+
+	buf, err := EncodeReputation(reputation)
+	require.NoError(t, err)
+
+	otherBuf, err := EncodeReputation(reputation)
+	require.NoError(t, err)
+	require.Equal(t, buf, otherBuf)
+
+	decoded, err := DecodeReputation(buf)
+	require.NoError(t, err)
+	require.Equal(t, reputation.Params, decoded.Params)
+	require.Equal(t, reputation.Penalties, decoded.Penalties)
+	require.True(t, reputation.Updated.Equal(decoded.Updated))
+
+	_, err = EncodeReputation(nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,219 @@
+/*
+evidence explains why nodes are blacklisted, so that anyone holding the chain can check the blacklist instead of trusting it
+
+A node blacklisted for its strikes comes with the triangles it takes part in which break the triangle inequality: the three
+nodes, the three latencies and by how much the longest side exceeds the sum of the two others. A suspect found to be a liar
+comes with the number of nodes accusing it. Both can be verified against the chain, as they only depend on its latencies
+*/
+
+package latencyprotocol
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//EvidenceTriangle is a triangle of latencies breaking the triangle inequality, found in the block of B
+type EvidenceTriangle struct {
+	B string
+	C string
+	D string
+	//BtoC and BtoD are given by B, CtoD by C or by D if C did not measure it
+	BtoC time.Duration
+	BtoD time.Duration
+	CtoD time.Duration
+	//Violation is by how much the longest side exceeds the sum of the two others
+	Violation time.Duration
+}
+
+func newEvidenceTriangle(B string, C string, D string, BtoC ConfirmedLatency, BtoD ConfirmedLatency, CtoD ConfirmedLatency) EvidenceTriangle {
+	return EvidenceTriangle{B, C, D, BtoC.Latency, BtoD.Latency, CtoD.Latency, triangleViolation(BtoC.Latency, BtoD.Latency, CtoD.Latency)}
+}
+
+//Involves tells whether a node is one of the three nodes of the triangle
+func (triangle *EvidenceTriangle) Involves(key string) bool {
+	return triangle.B == key || triangle.C == key || triangle.D == key
+}
+
+//SuspectTally is the count of the nodes accusing a suspect in the triangles of its block
+type SuspectTally struct {
+	Node string
+	//Accusers are the nodes giving more strikes to the triangles of the suspect than the accuser threshold
+	Accusers    int
+	NonAccusers int
+	//NonAccusersNeeded is the number of non-accusers under which the suspect is a liar
+	NonAccusersNeeded int
+}
+
+//IsLiar tells whether too few nodes do not accuse the suspect
+func (tally *SuspectTally) IsLiar() bool {
+	//if we cannot find enough nodes willing to not accuse the suspect, the suspect is a liar
+	return tally.NonAccusers < tally.NonAccusersNeeded
+}
+
+//NodeEvidence is why a node is in a blacklist
+type NodeEvidence struct {
+	//Triangles are the failing triangles the node takes part in, the largest violations first
+	Triangles []EvidenceTriangle
+	//Suspect is the tally of the accusers of the node if it was found to be a liar, nil otherwise
+	Suspect *SuspectTally
+}
+
+//addEvidence attaches to each node of the blacklist the failing triangles it takes part in and its tally as a suspect
+func (set *Blacklistset) addEvidence(violations []EvidenceTriangle, suspects []SuspectTally) {
+
+	sort.Slice(violations, func(i, j int) bool {
+		if violations[i].Violation != violations[j].Violation {
+			return violations[i].Violation > violations[j].Violation
+		}
+		if violations[i].B != violations[j].B {
+			return violations[i].B < violations[j].B
+		}
+		if violations[i].C != violations[j].C {
+			return violations[i].C < violations[j].C
+		}
+		return violations[i].D < violations[j].D
+	})
+
+	set.Evidence = make(map[string]*NodeEvidence)
+	for node, nbStrikes := range set.Strikes {
+		if nbStrikes > 0 {
+			set.Evidence[node] = &NodeEvidence{Triangles: make([]EvidenceTriangle, 0)}
+		}
+	}
+
+	for _, triangle := range violations {
+		for _, node := range []string{triangle.B, triangle.C, triangle.D} {
+			evidence, here := set.Evidence[node]
+			if here {
+				evidence.Triangles = append(evidence.Triangles, triangle)
+			}
+		}
+	}
+
+	for i := range suspects {
+		evidence, here := set.Evidence[suspects[i].Node]
+		if here {
+			evidence.Suspect = &suspects[i]
+		}
+	}
+}
+
+//VerifyEvidence checks that a triangle comes from the active blocks of the chain and breaks the triangle inequality
+//beyond the tolerance
+func (chain *Chain) VerifyEvidence(triangle EvidenceTriangle, tolerance TriangleTolerance) error {
+
+	latest := make(map[string]*Block)
+	BFound := false
+	var BtoC, BtoD ConfirmedLatency
+
+	for _, block := range chain.ActiveBlocks() {
+		key := string(block.ID.PublicKey)
+		latest[key] = block
+		if key != triangle.B {
+			continue
+		}
+		toC, CHere := block.Latencies[triangle.C]
+		toD, DHere := block.Latencies[triangle.D]
+		if CHere && DHere && toC.Latency == triangle.BtoC && toD.Latency == triangle.BtoD {
+			BFound = true
+			BtoC, BtoD = toC, toD
+		}
+	}
+
+	if !BFound {
+		return errors.New("No active block gives the latencies of the triangle")
+	}
+
+	CBlock, CHere := latest[triangle.C]
+	DBlock, DHere := latest[triangle.D]
+	if !CHere || !DHere {
+		return errors.New("Node of the triangle not in the chain")
+	}
+	CtoD, here := sideBetween(CBlock, DBlock)
+	if !here || CtoD.Latency != triangle.CtoD {
+		return errors.New("Latency between the second and third nodes does not match the chain")
+	}
+
+	if triangle.Violation != triangleViolation(triangle.BtoC, triangle.BtoD, triangle.CtoD) {
+		return errors.New("Wrong violation size")
+	}
+
+	if tolerance.Satisfied(BtoD, BtoC, CtoD) {
+		return errors.New("Triangle satisfies the triangle inequality")
+	}
+
+	return nil
+}
+
+//VerifyBlacklistEvidence checks the evidence of every node of a blacklist created by CreateExplainedBlacklist:
+//each node needs failing triangles it takes part in or a tally showing it is a liar, and both must match the chain
+func (chain *Chain) VerifyBlacklistEvidence(set *Blacklistset, tolerance TriangleTolerance) error {
+
+	active := chain.LatestChain()
+	N := len(active.Blocks)
+
+	for node, nbStrikes := range set.Strikes {
+		if nbStrikes <= 0 {
+			continue
+		}
+
+		evidence, here := set.Evidence[node]
+		if !here || (len(evidence.Triangles) == 0 && evidence.Suspect == nil) {
+			return errors.New("No evidence for a blacklisted node")
+		}
+
+		for i, triangle := range evidence.Triangles {
+			if !triangle.Involves(node) {
+				return errors.New("Triangle " + strconv.Itoa(i) + " does not involve the node")
+			}
+			err := chain.VerifyEvidence(triangle, tolerance)
+			if err != nil {
+				return errors.New("Triangle " + strconv.Itoa(i) + ": " + err.Error())
+			}
+		}
+
+		if evidence.Suspect != nil {
+			if !active.hasNode(node) {
+				return errors.New("Suspect not in the chain")
+			}
+			tally := TallyAccusers(active, node, N, BlacklistParams{Tolerance: tolerance, Suspects: ThirdsSuspectPolicy{}})
+			if tally != *evidence.Suspect {
+				return errors.New("Tally of the accusers does not match the chain")
+			}
+			if !tally.IsLiar() {
+				return errors.New("Suspect is not a liar")
+			}
+		}
+	}
+
+	return nil
+}
+
+//hasNode tells whether a node has a block in the chain
+func (chain *Chain) hasNode(key string) bool {
+	for _, block := range chain.Blocks {
+		if string(block.ID.PublicKey) == key {
+			return true
+		}
+	}
+	return false
+}
+
+//addNodeEvidence adds the evidence against a node to the one already in the blacklist
+func (set *Blacklistset) addNodeEvidence(key string, evidence *NodeEvidence) {
+	if set.Evidence == nil {
+		set.Evidence = make(map[string]*NodeEvidence)
+	}
+	current, here := set.Evidence[key]
+	if !here {
+		current = &NodeEvidence{Triangles: make([]EvidenceTriangle, 0)}
+		set.Evidence[key] = current
+	}
+	current.Triangles = append(current.Triangles, evidence.Triangles...)
+	if evidence.Suspect != nil {
+		current.Suspect = evidence.Suspect
+	}
+}
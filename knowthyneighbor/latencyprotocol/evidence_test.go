@@ -0,0 +1,89 @@
+package latencyprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainedBlacklist(t *testing.T) {
+	N := 7
+	tolerance := NewTriangleTolerance(0, 0)
+
+	chain, _ := chainWithAllLatenciesSame(N, 10)
+
+	setLiarAndVictim(chain, "N0", "N1", 70)
+	setLiarAndVictim(chain, "N0", "N2", 200)
+	setLiarAndVictim(chain, "N0", "N3", 2000)
+	setLiarAndVictim(chain, "N0", "N4", 20000)
+	setLiarAndVictim(chain, "N0", "N5", 200000)
+	setLiarAndVictim(chain, "N0", "N6", 2000000)
+
+	blacklist, err := CreateBlacklistWithTolerance(chain, tolerance, false, UpperThresholdPolicy{}, true)
+	require.NoError(t, err)
+
+	explained, err := CreateExplainedBlacklist(chain, tolerance, UpperThresholdPolicy{}, true)
+	require.NoError(t, err)
+	require.True(t, explained.Equals(&blacklist))
+
+	require.Equal(t, 1, len(explained.Evidence))
+	evidence := explained.Evidence["N0"]
+	require.NotNil(t, evidence)
+	require.NotEmpty(t, evidence.Triangles)
+
+	for i, triangle := range evidence.Triangles {
+		require.True(t, triangle.Involves("N0"))
+		require.True(t, triangle.Violation > 0)
+		if i > 0 {
+			require.True(t, triangle.Violation <= evidence.Triangles[i-1].Violation)
+		}
+	}
+	require.NoError(t, chain.VerifyBlacklistEvidence(&explained, tolerance))
+
+	//the evidence survives the export
+	data, err := explained.EvidenceToJSON()
+	require.NoError(t, err)
+	imported := blacklist.GetBlacklistWithThreshold(0)
+	require.NoError(t, imported.EvidenceFromJSON(data))
+	require.Equal(t, explained.Evidence, imported.Evidence)
+	require.NoError(t, chain.VerifyBlacklistEvidence(&imported, tolerance))
+
+	//a triangle not matching the chain is refused
+	forged := evidence.Triangles[0]
+	forged.BtoC++
+	require.Error(t, chain.VerifyEvidence(forged, tolerance))
+
+	//as is a triangle within the tolerance
+	require.Error(t, chain.VerifyEvidence(evidence.Triangles[0], NewTriangleTolerance(10*time.Second, 0)))
+
+	//and a blacklisted node without evidence
+	imported.Evidence = make(map[string]*NodeEvidence)
+	require.Error(t, chain.VerifyBlacklistEvidence(&imported, tolerance))
+}
+
+func TestExplainedSuspect(t *testing.T) {
+	N := 7
+	tolerance := NewTriangleTolerance(0, 0)
+
+	chain, _ := chainWithAllLatenciesSame(N, 10)
+
+	//too few lies for the liar to reach the threshold, it is only found by the strike patterns
+	setLiarAndVictim(chain, "N0", "N1", 100)
+	setLiarAndVictim(chain, "N0", "N2", 100)
+	setLiarAndVictim(chain, "N0", "N3", 100)
+
+	suspects := BlacklistEnhancement(chain, N, BlacklistParams{Tolerance: tolerance})
+	require.Equal(t, []SuspectTally{{"N0", 6, 1, 4}}, suspects)
+	require.True(t, SuspectIsLiar(chain, "N0", N, BlacklistParams{Tolerance: tolerance}))
+
+	explained, err := CreateExplainedBlacklist(chain, tolerance, UpperThresholdPolicy{}, true)
+	require.NoError(t, err)
+	require.True(t, explained.ContainsAsString("N0"))
+	require.Equal(t, suspects[0], *explained.Evidence["N0"].Suspect)
+	require.NoError(t, chain.VerifyBlacklistEvidence(&explained, tolerance))
+
+	//a tally which does not match the chain is refused
+	explained.Evidence["N0"].Suspect = &SuspectTally{"N0", 7, 0, 4}
+	require.Error(t, chain.VerifyBlacklistEvidence(&explained, tolerance))
+}
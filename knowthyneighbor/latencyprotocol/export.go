@@ -0,0 +1,592 @@
+/*
+export writes chains and blacklists in formats which can be analysed and visualised outside of the protocol:
+
+	JSON round-trips a chain or a blacklist fully, public keys and signatures being hex-encoded
+	JSON of a blacklist is deterministic, so that blacklists of different runs can be stored and diffed
+	JSON also carries the evidence of an explained blacklist, to be verified against the chain
+	CSV lists the latencies of a chain as edges src,dst,latency,timestamp
+	DOT draws the latencies of a chain with Graphviz, blacklisted nodes being coloured
+*/
+
+package latencyprotocol
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.dedis.ch/onet/v3/network"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+type jsonServerIdentity struct {
+	Public      string
+	ID          string
+	Address     network.Address
+	Description string
+	URL         string
+}
+
+type jsonLatency struct {
+	PublicKey          string
+	Latency            time.Duration
+	SignedLatency      string
+	Timestamp          time.Time
+	SignedConfirmation string
+	Spread             time.Duration `json:",omitempty"`
+}
+
+type jsonBlock struct {
+	PublicKey    string
+	ServerID     *jsonServerIdentity `json:",omitempty"`
+	Index        int
+	PreviousHash string
+	CreatedAt    time.Time
+	Signature    string
+	Latencies    []jsonLatency
+	//the membership fields are only set for membership blocks
+	Kind            BlockKind `json:",omitempty"`
+	NewKey          string    `json:",omitempty"`
+	NodeSignature   string    `json:",omitempty"`
+	NewKeySignature string    `json:",omitempty"`
+	Admission       string    `json:",omitempty"`
+}
+
+type jsonChain struct {
+	BucketName string
+	Blocks     []jsonBlock
+}
+
+//ToJSON exports the chain in JSON
+func (chain *Chain) ToJSON() ([]byte, error) {
+
+	exported := jsonChain{
+		BucketName: string(chain.BucketName),
+		Blocks:     make([]jsonBlock, len(chain.Blocks)),
+	}
+
+	for i, block := range chain.Blocks {
+		if block == nil || block.ID == nil {
+			return nil, errors.New("Cannot export a block without identity")
+		}
+
+		serverID, err := exportServerIdentity(block.ID.ServerID)
+		if err != nil {
+			return nil, err
+		}
+
+		latencies := make([]jsonLatency, 0, len(block.Latencies))
+		for _, entry := range block.SortedLatencies() {
+			latencies = append(latencies, jsonLatency{
+				PublicKey:          hex.EncodeToString([]byte(entry.PublicKey)),
+				Latency:            entry.Latency.Latency,
+				SignedLatency:      hex.EncodeToString(entry.Latency.SignedLatency),
+				Timestamp:          entry.Latency.Timestamp,
+				SignedConfirmation: hex.EncodeToString(entry.Latency.SignedConfirmation),
+				Spread:             entry.Latency.Spread,
+			})
+		}
+
+		exported.Blocks[i] = jsonBlock{
+			PublicKey:       hex.EncodeToString(block.ID.PublicKey),
+			ServerID:        serverID,
+			Index:           block.Index,
+			PreviousHash:    hex.EncodeToString(block.PreviousHash),
+			CreatedAt:       block.CreatedAt,
+			Signature:       hex.EncodeToString(block.Signature),
+			Latencies:       latencies,
+			Kind:            block.Kind,
+			NewKey:          hex.EncodeToString(block.NewKey),
+			NodeSignature:   hex.EncodeToString(block.NodeSignature),
+			NewKeySignature: hex.EncodeToString(block.NewKeySignature),
+			Admission:       hex.EncodeToString(block.Admission),
+		}
+	}
+
+	return json.MarshalIndent(exported, "", "  ")
+}
+
+//ChainFromJSON imports a chain exported with ToJSON, the suite is used to read the public keys of the server identities
+func ChainFromJSON(data []byte, suite network.Suite) (*Chain, error) {
+
+	imported := jsonChain{}
+	err := json.Unmarshal(data, &imported)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := &Chain{Blocks: make([]*Block, len(imported.Blocks)), BucketName: []byte(imported.BucketName)}
+
+	for i, exported := range imported.Blocks {
+		block := &Block{
+			ID:        &NodeID{},
+			Latencies: make(map[string]ConfirmedLatency, len(exported.Latencies)),
+			Index:     exported.Index,
+			CreatedAt: exported.CreatedAt,
+			Kind:      exported.Kind,
+		}
+
+		block.ID.PublicKey, err = decodeHex(exported.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		block.ID.ServerID, err = importServerIdentity(exported.ServerID, suite)
+		if err != nil {
+			return nil, err
+		}
+		block.PreviousHash, err = decodeHex(exported.PreviousHash)
+		if err != nil {
+			return nil, err
+		}
+		block.Signature, err = decodeHex(exported.Signature)
+		if err != nil {
+			return nil, err
+		}
+		block.NewKey, err = decodeHex(exported.NewKey)
+		if err != nil {
+			return nil, err
+		}
+		block.NodeSignature, err = decodeHex(exported.NodeSignature)
+		if err != nil {
+			return nil, err
+		}
+		block.NewKeySignature, err = decodeHex(exported.NewKeySignature)
+		if err != nil {
+			return nil, err
+		}
+		block.Admission, err = decodeHex(exported.Admission)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, latency := range exported.Latencies {
+			key, err := decodeHex(latency.PublicKey)
+			if err != nil {
+				return nil, err
+			}
+			signedLatency, err := decodeHex(latency.SignedLatency)
+			if err != nil {
+				return nil, err
+			}
+			signedConfirmation, err := decodeHex(latency.SignedConfirmation)
+			if err != nil {
+				return nil, err
+			}
+			block.Latencies[string(key)] = ConfirmedLatency{latency.Latency, signedLatency, latency.Timestamp, signedConfirmation, latency.Spread}
+		}
+
+		chain.Blocks[i] = block
+	}
+
+	return chain, nil
+}
+
+//decodeHex decodes a hex string, an empty string giving a nil slice
+func decodeHex(str string) ([]byte, error) {
+	if str == "" {
+		return nil, nil
+	}
+	return hex.DecodeString(str)
+}
+
+func exportServerIdentity(si *network.ServerIdentity) (*jsonServerIdentity, error) {
+	if si == nil {
+		return nil, nil
+	}
+
+	public := ""
+	if si.Public != nil {
+		publicBytes, err := si.Public.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		public = hex.EncodeToString(publicBytes)
+	}
+
+	id := si.ID
+	return &jsonServerIdentity{public, hex.EncodeToString(id[:]), si.Address, si.Description, si.URL}, nil
+}
+
+func importServerIdentity(exported *jsonServerIdentity, suite network.Suite) (*network.ServerIdentity, error) {
+	if exported == nil {
+		return nil, nil
+	}
+
+	si := &network.ServerIdentity{Address: exported.Address, Description: exported.Description, URL: exported.URL}
+
+	if exported.Public != "" {
+		publicBytes, err := hex.DecodeString(exported.Public)
+		if err != nil {
+			return nil, err
+		}
+		si.Public = suite.Point()
+		err = si.Public.UnmarshalBinary(publicBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := hex.DecodeString(exported.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(id) != len(si.ID) {
+		return nil, errors.New("Invalid server identity ID")
+	}
+	copy(si.ID[:], id)
+
+	return si, nil
+}
+
+type jsonBlacklistset struct {
+	Strikes         map[string]int
+	SuspectedSybils []string `json:",omitempty"`
+}
+
+//MarshalJSON encodes the blacklist in JSON with hex-encoded public keys, the nodes without strikes being left out.
+//The keys are sorted so that the same blacklist always gives the same JSON. The evidence is exported by EvidenceToJSON
+func (set Blacklistset) MarshalJSON() ([]byte, error) {
+	exported := jsonBlacklistset{Strikes: make(map[string]int, len(set.Strikes))}
+	set.ForEach(func(key string, nbStrikes int) {
+		exported.Strikes[hex.EncodeToString([]byte(key))] = nbStrikes
+	})
+	for key := range set.SuspectedSybils {
+		exported.SuspectedSybils = append(exported.SuspectedSybils, hex.EncodeToString([]byte(key)))
+	}
+	sort.Strings(exported.SuspectedSybils)
+	return json.Marshal(exported)
+}
+
+//UnmarshalJSON decodes a blacklist encoded with MarshalJSON
+func (set *Blacklistset) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	imported := jsonBlacklistset{}
+	err := decoder.Decode(&imported)
+	if err != nil {
+		return err
+	}
+
+	*set = NewBlacklistset()
+	for key, nbStrikes := range imported.Strikes {
+		decoded, err := hex.DecodeString(key)
+		if err != nil {
+			return err
+		}
+		set.AddWithStrikesStringKey(string(decoded), nbStrikes)
+	}
+	for _, key := range imported.SuspectedSybils {
+		decoded, err := hex.DecodeString(key)
+		if err != nil {
+			return err
+		}
+		set.AddSuspectedSybil(string(decoded))
+	}
+	return nil
+}
+
+//ToJSON exports the blacklist in JSON, see MarshalJSON
+func (set *Blacklistset) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(set, "", "  ")
+}
+
+//BlacklistsetFromJSON imports a blacklist exported with ToJSON
+func BlacklistsetFromJSON(data []byte) (Blacklistset, error) {
+	set := NewBlacklistset()
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return Blacklistset{}, err
+	}
+	return set, nil
+}
+
+type jsonEvidenceTriangle struct {
+	B         string
+	C         string
+	D         string
+	BtoC      time.Duration
+	BtoD      time.Duration
+	CtoD      time.Duration
+	Violation time.Duration
+}
+
+type jsonNodeEvidence struct {
+	Node      string
+	Triangles []jsonEvidenceTriangle
+	Suspect   *SuspectTally `json:",omitempty"`
+}
+
+//EvidenceToJSON exports the evidence of the blacklist in JSON, ordered by node, with hex-encoded public keys
+func (set *Blacklistset) EvidenceToJSON() ([]byte, error) {
+	keys := make([]string, 0, len(set.Evidence))
+	for key := range set.Evidence {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	exported := make([]jsonNodeEvidence, 0, len(keys))
+	for _, key := range keys {
+		evidence := set.Evidence[key]
+		triangles := make([]jsonEvidenceTriangle, len(evidence.Triangles))
+		for i, triangle := range evidence.Triangles {
+			triangles[i] = jsonEvidenceTriangle{
+				B:         hex.EncodeToString([]byte(triangle.B)),
+				C:         hex.EncodeToString([]byte(triangle.C)),
+				D:         hex.EncodeToString([]byte(triangle.D)),
+				BtoC:      triangle.BtoC,
+				BtoD:      triangle.BtoD,
+				CtoD:      triangle.CtoD,
+				Violation: triangle.Violation,
+			}
+		}
+
+		var suspect *SuspectTally
+		if evidence.Suspect != nil {
+			tally := *evidence.Suspect
+			tally.Node = hex.EncodeToString([]byte(tally.Node))
+			suspect = &tally
+		}
+
+		exported = append(exported, jsonNodeEvidence{hex.EncodeToString([]byte(key)), triangles, suspect})
+	}
+
+	return json.MarshalIndent(exported, "", "  ")
+}
+
+//EvidenceFromJSON imports evidence exported with EvidenceToJSON into the blacklist
+func (set *Blacklistset) EvidenceFromJSON(data []byte) error {
+	imported := make([]jsonNodeEvidence, 0)
+	err := json.Unmarshal(data, &imported)
+	if err != nil {
+		return err
+	}
+
+	evidence := make(map[string]*NodeEvidence, len(imported))
+	for _, exported := range imported {
+		key, err := hex.DecodeString(exported.Node)
+		if err != nil {
+			return err
+		}
+
+		nodeEvidence := &NodeEvidence{Triangles: make([]EvidenceTriangle, len(exported.Triangles))}
+		for i, triangle := range exported.Triangles {
+			nodes := make([][]byte, 3)
+			for j, node := range []string{triangle.B, triangle.C, triangle.D} {
+				nodes[j], err = hex.DecodeString(node)
+				if err != nil {
+					return err
+				}
+			}
+			nodeEvidence.Triangles[i] = EvidenceTriangle{string(nodes[0]), string(nodes[1]), string(nodes[2]),
+				triangle.BtoC, triangle.BtoD, triangle.CtoD, triangle.Violation}
+		}
+
+		if exported.Suspect != nil {
+			suspect, err := hex.DecodeString(exported.Suspect.Node)
+			if err != nil {
+				return err
+			}
+			tally := *exported.Suspect
+			tally.Node = string(suspect)
+			nodeEvidence.Suspect = &tally
+		}
+
+		evidence[string(key)] = nodeEvidence
+	}
+
+	set.Evidence = evidence
+	return nil
+}
+
+var csvHeader = []string{"src", "dst", "latency", "timestamp"}
+
+//WriteCSV writes the latencies of the chain as an edge list src,dst,latency,timestamp, with hex-encoded public keys,
+//latencies in nanoseconds and RFC 3339 timestamps
+func (chain *Chain) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+
+	err := writer.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+
+	for _, block := range chain.Blocks {
+		src := hex.EncodeToString(block.ID.PublicKey)
+		for _, entry := range block.SortedLatencies() {
+			err = writer.Write([]string{
+				src,
+				hex.EncodeToString([]byte(entry.PublicKey)),
+				strconv.FormatInt(int64(entry.Latency.Latency), 10),
+				entry.Latency.Timestamp.Format(time.RFC3339Nano),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+//ChainFromCSV builds a chain from an edge list written by WriteCSV, with one block per source node.
+//Signatures are not part of the edge list, so the chain can be analysed but not validated
+func ChainFromCSV(r io.Reader) (*Chain, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(csvHeader)
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("Missing CSV header")
+	}
+
+	chain := &Chain{Blocks: make([]*Block, 0), BucketName: []byte{}}
+	blocks := make(map[string]*Block)
+
+	for line, record := range records[1:] {
+		src, err := hex.DecodeString(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", line+2, err)
+		}
+		dst, err := hex.DecodeString(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", line+2, err)
+		}
+		latency, err := strconv.ParseInt(record[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", line+2, err)
+		}
+		timestamp, err := time.Parse(time.RFC3339Nano, record[3])
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", line+2, err)
+		}
+
+		block, exists := blocks[string(src)]
+		if !exists {
+			block = &Block{
+				ID:        &NodeID{PublicKey: sigAlg.PublicKey(src)},
+				Latencies: make(map[string]ConfirmedLatency),
+				Index:     len(chain.Blocks),
+			}
+			blocks[string(src)] = block
+			chain.Blocks = append(chain.Blocks, block)
+		}
+		block.Latencies[string(dst)] = ConfirmedLatency{Latency: time.Duration(latency), Timestamp: timestamp}
+	}
+
+	return chain, nil
+}
+
+//WriteCSV writes the blacklist as a list key,strikes with hex-encoded public keys
+func (set *Blacklistset) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+
+	err := writer.Write([]string{"key", "strikes"})
+	if err != nil {
+		return err
+	}
+
+	set.ForEach(func(key string, nbStrikes int) {
+		if err == nil {
+			err = writer.Write([]string{hex.EncodeToString([]byte(key)), strconv.Itoa(nbStrikes)})
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+//BlacklistsetFromCSV reads a blacklist written by WriteCSV
+func BlacklistsetFromCSV(r io.Reader) (Blacklistset, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return Blacklistset{}, err
+	}
+	if len(records) == 0 {
+		return Blacklistset{}, errors.New("Missing CSV header")
+	}
+
+	set := NewBlacklistset()
+	for line, record := range records[1:] {
+		key, err := hex.DecodeString(record[0])
+		if err != nil {
+			return Blacklistset{}, fmt.Errorf("Line %d: %s", line+2, err)
+		}
+		strikes, err := strconv.Atoi(record[1])
+		if err != nil {
+			return Blacklistset{}, fmt.Errorf("Line %d: %s", line+2, err)
+		}
+		set.AddWithStrikesStringKey(string(key), strikes)
+	}
+	return set, nil
+}
+
+//WriteDOT draws the latencies of the chain as a Graphviz graph, with an edge from each node to the nodes it measured
+//a latency with. Nodes are identified by their whole hex-encoded public key and labelled with a prefix of it.
+//Nodes with strikes in the blacklist, which can be nil, are coloured in red and suspected sybils in orange
+func (chain *Chain) WriteDOT(w io.Writer, blacklist *Blacklistset) error {
+
+	_, err := fmt.Fprintln(w, "digraph G {")
+	if err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+	writeNode := func(key string) error {
+		if written[key] {
+			return nil
+		}
+		written[key] = true
+		attributes := fmt.Sprintf("label=\"%s\"", shortKey(key))
+		if blacklist != nil && blacklist.ContainsAsString(key) {
+			attributes += fmt.Sprintf(", style=filled, fillcolor=red, xlabel=\"%d strikes\"", blacklist.NbStrikesOf(key))
+		} else if blacklist != nil && blacklist.IsSuspectedSybil(key) {
+			attributes += ", style=filled, fillcolor=orange, xlabel=\"suspected sybil\""
+		}
+		_, err := fmt.Fprintf(w, "    \"%s\" [%s];\n", hex.EncodeToString([]byte(key)), attributes)
+		return err
+	}
+
+	for _, block := range chain.Blocks {
+		err = writeNode(string(block.ID.PublicKey))
+		if err != nil {
+			return err
+		}
+		for _, entry := range block.SortedLatencies() {
+			err = writeNode(entry.PublicKey)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, block := range chain.Blocks {
+		src := hex.EncodeToString(block.ID.PublicKey)
+		for _, entry := range block.SortedLatencies() {
+			_, err = fmt.Fprintf(w, "    \"%s\" -> \"%s\" [label=\"%s\"];\n", src, hex.EncodeToString([]byte(entry.PublicKey)), entry.Latency.Latency)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = fmt.Fprintln(w, "}")
+	return err
+}
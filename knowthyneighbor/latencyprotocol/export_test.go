@@ -0,0 +1,147 @@
+package latencyprotocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainJSON(t *testing.T) {
+
+	chain := handshakeChain(t)
+	chain.Blocks[1].Signature = []byte("signature")
+
+	data, err := chain.ToJSON()
+	require.NoError(t, err)
+
+	imported, err := ChainFromJSON(data, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, chain.Height(), imported.Height())
+	require.Equal(t, chain.BucketName, imported.BucketName)
+
+	for i, block := range chain.Blocks {
+		hash, err := block.Hash()
+		require.NoError(t, err)
+		importedHash, err := imported.Blocks[i].Hash()
+		require.NoError(t, err)
+		require.Equal(t, hash, importedHash)
+		require.Equal(t, block.Signature, imported.Blocks[i].Signature)
+		require.Equal(t, block.ID.ServerID.Address, imported.Blocks[i].ID.ServerID.Address)
+		require.Equal(t, block.ID.ServerID.ID, imported.Blocks[i].ID.ServerID.ID)
+	}
+
+	//the signatures of the latencies survive the round trip
+	params := DefaultChainValidationParams()
+	params.Tolerance = params.MaxLatency
+	report := imported.Validate(nil, params)
+	require.True(t, report.Valid(), report.String())
+
+	_, err = ChainFromJSON([]byte("{\"Blocks\": [{\"PublicKey\": \"not hex\"}]}"), tSuite)
+	require.Error(t, err)
+}
+
+func TestChainCSV(t *testing.T) {
+
+	chain, _ := chainWithAllLatenciesSame(4, 0)
+
+	var buf bytes.Buffer
+	require.NoError(t, chain.WriteCSV(&buf))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Equal(t, "src,dst,latency,timestamp", lines[0])
+	require.Equal(t, 1+4*3, len(lines))
+
+	imported, err := ChainFromCSV(&buf)
+	require.NoError(t, err)
+	require.Equal(t, chain.Height(), imported.Height())
+	for i, block := range chain.Blocks {
+		require.Equal(t, block.ID.PublicKey, imported.Blocks[i].ID.PublicKey)
+		require.Equal(t, len(block.Latencies), len(imported.Blocks[i].Latencies))
+		for key, latency := range block.Latencies {
+			require.Equal(t, latency.Latency, imported.Blocks[i].Latencies[key].Latency)
+			require.True(t, latency.Timestamp.Equal(imported.Blocks[i].Latencies[key].Timestamp))
+		}
+	}
+
+	_, err = ChainFromCSV(strings.NewReader("src,dst,latency,timestamp\n4e30,4e31,notanumber,2019-01-01T00:00:00Z\n"))
+	require.Error(t, err)
+}
+
+func TestBlacklistExport(t *testing.T) {
+
+	blacklist := NewBlacklistset()
+	blacklist.AddWithStrikesStringKey(numbersToNodes(1), 3)
+	blacklist.AddWithStrikesStringKey(numbersToNodes(2), 1)
+	blacklist.AddSuspectedSybil(numbersToNodes(4))
+
+	data, err := blacklist.ToJSON()
+	require.NoError(t, err)
+	imported, err := BlacklistsetFromJSON(data)
+	require.NoError(t, err)
+	require.True(t, blacklist.Equals(&imported))
+	require.Contains(t, string(data), `"4e31": 3`)
+
+	//the same blacklist always gives the same JSON
+	for i := 0; i < 10; i++ {
+		otherData, err := imported.ToJSON()
+		require.NoError(t, err)
+		require.Equal(t, data, otherData)
+	}
+
+	_, err = BlacklistsetFromJSON([]byte(`{"4e31": 3}`))
+	require.Error(t, err)
+	_, err = BlacklistsetFromJSON([]byte(`{"Strikes": {"not hex": 3}}`))
+	require.Error(t, err)
+	_, err = BlacklistsetFromJSON([]byte(`{"Strikes": {"4e31": 3}, "Unknown": 1}`))
+	require.Error(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, blacklist.WriteCSV(&buf))
+	imported, err = BlacklistsetFromCSV(&buf)
+	require.NoError(t, err)
+	//the CSV only lists the strikes
+	require.Equal(t, blacklist.Strikes, imported.Strikes)
+}
+
+func TestChainDOT(t *testing.T) {
+
+	chain, _ := chainWithAllLatenciesSame(3, 0)
+	blacklist := NewBlacklistset()
+	blacklist.AddWithStrikesStringKey(numbersToNodes(1), 2)
+
+	var buf bytes.Buffer
+	require.NoError(t, chain.WriteDOT(&buf, &blacklist))
+	dot := buf.String()
+
+	require.True(t, strings.HasPrefix(dot, "digraph G {"))
+	require.True(t, strings.HasSuffix(strings.TrimSpace(dot), "}"))
+	require.Equal(t, 6, strings.Count(dot, "->"))
+	require.Equal(t, 1, strings.Count(dot, "fillcolor=red"))
+	require.Contains(t, dot, "\""+hex.EncodeToString([]byte(numbersToNodes(1)))+"\" [label=\""+shortKey(numbersToNodes(1))+"\", style=filled")
+
+	buf.Reset()
+	require.NoError(t, chain.WriteDOT(&buf, nil))
+	require.NotContains(t, buf.String(), "fillcolor")
+
+	//keys sharing the prefix shown in the labels are still different nodes
+	prefix := strings.Repeat("k", 8)
+	colliding := &Chain{Blocks: []*Block{}, BucketName: []byte("testBucket")}
+	for _, suffix := range []string{"A", "B"} {
+		block := &Block{ID: &NodeID{PublicKey: []byte(prefix + suffix)}, Latencies: map[string]ConfirmedLatency{
+			prefix + "C": {Latency: time.Millisecond},
+		}}
+		colliding.Blocks = append(colliding.Blocks, block)
+	}
+	require.Equal(t, shortKey(prefix+"A"), shortKey(prefix+"B"))
+
+	buf.Reset()
+	require.NoError(t, colliding.WriteDOT(&buf, nil))
+	require.Equal(t, 3, strings.Count(buf.String(), "[label=\""+shortKey(prefix)))
+	require.Equal(t, 2, strings.Count(buf.String(), "->"))
+	require.Contains(t, buf.String(), "\""+hex.EncodeToString([]byte(prefix+"A"))+"\" -> ")
+	require.Contains(t, buf.String(), "\""+hex.EncodeToString([]byte(prefix+"B"))+"\" -> ")
+}
@@ -76,7 +76,7 @@ func TestMultiliarClusterInfiltrationGraphCreation(t *testing.T) {
 				inconsistentChain := setMultipleLiesToClusters(nbLiars, consistentChain)
 
 				thresh := UpperThreshold(N)
-				blacklist, err := CreateBlacklist(inconsistentChain, 0, false, true, thresh, withSuspects)
+				blacklist, err := createBlacklistOnAllCores(inconsistentChain, TriangleTolerance{}, false, FixedThreshold(thresh), withSuspects)
 				if err != nil {
 					log.Print(err)
 				}
@@ -81,7 +81,7 @@ func TestSoloClusterInfiltrationGraphCreation(t *testing.T) {
 				inconsistentChain := setLiesToClusters(0, consistentChain)
 
 				thresh := UpperThreshold(N)
-				blacklist, err := CreateBlacklist(inconsistentChain, 0, false, true, thresh, withSuspects)
+				blacklist, err := createBlacklistOnAllCores(inconsistentChain, TriangleTolerance{}, false, FixedThreshold(thresh), withSuspects)
 				if err != nil {
 					log.Print(err)
 				}
@@ -135,7 +135,7 @@ func BinarySearch(initDistance int, N int, clusterSizes []int, withSuspects bool
 
 		thresh := UpperThreshold(N)
 		//threshold := strconv.Itoa(thresh)
-		blacklist, err := CreateBlacklist(inconsistentChain, 0, false, true, thresh, withSuspects)
+		blacklist, err := createBlacklistOnAllCores(inconsistentChain, TriangleTolerance{}, false, FixedThreshold(thresh), withSuspects)
 		if err != nil {
 			log.Print(err)
 		}
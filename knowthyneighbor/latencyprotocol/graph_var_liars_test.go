@@ -231,7 +231,7 @@ func createLyingNetworkWithMapping(
 
 	log.Print("Lies set")
 
-	blacklist, _ := CreateBlacklist(inconsistentChain, 0, false, false, 0, withSuspects)
+	blacklist, _ := createBlacklistOnAllCores(inconsistentChain, TriangleTolerance{}, false, UpperThresholdPolicy{}, withSuspects)
 
 	log.Print("Create blacklist")
 
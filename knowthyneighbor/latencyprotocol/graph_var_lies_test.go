@@ -185,7 +185,7 @@ func CreateFixedLiarHonestAndLyingNetworks(N int, nbLiars int, withSuspects bool
 	//1) Create chain with No TIVs or liars
 	consistentChain, _ := chainWithOnlyConsistentLatencies(N, 0)
 
-	testBlacklist, _ := CreateBlacklist(consistentChain, 0, false, true, 0, withSuspects)
+	testBlacklist, _ := createBlacklistOnAllCores(consistentChain, TriangleTolerance{}, false, FixedThreshold(0), withSuspects)
 
 	if !testBlacklist.IsEmpty() {
 		log.Print(testBlacklist.ToString())
@@ -252,7 +252,7 @@ func CreateFixedLiarHonestAndLyingNetworks(N int, nbLiars int, withSuspects bool
 		}
 	}
 
-	blacklist, _ := CreateBlacklist(inconsistentChain, 0, verbose, false, 0, withSuspects)
+	blacklist, _ := createBlacklistOnAllCores(inconsistentChain, TriangleTolerance{}, verbose, UpperThresholdPolicy{}, withSuspects)
 
 	return consistentChain, inconsistentChain, &blacklist, nil
 
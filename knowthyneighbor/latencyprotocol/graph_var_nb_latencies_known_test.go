@@ -24,7 +24,7 @@ func TestBlacklistOneLiarNotAllLatencies(t *testing.T) {
 
 	for index := range nodeIDs {
 
-		blacklist, err := CreateBlacklist(chain, d, false, false, -1, withSuspects)
+		blacklist, err := CreateBlacklist(chain, d, false, UpperThresholdPolicy{}, withSuspects)
 
 		blacklists[index] = blacklist
 
@@ -88,7 +88,7 @@ func TestBlacklistTwoLiarsNotAllLatencies(t *testing.T) {
 
 	for index := range nodeIDs {
 
-		blacklist, err := CreateBlacklist(chain, d, false, false, -1, withSuspects)
+		blacklist, err := CreateBlacklist(chain, d, false, UpperThresholdPolicy{}, withSuspects)
 
 		blacklists[index] = blacklist
 
@@ -0,0 +1,117 @@
+/*
+ This file allows us to measure how many honest nodes get strikes or are blacklisted because of measurement noise,
+ depending on the tolerance of the triangle inequality checks.
+
+ There are multiple configurable variables (see below)
+
+ Once configured, the test should be run from the terminal within the latencyprotocol folder using the command:
+
+	go test -run TestVarToleranceGraphCreation -timeout=24h
+
+
+ The generated data can be found under python_graphs/var_tolerance
+*/
+
+package latencyprotocol
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+func TestVarToleranceGraphCreation(t *testing.T) {
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	//configs =====================================================================================================
+	nbNodes := 30
+	nbNetworks := 10                                  //nb different honest networks generated
+	maxDistance := 1000                               //side of the square in which the nodes are placed
+	noise := 20                                       //maximum measurement error of a latency
+	absoluteTolerances := []int{0, 5, 10, 20, 40, 80} //absolute margins tested
+	relativeTolerances := []float64{0, 0.01, 0.02, 0.05, 0.1}
+	withSuspects := true //use enhanced blacklisting algorithm
+	//=============================================================================================================
+
+	filename := "test_" +
+		strconv.Itoa(nbNodes) + "_nodes_" +
+		strconv.Itoa(nbNetworks) + "_networks" +
+		"_noise_" + strconv.Itoa(noise)
+
+	if withSuspects {
+		filename += "_with_suspects"
+	}
+
+	err := CreateToleranceFalsePositiveData(filename, nbNodes, nbNetworks, maxDistance, noise,
+		absoluteTolerances, relativeTolerances, withSuspects)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+//CreateToleranceFalsePositiveData writes, for honest networks with noisy latencies and each tolerance, the ratio of
+//nodes which got strikes and of nodes which were blacklisted, with and without the spreads of the latencies
+func CreateToleranceFalsePositiveData(filename string, nbNodes int, nbNetworks int, maxDistance int, noise int,
+	absoluteTolerances []int, relativeTolerances []float64, withSuspects bool) error {
+
+	dir := "../../python_graphs/var_tolerance/data/"
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(dir + filename + ".csv")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fmt.Fprintln(file, "network,with_spread,absolute_tolerance,relative_tolerance,strike_rate,false_positive_rate")
+
+	for network := 0; network < nbNetworks; network++ {
+		chain := chainWithNoisyLatencies(nbNodes, maxDistance, noise, false)
+
+		for _, withSpread := range []bool{false, true} {
+			if withSpread {
+				for _, block := range chain.Blocks {
+					for key, latency := range block.Latencies {
+						latency.Spread = time.Duration(noise)
+						block.Latencies[key] = latency
+					}
+				}
+			}
+
+			for _, absolute := range absoluteTolerances {
+				for _, relative := range relativeTolerances {
+					tolerance := NewTriangleTolerance(time.Duration(absolute), relative)
+
+					strikes, err := createBlacklistOnAllCores(chain, tolerance, false, FixedThreshold(0), false)
+					if err != nil {
+						return err
+					}
+					blacklist, err := createBlacklistOnAllCores(chain, tolerance, false, UpperThresholdPolicy{}, withSuspects)
+					if err != nil {
+						return err
+					}
+
+					strikeRate := float64(strikes.Size()) / float64(nbNodes)
+					falsePositiveRate := float64(blacklist.Size()) / float64(nbNodes)
+
+					fmt.Fprintln(file, strconv.Itoa(network)+","+
+						strconv.FormatBool(withSpread)+","+
+						strconv.Itoa(absolute)+","+
+						strconv.FormatFloat(relative, 'f', -1, 64)+","+
+						strconv.FormatFloat(strikeRate, 'f', 4, 64)+","+
+						strconv.FormatFloat(falsePositiveRate, 'f', 4, 64))
+				}
+			}
+		}
+	}
+
+	return nil
+}
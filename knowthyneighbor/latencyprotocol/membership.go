@@ -0,0 +1,285 @@
+/*
+membership records the changes of the set of nodes of the chain: a node can leave, rotate its key or be revoked
+by the validators. Every such change is a block of the node without latencies, after which the previous blocks
+of the node are no longer used: its latencies are ignored by the blacklisting, the distance estimation and the peer selection
+
+	A leave block is signed by the key of the node, which can later join again with a new latency block
+	A key rotation block is signed by both the old and the new key, the old key cannot be used anymore
+	A revocation block is only signed collectively, validators accepting it only if they blacklisted the node
+
+The node signs its change for a given position of the chain, so that an old leave or key rotation block cannot be
+appended again later: if blocks are added before the change is appended, the node has to sign it again
+*/
+
+package latencyprotocol
+
+import (
+	"errors"
+
+	"go.dedis.ch/protobuf"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+//BlockKind tells what a block records
+type BlockKind int
+
+const (
+	//LatencyBlock records the latencies measured by its node
+	LatencyBlock BlockKind = iota
+	//LeaveBlock records that its node left the system
+	LeaveBlock
+	//KeyRotationBlock records that its node replaced its key by a new one
+	KeyRotationBlock
+	//RevocationBlock records that the validators evicted its node
+	RevocationBlock
+)
+
+var blockKindNames = []string{
+	"latency",
+	"leave",
+	"key rotation",
+	"revocation",
+}
+
+//String returns a readable name for the kind of block
+func (kind BlockKind) String() string {
+	if kind < 0 || int(kind) >= len(blockKindNames) {
+		return "unknown"
+	}
+	return blockKindNames[kind]
+}
+
+//IsMembershipChange tells whether the block changes the membership of its node instead of recording latencies
+func (block *Block) IsMembershipChange() bool {
+	return block.Kind != LatencyBlock
+}
+
+//MembershipChange is the message signed by a node leaving or rotating its key. It includes the position of the
+//block in the chain, so that the signature cannot be reused for another block
+type MembershipChange struct {
+	Kind         BlockKind
+	Key          []byte
+	NewKey       []byte
+	Index        int
+	PreviousHash []byte
+}
+
+//membershipMessage encodes the change of membership of a block, which the node signs
+func membershipMessage(block *Block) ([]byte, error) {
+	return protobuf.Encode(&MembershipChange{block.Kind, block.ID.PublicKey, block.NewKey, block.Index, block.PreviousHash})
+}
+
+//NewLeaveBlock creates the block by which a node leaves the system, linked to the end of the chain
+func NewLeaveBlock(id *NodeID, privateKey sigAlg.PrivateKey, chain *Chain) (*Block, error) {
+
+	block := &Block{
+		ID:        id,
+		Latencies: make(map[string]ConfirmedLatency),
+		Kind:      LeaveBlock,
+	}
+
+	err := chain.Link(block)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := membershipMessage(block)
+	if err != nil {
+		return nil, err
+	}
+	block.NodeSignature = sigAlg.Sign(privateKey, msg)
+
+	return block, nil
+}
+
+//NewKeyRotationBlock creates the block by which a node replaces its key, linked to the end of the chain and signed
+//by both keys
+func NewKeyRotationBlock(id *NodeID, privateKey sigAlg.PrivateKey, newPrivateKey sigAlg.PrivateKey, chain *Chain) (*Block, error) {
+
+	block := &Block{
+		ID:        id,
+		Latencies: make(map[string]ConfirmedLatency),
+		Kind:      KeyRotationBlock,
+		NewKey:    newPrivateKey.Public().(sigAlg.PublicKey),
+	}
+
+	err := chain.Link(block)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := membershipMessage(block)
+	if err != nil {
+		return nil, err
+	}
+	block.NodeSignature = sigAlg.Sign(privateKey, msg)
+	block.NewKeySignature = sigAlg.Sign(newPrivateKey, msg)
+
+	return block, nil
+}
+
+//NewRevocationBlock creates the block by which the validators evict a node, it only needs their collective signature
+func NewRevocationBlock(id *NodeID) *Block {
+	return &Block{
+		ID:        id,
+		Latencies: make(map[string]ConfirmedLatency),
+		Kind:      RevocationBlock,
+	}
+}
+
+//verifyMembershipSignatures checks the signatures of the node on a leave or key rotation block at its position
+//in the chain
+func verifyMembershipSignatures(block *Block) error {
+
+	if block.Kind == RevocationBlock {
+		return nil
+	}
+
+	if len(block.ID.PublicKey) != sigAlg.PublicKeySize {
+		return errors.New("Invalid public key")
+	}
+
+	msg, err := membershipMessage(block)
+	if err != nil {
+		return err
+	}
+
+	if !sigAlg.Verify(block.ID.PublicKey, msg, block.NodeSignature) {
+		return errors.New("Incorrect signature of the node")
+	}
+
+	if block.Kind == KeyRotationBlock {
+		if len(block.NewKey) != sigAlg.PublicKeySize {
+			return errors.New("Invalid new key")
+		}
+		if !sigAlg.Verify(block.NewKey, msg, block.NewKeySignature) {
+			return errors.New("Incorrect signature of the new key")
+		}
+	}
+
+	return nil
+}
+
+//lastMembershipChanges returns, for every key, the position in the chain of the last block changing its membership
+func (chain *Chain) lastMembershipChanges() map[string]int {
+	changes := make(map[string]int)
+	for i, block := range chain.Blocks {
+		if block.IsMembershipChange() {
+			changes[string(block.ID.PublicKey)] = i
+		}
+	}
+	return changes
+}
+
+//ActiveBlocks returns the latency blocks of the chain whose node is still a member: the blocks followed by a leave,
+//key rotation or revocation block of their node are left out, so that their latencies are no longer used
+func (chain *Chain) ActiveBlocks() []*Block {
+
+	changes := chain.lastMembershipChanges()
+
+	blocks := make([]*Block, 0, len(chain.Blocks))
+	for i, block := range chain.Blocks {
+		if block.IsMembershipChange() {
+			continue
+		}
+		if change, changed := changes[string(block.ID.PublicKey)]; changed && change > i {
+			continue
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks
+}
+
+//ActiveChain returns a chain made of the active blocks of the chain, see ActiveBlocks.
+//Its blocks are not linked anymore, it is only meant to be analysed
+func (chain *Chain) ActiveChain() *Chain {
+	return &Chain{Blocks: chain.ActiveBlocks(), BucketName: chain.BucketName}
+}
+
+//LatestChain returns a chain made of the latest active block of each node, in the order of the chain: the latencies
+//of a block replace the latencies of the previous blocks of its node. Its blocks are not linked anymore,
+//it is only meant to be analysed
+func (chain *Chain) LatestChain() *Chain {
+
+	active := chain.ActiveBlocks()
+
+	latest := make(map[string]int)
+	for i, block := range active {
+		latest[string(block.ID.PublicKey)] = i
+	}
+
+	blocks := make([]*Block, 0, len(latest))
+	for i, block := range active {
+		if latest[string(block.ID.PublicKey)] == i {
+			blocks = append(blocks, block)
+		}
+	}
+
+	return &Chain{Blocks: blocks, BucketName: chain.BucketName}
+}
+
+//ActiveNodes returns the nodes of the active blocks of the chain, each node once, in the order in which they joined
+func (chain *Chain) ActiveNodes() []*NodeID {
+
+	latest := make(map[string]*NodeID)
+	order := make([]string, 0)
+	for _, block := range chain.ActiveBlocks() {
+		key := string(block.ID.PublicKey)
+		if _, known := latest[key]; !known {
+			order = append(order, key)
+		}
+		latest[key] = block.ID
+	}
+
+	nodes := make([]*NodeID, len(order))
+	for i, key := range order {
+		nodes[i] = latest[key]
+	}
+	return nodes
+}
+
+//activeKeys returns the keys latencies can be measured with: the keys of the active blocks, and the new keys
+//of key rotations which were not themselves replaced
+func (chain *Chain) activeKeys() map[string]bool {
+
+	keys := make(map[string]bool)
+	for _, block := range chain.ActiveBlocks() {
+		keys[string(block.ID.PublicKey)] = true
+	}
+
+	changes := chain.lastMembershipChanges()
+	for _, block := range chain.Blocks {
+		if block.Kind != KeyRotationBlock {
+			continue
+		}
+		if _, changed := changes[string(block.NewKey)]; !changed {
+			keys[string(block.NewKey)] = true
+		}
+	}
+
+	return keys
+}
+
+//retiredKeys returns the keys which cannot be used anymore: revoked keys and keys replaced by a key rotation
+func (chain *Chain) retiredKeys() map[string]BlockKind {
+	retired := make(map[string]BlockKind)
+	for _, block := range chain.Blocks {
+		if block.Kind == RevocationBlock || block.Kind == KeyRotationBlock {
+			retired[string(block.ID.PublicKey)] = block.Kind
+		}
+	}
+	return retired
+}
+
+//knownKeys returns all the keys which ever appeared in the chain, including new keys of key rotations
+func (chain *Chain) knownKeys() map[string]bool {
+	keys := make(map[string]bool)
+	for _, block := range chain.Blocks {
+		keys[string(block.ID.PublicKey)] = true
+		if block.Kind == KeyRotationBlock {
+			keys[string(block.NewKey)] = true
+		}
+	}
+	return keys
+}
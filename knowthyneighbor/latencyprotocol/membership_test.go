@@ -0,0 +1,220 @@
+package latencyprotocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+//chainOfKeys creates a chain with a block without latencies for each of nbNodes new keys
+func chainOfKeys(t *testing.T, nbNodes int) (*Chain, []sigAlg.PrivateKey) {
+	chain := &Chain{Blocks: []*Block{}, BucketName: []byte("testBucket")}
+	keys := make([]sigAlg.PrivateKey, nbNodes)
+
+	for i := range keys {
+		pubKey, privKey, err := sigAlg.GenerateKey(nil)
+		require.NoError(t, err)
+		keys[i] = privKey
+
+		block := &Block{ID: &NodeID{PublicKey: pubKey}, Latencies: make(map[string]ConfirmedLatency)}
+		require.NoError(t, chain.Link(block))
+		require.NoError(t, chain.Append(block))
+	}
+
+	return chain, keys
+}
+
+//requireRejection checks that a block is rejected for the given reason
+func requireRejection(t *testing.T, err error, reason RejectionReason) {
+	require.Error(t, err)
+	rejection, ok := err.(*BlockRejection)
+	require.True(t, ok, err.Error())
+	require.Equal(t, reason, rejection.Reason, err.Error())
+}
+
+//appendBlock links and appends a block to the chain
+func appendBlock(t *testing.T, chain *Chain, block *Block) {
+	require.NoError(t, chain.Link(block))
+	require.NoError(t, chain.Append(block))
+}
+
+//linkedBlock creates a block without latencies for a node, linked to the end of the chain
+func linkedBlock(t *testing.T, chain *Chain, id *NodeID) *Block {
+	block := &Block{ID: id, Latencies: make(map[string]ConfirmedLatency)}
+	require.NoError(t, chain.Link(block))
+	return block
+}
+
+func TestLeaveBlock(t *testing.T) {
+
+	chain, keys := chainOfKeys(t, 3)
+	params := DefaultBlockVerificationParams()
+	id := chain.Blocks[0].ID
+
+	//the leave has to be signed by the node itself
+	forged, err := NewLeaveBlock(id, keys[1], chain)
+	require.NoError(t, err)
+	requireRejection(t, VerifyBlock(forged, chain, params), BadMembershipSignature)
+
+	leave, err := NewLeaveBlock(id, keys[0], chain)
+	require.NoError(t, err)
+	require.NoError(t, VerifyBlock(leave, chain, params))
+	appendBlock(t, chain, leave)
+
+	require.Equal(t, 2, len(chain.ActiveNodes()))
+	require.Equal(t, 2, len(chain.ActiveBlocks()))
+	//the node cannot leave again
+	leave, err = NewLeaveBlock(id, keys[0], chain)
+	require.NoError(t, err)
+	requireRejection(t, VerifyBlock(leave, chain, params), UnknownNode)
+
+	//the node can join again
+	rejoin := linkedBlock(t, chain, id)
+	require.NoError(t, VerifyBlock(rejoin, chain, params))
+	appendBlock(t, chain, rejoin)
+	require.Equal(t, 3, len(chain.ActiveNodes()))
+
+	require.True(t, chain.Validate(nil, DefaultChainValidationParams()).Valid())
+}
+
+func TestKeyRotationBlock(t *testing.T) {
+
+	chain, keys := chainOfKeys(t, 3)
+	params := DefaultBlockVerificationParams()
+	id := chain.Blocks[1].ID
+
+	newPubKey, newPrivKey, err := sigAlg.GenerateKey(nil)
+	require.NoError(t, err)
+
+	//both keys have to sign
+	forged, err := NewKeyRotationBlock(id, keys[1], newPrivKey, chain)
+	require.NoError(t, err)
+	forged.NewKeySignature = forged.NodeSignature
+	requireRejection(t, VerifyBlock(forged, chain, params), BadMembershipSignature)
+
+	//the new key cannot be the key of another node
+	taken, err := NewKeyRotationBlock(id, keys[1], keys[2], chain)
+	require.NoError(t, err)
+	requireRejection(t, VerifyBlock(taken, chain, params), MalformedBlock)
+
+	rotation, err := NewKeyRotationBlock(id, keys[1], newPrivKey, chain)
+	require.NoError(t, err)
+	require.NoError(t, VerifyBlock(rotation, chain, params))
+	appendBlock(t, chain, rotation)
+
+	//the old key is retired, the new key is a member
+	requireRejection(t, VerifyBlock(linkedBlock(t, chain, id), chain, params), RetiredNode)
+	require.True(t, chain.activeKeys()[string(newPubKey)])
+	require.False(t, chain.activeKeys()[string(id.PublicKey)])
+
+	rotated := linkedBlock(t, chain, &NodeID{PublicKey: newPubKey})
+	require.NoError(t, VerifyBlock(rotated, chain, params))
+	appendBlock(t, chain, rotated)
+	require.Equal(t, 3, len(chain.ActiveNodes()))
+
+	require.True(t, chain.Validate(nil, DefaultChainValidationParams()).Valid())
+
+	//the membership fields are covered by the hash and the encoding
+	hash, err := rotation.Hash()
+	require.NoError(t, err)
+	blockBytes, err := EncodeBlock(rotation)
+	require.NoError(t, err)
+	decoded, err := DecodeBlock(blockBytes, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, KeyRotationBlock, decoded.Kind)
+	decodedHash, err := decoded.Hash()
+	require.NoError(t, err)
+	require.Equal(t, hash, decodedHash)
+
+	decoded.NewKey = newPubKey[:len(newPubKey)-1]
+	changedHash, err := decoded.Hash()
+	require.NoError(t, err)
+	require.NotEqual(t, hash, changedHash)
+}
+
+func TestMembershipReplay(t *testing.T) {
+
+	chain, keys := chainOfKeys(t, 3)
+	params := DefaultBlockVerificationParams()
+	id := chain.Blocks[0].ID
+
+	leave, err := NewLeaveBlock(id, keys[0], chain)
+	require.NoError(t, err)
+	appendBlock(t, chain, leave)
+
+	rejoin := &Block{ID: id, Latencies: make(map[string]ConfirmedLatency)}
+	appendBlock(t, chain, rejoin)
+
+	//the old leave block cannot be appended again as it is
+	replayed := *leave
+	require.Error(t, chain.Append(&replayed))
+
+	//nor linked again to the end of the chain, as the node signed it for its former position
+	require.NoError(t, chain.Link(&replayed))
+	requireRejection(t, VerifyBlock(&replayed, chain, params), BadMembershipSignature)
+
+	//the same holds for a key rotation
+	newPubKey, newPrivKey, err := sigAlg.GenerateKey(nil)
+	require.NoError(t, err)
+	rotation, err := NewKeyRotationBlock(chain.Blocks[1].ID, keys[1], newPrivKey, chain)
+	require.NoError(t, err)
+
+	//a block added in between moves the end of the chain, the rotation has to be signed again
+	appendBlock(t, chain, &Block{ID: chain.Blocks[2].ID, Latencies: make(map[string]ConfirmedLatency)})
+	require.NoError(t, chain.Link(rotation))
+	requireRejection(t, VerifyBlock(rotation, chain, params), BadMembershipSignature)
+
+	rotation, err = NewKeyRotationBlock(chain.Blocks[1].ID, keys[1], newPrivKey, chain)
+	require.NoError(t, err)
+	require.NoError(t, VerifyBlock(rotation, chain, params))
+	appendBlock(t, chain, rotation)
+	require.True(t, chain.activeKeys()[string(newPubKey)])
+
+	require.True(t, chain.Validate(nil, DefaultChainValidationParams()).Valid())
+}
+
+func TestRevocationBlock(t *testing.T) {
+
+	chain, _ := chainOfKeys(t, 3)
+	params := DefaultBlockVerificationParams()
+	id := chain.Blocks[2].ID
+
+	//without a blacklist, or if the node is not blacklisted, it cannot be revoked
+	revocation := NewRevocationBlock(id)
+	require.NoError(t, chain.Link(revocation))
+	requireRejection(t, VerifyBlock(revocation, chain, params), UnjustifiedRevocation)
+
+	blacklist := NewBlacklistset()
+	params.Blacklist = &blacklist
+	requireRejection(t, VerifyBlock(revocation, chain, params), UnjustifiedRevocation)
+
+	blacklist.Add(id.PublicKey)
+	require.NoError(t, VerifyBlock(revocation, chain, params))
+	appendBlock(t, chain, revocation)
+
+	requireRejection(t, VerifyBlock(linkedBlock(t, chain, id), chain, DefaultBlockVerificationParams()), RetiredNode)
+	require.Equal(t, 2, len(chain.ActiveNodes()))
+}
+
+func TestBlacklistIgnoresLeftNodes(t *testing.T) {
+
+	chain, _ := chainWithAllLatenciesSame(7, 10)
+	setLiarAndVictim(chain, "N0", "N1", 70)
+	setLiarAndVictim(chain, "N0", "N2", 200)
+	setLiarAndVictim(chain, "N0", "N3", 2000)
+	setLiarAndVictim(chain, "N0", "N4", 20000)
+	setLiarAndVictim(chain, "N0", "N5", 200000)
+	setLiarAndVictim(chain, "N0", "N6", 2000000)
+
+	blacklist, err := CreateBlacklist(chain, 0, false, UpperThresholdPolicy{}, true)
+	require.NoError(t, err)
+	require.True(t, blacklist.ContainsAsString("N0"))
+
+	//once the liar is revoked, its latencies are not used anymore
+	chain.Blocks = append(chain.Blocks, NewRevocationBlock(&NodeID{PublicKey: sigAlg.PublicKey("N0")}))
+
+	blacklist, err = CreateBlacklist(chain, 0, false, UpperThresholdPolicy{}, true)
+	require.NoError(t, err)
+	require.Equal(t, 0, blacklist.Size())
+}
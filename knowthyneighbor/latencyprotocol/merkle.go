@@ -0,0 +1,160 @@
+/*
+merkle commits to the latencies of a block with a Merkle tree, so that a single latency can be proven to belong to a block
+
+The leaves are the latencies sorted by public key. Leaves and inner nodes are hashed with different prefixes,
+and a node without sibling at the end of a level is moved up to the next level unchanged
+*/
+
+package latencyprotocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"sort"
+)
+
+const (
+	merkleLeafPrefix  = 0
+	merkleInnerPrefix = 1
+)
+
+//LatencyProof proves that a latency is a leaf of the Merkle tree of the latencies of a block
+type LatencyProof struct {
+	//LeafIndex is the position of the latency among the latencies of the block sorted by public key
+	LeafIndex int
+	//NbLeaves is the number of latencies in the block
+	NbLeaves int
+	//Siblings are the hashes of the siblings on the path from the leaf to the root
+	Siblings [][]byte
+}
+
+//latencyLeaf hashes a latency measured with the node of the given public key
+func latencyLeaf(publicKey string, latency ConfirmedLatency) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleLeafPrefix})
+	writeBytes(h, []byte(publicKey))
+	writeInt(h, int64(latency.Latency))
+	writeBytes(h, latency.SignedLatency)
+	writeInt(h, latency.Timestamp.UnixNano())
+	writeBytes(h, latency.SignedConfirmation)
+	writeInt(h, int64(latency.Spread))
+	return h.Sum(nil)
+}
+
+//merkleInner hashes two children of the Merkle tree
+func merkleInner(left []byte, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleInnerPrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+//merkleLevels returns all the levels of the Merkle tree built on the leaves, from the leaves to the root
+func merkleLevels(leaves [][]byte) [][][]byte {
+	levels := [][][]byte{leaves}
+	for level := leaves; len(level) > 1; {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleInner(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+//latencyLeaves returns the leaves of the Merkle tree of the latencies of a block
+func (block *Block) latencyLeaves() [][]byte {
+	entries := block.SortedLatencies()
+	leaves := make([][]byte, len(entries))
+	for i, entry := range entries {
+		leaves[i] = latencyLeaf(entry.PublicKey, entry.Latency)
+	}
+	return leaves
+}
+
+//LatenciesRoot returns the root of the Merkle tree of the latencies of the block.
+//A block without latencies has the hash of the empty string as root
+func (block *Block) LatenciesRoot() []byte {
+	leaves := block.latencyLeaves()
+	if len(leaves) == 0 {
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	}
+	levels := merkleLevels(leaves)
+	return levels[len(levels)-1][0]
+}
+
+//ProveLatency returns the latency of the block measured with the node of the given public key, along with the proof
+//that it belongs to the latencies committed to by the header of the block
+func (block *Block) ProveLatency(publicKey string) (*ConfirmedLatency, *LatencyProof, error) {
+
+	latency, exists := block.Latencies[publicKey]
+	if !exists {
+		return nil, nil, errors.New("No latency with this node in the block")
+	}
+
+	keys := make([]string, 0, len(block.Latencies))
+	for key := range block.Latencies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	index := sort.SearchStrings(keys, publicKey)
+
+	proof := &LatencyProof{LeafIndex: index, NbLeaves: len(keys), Siblings: make([][]byte, 0)}
+
+	levels := merkleLevels(block.latencyLeaves())
+	for _, level := range levels[:len(levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Siblings = append(proof.Siblings, level[sibling])
+		}
+		index /= 2
+	}
+
+	return &latency, proof, nil
+}
+
+//VerifyLatency checks that a latency measured with the node of the given public key belongs to the latencies
+//committed to by the header
+func (header *BlockHeader) VerifyLatency(publicKey string, latency ConfirmedLatency, proof *LatencyProof) error {
+
+	if proof == nil || proof.LeafIndex < 0 || proof.LeafIndex >= proof.NbLeaves {
+		return errors.New("Invalid proof")
+	}
+
+	current := latencyLeaf(publicKey, latency)
+	index := proof.LeafIndex
+	siblings := proof.Siblings
+
+	for width := proof.NbLeaves; width > 1; width = (width + 1) / 2 {
+		if index^1 < width {
+			if len(siblings) == 0 {
+				return errors.New("Proof too short")
+			}
+			if index%2 == 0 {
+				current = merkleInner(current, siblings[0])
+			} else {
+				current = merkleInner(siblings[0], current)
+			}
+			siblings = siblings[1:]
+		}
+		index /= 2
+	}
+
+	if len(siblings) != 0 {
+		return errors.New("Proof too long")
+	}
+
+	if !bytes.Equal(current, header.LatenciesRoot) {
+		return errors.New("Latency not committed to by the header")
+	}
+
+	return nil
+}
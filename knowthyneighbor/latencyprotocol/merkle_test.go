@@ -0,0 +1,90 @@
+package latencyprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+//blockWithLatencies creates a block with the given number of latencies
+func blockWithLatencies(nbLatencies int) *Block {
+	latencies := make(map[string]ConfirmedLatency)
+	for i := 0; i < nbLatencies; i++ {
+		latencies[numbersToNodes(i+1)] = ConfirmedLatency{time.Duration(10 * (i + 1)), []byte("signed"), time.Now(), []byte("confirmed"), 0}
+	}
+	return &Block{ID: &NodeID{PublicKey: sigAlg.PublicKey(numbersToNodes(0))}, Latencies: latencies}
+}
+
+func TestLatencyProof(t *testing.T) {
+
+	for nbLatencies := 1; nbLatencies <= 9; nbLatencies++ {
+		block := blockWithLatencies(nbLatencies)
+		header, err := block.Header()
+		require.NoError(t, err)
+
+		for key := range block.Latencies {
+			latency, proof, err := block.ProveLatency(key)
+			require.NoError(t, err)
+			require.NoError(t, header.VerifyLatency(key, *latency, proof), "%d latencies", nbLatencies)
+
+			//the latency cannot be modified or claimed for another node
+			modified := *latency
+			modified.Latency++
+			require.Error(t, header.VerifyLatency(key, modified, proof))
+			if nbLatencies > 1 {
+				other := numbersToNodes(1)
+				if other == key {
+					other = numbersToNodes(2)
+				}
+				require.Error(t, header.VerifyLatency(other, *latency, proof))
+			}
+
+			//nor can the proof
+			if len(proof.Siblings) > 0 {
+				truncated := *proof
+				truncated.Siblings = proof.Siblings[1:]
+				require.Error(t, header.VerifyLatency(key, *latency, &truncated))
+			}
+			extended := *proof
+			extended.Siblings = append(append([][]byte{}, proof.Siblings...), header.LatenciesRoot)
+			require.Error(t, header.VerifyLatency(key, *latency, &extended))
+		}
+	}
+
+	block := blockWithLatencies(3)
+	_, _, err := block.ProveLatency(numbersToNodes(7))
+	require.Error(t, err)
+
+	header, err := block.Header()
+	require.NoError(t, err)
+	require.Error(t, header.VerifyLatency(numbersToNodes(1), block.Latencies[numbersToNodes(1)], nil))
+}
+
+func TestLatenciesRoot(t *testing.T) {
+
+	block := blockWithLatencies(5)
+	root := block.LatenciesRoot()
+
+	//the root commits to every latency, and so does the hash of the block
+	hash, err := block.Hash()
+	require.NoError(t, err)
+	for key, latency := range block.Latencies {
+		modified := copyBlock(block)
+		latency.Timestamp = latency.Timestamp.Add(time.Nanosecond)
+		modified.Latencies[key] = latency
+		require.NotEqual(t, root, modified.LatenciesRoot())
+		modifiedHash, err := modified.Hash()
+		require.NoError(t, err)
+		require.NotEqual(t, hash, modifiedHash)
+
+		//including its spread
+		modifiedRoot := modified.LatenciesRoot()
+		latency.Spread++
+		modified.Latencies[key] = latency
+		require.NotEqual(t, modifiedRoot, modified.LatenciesRoot())
+	}
+
+	require.NotEqual(t, root, blockWithLatencies(0).LatenciesRoot())
+}
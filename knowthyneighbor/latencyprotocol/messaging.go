@@ -288,7 +288,7 @@ func (Node *Node) sendMessage3(msg *udp.PingMsg, msgContent *PingMsg2) error {
 
 	latency := localtime.Sub(latencyConstr.LocalTimestamps[0])
 
-	unsignedLatency, err := protobuf.Encode(&LatencyWrapper{latency})
+	unsignedLatency, err := protobuf.Encode(&LatencyWrapper{Latency: latency})
 	if err != nil {
 		log.Warn(err)
 		return err
@@ -399,7 +399,7 @@ func (Node *Node) sendMessage4(msg *udp.PingMsg, msgContent *PingMsg3) error {
 		return errors.New("Latencies too different")
 	}
 
-	unsignedLocalLatency, err := protobuf.Encode(&LatencyWrapper{localLatency})
+	unsignedLocalLatency, err := protobuf.Encode(&LatencyWrapper{Latency: localLatency})
 	if err != nil {
 		log.Warn(err)
 		return err
@@ -608,6 +608,7 @@ func (Node *Node) checkMessage5(msg *udp.PingMsg) (*ConfirmedLatency, bool) {
 
 	newLatency := &ConfirmedLatency{
 		Latency:            latencyConstr.Latency,
+		SignedLatency:      latencyConstr.SignedLatency,
 		Timestamp:          sentTimestamp,
 		SignedConfirmation: content.DoubleSignedForeignLatency,
 	}
@@ -83,14 +83,27 @@ func passOnEndSignal(src chan bool, dst1 chan bool, dst2 chan bool, wg *sync.Wai
 
 //AddBlock lets a node add a new block to a chain
 func (Node *Node) AddBlock(chain *Chain) {
+	Node.AddBlockWithReputation(chain, nil)
+}
+
+//AddBlockWithReputation lets a node add a new block to a chain, measuring its latencies to the most trusted nodes.
+//Without reputation, the first nodes to have joined are used
+func (Node *Node) AddBlockWithReputation(chain *Chain, reputation *Reputation) {
 
-	// send pings
-	nbLatenciesNeeded := min(nbLatencies, len(chain.Blocks))
+	// send pings to the nodes which are still members
+	for _, peer := range selectPeers(chain, reputation) {
+		Node.sendMessage1(peer)
+	}
+}
 
-	//for now just ping the first ones
-	for i := 0; i < nbLatenciesNeeded && i < len(chain.Blocks); i++ {
-		Node.sendMessage1(chain.Blocks[i].ID)
+//selectPeers returns the nodes of the chain a new block measures its latencies to: the most trusted nodes still members,
+//or the first ones to have joined without reputation
+func selectPeers(chain *Chain, reputation *Reputation) []*NodeID {
+	nodes := chain.ActiveNodes()
+	if reputation != nil {
+		return reputation.MostTrusted(nodes, nbLatencies)
 	}
+	return nodes[:min(nbLatencies, len(nodes))]
 }
 
 func min(a, b int) int {
@@ -49,12 +49,12 @@ func TestAddBlock(t *testing.T) {
 	_, el, _ := local.GenTree(4, false)
 	defer local.CloseAll()
 
-	chain := &Chain{make([]*Block, 1), []byte("testBucket")}
+	chain := &Chain{Blocks: make([]*Block, 1), BucketName: []byte("testBucket")}
 
 	newNode1, finish1, wg1, err := NewNode(el.List[0], el.List[1].Address, tSuite, 1)
 	require.NoError(t, err)
 
-	chain.Blocks[0] = &Block{newNode1.ID, make(map[string]ConfirmedLatency, 0)}
+	chain.Blocks[0] = &Block{ID: newNode1.ID, Latencies: make(map[string]ConfirmedLatency, 0)}
 
 	newNode2, finish2, wg2, err := NewNode(el.List[2], el.List[3].Address, tSuite, 1)
 
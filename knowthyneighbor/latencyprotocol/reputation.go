@@ -0,0 +1,152 @@
+/*
+reputation accumulates the strikes of repeated blacklisting runs into a trust score for each node, instead of
+deciding from a single run whether a node is over the threshold or not
+
+Each run adds to the penalty of a node its strikes, relative to the maximum number of strikes a victim can get.
+Penalties decay exponentially with time, so that old strikes weigh less and nodes which stay consistent recover.
+The trust of a node is 1/(1+penalty): 1 for a node which never got strikes, tending to 0 for a node striked at every run
+*/
+
+package latencyprotocol
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+//minPenalty is the penalty under which a node is considered to have fully recovered and is forgotten
+const minPenalty = 1e-3
+
+//ReputationParams holds how the reputation is computed
+type ReputationParams struct {
+	//HalfLife is the time after which a penalty is halved
+	HalfLife time.Duration
+	//Tolerance is the margin of the triangles examined at each run
+	Tolerance TriangleTolerance
+	//Period is the shortest time between two runs recorded from a blacklist index, see UpdateFromIndex
+	Period time.Duration
+}
+
+//DefaultReputationParams returns the parameters used by default
+func DefaultReputationParams() ReputationParams {
+	return ReputationParams{
+		HalfLife:  time.Hour,
+		Tolerance: TriangleTolerance{Absolute: 0},
+		Period:    10 * time.Minute,
+	}
+}
+
+//Reputation holds the decayed penalties of the nodes
+type Reputation struct {
+	Params ReputationParams
+	//Penalties are the penalties of the nodes as of Updated, nodes without penalty are left out
+	Penalties map[string]float64
+	//Updated is the time of the last run recorded
+	Updated time.Time
+}
+
+//NewReputation creates a reputation in which every node is trusted
+func NewReputation(params ReputationParams) *Reputation {
+	return &Reputation{Params: params, Penalties: make(map[string]float64)}
+}
+
+//decayFactor is by how much a penalty is multiplied after some time
+func (reputation *Reputation) decayFactor(elapsed time.Duration) float64 {
+	if reputation.Params.HalfLife <= 0 {
+		return 0
+	}
+	return math.Pow(0.5, float64(elapsed)/float64(reputation.Params.HalfLife))
+}
+
+//decay reduces the penalties for the time elapsed since the last update
+func (reputation *Reputation) decay(at time.Time) {
+
+	if !at.After(reputation.Updated) {
+		return
+	}
+
+	factor := reputation.decayFactor(at.Sub(reputation.Updated))
+	for node, penalty := range reputation.Penalties {
+		penalty *= factor
+		if penalty < minPenalty {
+			delete(reputation.Penalties, node)
+		} else {
+			reputation.Penalties[node] = penalty
+		}
+	}
+	reputation.Updated = at
+}
+
+//Record adds the strikes of a blacklisting run over N nodes, made at the given time, to the reputation.
+//The strikes must not be thresholded, as nodes under the threshold also lose trust
+func (reputation *Reputation) Record(strikes *Blacklistset, N int, at time.Time) {
+
+	reputation.decay(at)
+
+	threshold := UpperThreshold(N)
+	if threshold <= 0 {
+		threshold = 1
+	}
+
+	for node, nbStrikes := range strikes.Strikes {
+		if nbStrikes > 0 {
+			reputation.Penalties[node] += float64(nbStrikes) / float64(threshold)
+		}
+	}
+}
+
+//Update runs the blacklisting on the chain and records its strikes at the given time
+func (reputation *Reputation) Update(chain *Chain, at time.Time) error {
+	active := chain.LatestChain()
+	strikes, err := CreateBlacklistWithTolerance(active, reputation.Params.Tolerance, false, FixedThreshold(0), false)
+	if err != nil {
+		return err
+	}
+	reputation.Record(&strikes, len(active.Blocks), at)
+	return nil
+}
+
+//UpdateFromIndex records the strikes of a blacklist index at the given time, as Update does for the chain of the index,
+//without examining all its triangles again. The triangles are examined with the tolerance of the index.
+//The strikes of the index are the strikes of the whole chain, which barely change from one block to the next, so a run
+//is only recorded if Period elapsed since the last one: it returns whether it recorded one
+func (reputation *Reputation) UpdateFromIndex(index *BlacklistIndex, at time.Time) bool {
+
+	if !reputation.Updated.IsZero() && at.Sub(reputation.Updated) < reputation.Params.Period {
+		return false
+	}
+
+	strikes := index.Blacklist(false, FixedThreshold(0), false, nil)
+	reputation.Record(&strikes, len(index.latest), at)
+	return true
+}
+
+//Trust returns the trust score of a node as of the last update, between 0 and 1
+func (reputation *Reputation) Trust(key string) float64 {
+	return 1 / (1 + reputation.Penalties[key])
+}
+
+//TrustAt returns the trust score a node will have at the given time if it gets no more strikes
+func (reputation *Reputation) TrustAt(key string, at time.Time) float64 {
+	penalty := reputation.Penalties[key]
+	if at.After(reputation.Updated) {
+		penalty *= reputation.decayFactor(at.Sub(reputation.Updated))
+	}
+	return 1 / (1 + penalty)
+}
+
+//MostTrusted returns the n most trusted nodes among the given ones, the order of the nodes deciding between equal scores
+func (reputation *Reputation) MostTrusted(nodes []*NodeID, n int) []*NodeID {
+
+	sorted := make([]*NodeID, len(nodes))
+	copy(sorted, nodes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return reputation.Trust(string(sorted[i].PublicKey)) > reputation.Trust(string(sorted[j].PublicKey))
+	})
+
+	if n < len(sorted) {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
@@ -0,0 +1,166 @@
+package latencyprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReputationDecay(t *testing.T) {
+	N := 7
+	params := DefaultReputationParams()
+	reputation := NewReputation(params)
+
+	chain, nodes := chainWithAllLatenciesSame(N, 10)
+	setLiarAndVictim(chain, "N0", "N1", 70)
+	setLiarAndVictim(chain, "N0", "N2", 200)
+	setLiarAndVictim(chain, "N0", "N3", 2000)
+	setLiarAndVictim(chain, "N0", "N4", 20000)
+	setLiarAndVictim(chain, "N0", "N5", 200000)
+	setLiarAndVictim(chain, "N0", "N6", 2000000)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	//a liar striked at every run loses more and more trust
+	previous := 1.0
+	for run := 0; run < 3; run++ {
+		require.NoError(t, reputation.Update(chain, start.Add(time.Duration(run)*time.Minute)))
+		trust := reputation.Trust("N0")
+		require.True(t, trust < previous)
+		previous = trust
+	}
+
+	//its victims get strikes too, but fewer
+	require.True(t, reputation.Trust("N1") < 1)
+	require.True(t, reputation.Trust("N0") < reputation.Trust("N1"))
+
+	ids := make([]*NodeID, N)
+	for i, key := range nodes {
+		ids[i] = &NodeID{PublicKey: key}
+	}
+	mostTrusted := reputation.MostTrusted(ids, 2)
+	require.Equal(t, 2, len(mostTrusted))
+	require.NotEqual(t, "N0", string(mostTrusted[0].PublicKey))
+	require.Equal(t, "N0", string(reputation.MostTrusted(ids, N)[N-1].PublicKey))
+
+	//the penalty is halved after a half-life
+	penalty := reputation.Penalties["N0"]
+	later := reputation.Updated.Add(params.HalfLife)
+	require.InDelta(t, 1/(1+penalty/2), reputation.TrustAt("N0", later), 1e-9)
+
+	//once the node stops lying it recovers, and is eventually forgotten
+	consistent, _ := chainWithAllLatenciesSame(N, 10)
+	require.NoError(t, reputation.Update(consistent, later))
+	require.InDelta(t, 1/(1+penalty/2), reputation.Trust("N0"), 1e-9)
+
+	require.NoError(t, reputation.Update(consistent, later.Add(20*params.HalfLife)))
+	require.Equal(t, 1.0, reputation.Trust("N0"))
+	require.Empty(t, reputation.Penalties)
+}
+
+func TestApproximateOverChainWithReputation(t *testing.T) {
+	N := 4
+
+	chain, _ := chainWithAllLatenciesSame(N, 10)
+
+	//B and C do not know each other, so that the distance is estimated through the other nodes
+	delete(chain.Blocks[1].Latencies, "N2")
+	delete(chain.Blocks[2].Latencies, "N1")
+	setLiarAndVictim(chain, "N0", "N1", 30)
+	setLiarAndVictim(chain, "N0", "N2", 40)
+
+	B := &Node{ID: chain.Blocks[1].ID}
+	C := &Node{ID: chain.Blocks[2].ID}
+
+	estimation0, _, err := chain.Blocks[0].ApproximateDistance(chain.Blocks[1], chain.Blocks[2], delta)
+	require.NoError(t, err)
+	estimation3, _, err := chain.Blocks[3].ApproximateDistance(chain.Blocks[1], chain.Blocks[2], delta)
+	require.NoError(t, err)
+
+	//with every node trusted, the estimations weigh the same
+	reputation := NewReputation(DefaultReputationParams())
+	average, err := chain.ApproximateOverChain(B, C)
+	require.NoError(t, err)
+	require.Equal(t, (estimation0+estimation3)/2, average)
+
+	weighted, err := chain.ApproximateOverChainWithReputation(B, C, reputation)
+	require.NoError(t, err)
+	require.Equal(t, average, weighted)
+
+	//the estimation of a distrusted node weighs less
+	reputation.Penalties["N0"] = 3
+	weighted, err = chain.ApproximateOverChainWithReputation(B, C, reputation)
+	require.NoError(t, err)
+	require.Equal(t, time.Duration((0.25*float64(estimation0)+float64(estimation3))/1.25), weighted)
+}
+
+func TestReputationFromIndex(t *testing.T) {
+	N := 7
+
+	chain, _ := chainWithAllLatenciesSame(N, 10)
+	setLiarAndVictim(chain, "N0", "N1", 70)
+	setLiarAndVictim(chain, "N0", "N2", 200)
+	setLiarAndVictim(chain, "N0", "N3", 2000)
+
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	//the index gives the same reputation as a run over the whole chain
+	expected := NewReputation(DefaultReputationParams())
+	require.NoError(t, expected.Update(chain, at))
+
+	reputation := NewReputation(DefaultReputationParams())
+	index := NewBlacklistIndex(chain, expected.Params.Tolerance)
+	require.True(t, reputation.UpdateFromIndex(index, at))
+	require.Equal(t, expected.Penalties, reputation.Penalties)
+	require.Equal(t, at, reputation.Updated)
+
+	//the strikes of the index are only recorded once per period
+	require.False(t, reputation.UpdateFromIndex(index, at.Add(reputation.Params.Period/2)))
+	require.Equal(t, expected.Penalties, reputation.Penalties)
+	require.Equal(t, at, reputation.Updated)
+
+	//new blocks measure their latencies to the most trusted nodes
+	require.Equal(t, "N0", string(selectPeers(chain, nil)[0].PublicKey))
+	peers := selectPeers(chain, reputation)
+	require.Equal(t, nbLatencies, len(peers))
+	for _, peer := range peers {
+		require.NotEqual(t, "N0", string(peer.PublicKey))
+	}
+}
+
+//copyBlocks copies blocks so that their latencies can be modified
+func copyBlocks(blocks []*Block) []*Block {
+	copies := make([]*Block, len(blocks))
+	for i, block := range blocks {
+		copies[i] = copyBlock(block)
+	}
+	return copies
+}
+
+func TestReputationRecoversFromIndex(t *testing.T) {
+	N := 7
+
+	chain, _ := chainWithAllLatenciesSame(N, 10)
+	consistent := copyBlocks(chain.Blocks[:4])
+	setLiarAndVictim(chain, "N0", "N1", 70)
+	setLiarAndVictim(chain, "N0", "N2", 200)
+	setLiarAndVictim(chain, "N0", "N3", 2000)
+
+	reputation := NewReputation(DefaultReputationParams())
+	index := NewBlacklistIndex(chain, reputation.Params.Tolerance)
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.True(t, reputation.UpdateFromIndex(index, at))
+	lied := reputation.Trust("N0")
+	require.True(t, lied < 1)
+
+	//once N0 and its victims send consistent latencies again, N0 keeps recovering as blocks arrive
+	for _, block := range consistent {
+		index.Append(block)
+	}
+	for i := 1; i <= 20; i++ {
+		reputation.UpdateFromIndex(index, at.Add(time.Duration(i)*reputation.Params.Period))
+		require.True(t, reputation.Trust("N0") > lied)
+		lied = reputation.Trust("N0")
+	}
+}
@@ -0,0 +1,279 @@
+/*
+snapshot materialises the latest block of every node at a given height of the chain into a checkpoint.
+Once the validators signed a snapshot, the blocks before it can be pruned and new nodes can bootstrap from it
+*/
+
+package latencyprotocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"sort"
+	"time"
+
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+//Snapshot holds the latest block of every node among the blocks below a given height
+type Snapshot struct {
+	//Height is the number of blocks the snapshot covers
+	Height int
+	//LastHash is the hash of the block at Height-1, to which the next block of the chain is linked
+	LastHash []byte
+	//LastCreatedAt is the creation time of the block at Height-1
+	LastCreatedAt time.Time
+	//Blocks are the latest block of every node, ordered by index
+	Blocks []*Block
+	//Signature is the collective signature of the validators on the hash of the snapshot
+	Signature []byte
+}
+
+//Snapshot creates the snapshot of the chain at the given height
+func (chain *Chain) Snapshot(height int) (*Snapshot, error) {
+
+	if height <= 0 || height > chain.Height() {
+		return nil, errors.New("Snapshot height out of the chain")
+	}
+
+	if chain.Checkpoint != nil && height < chain.Checkpoint.Height {
+		return nil, errors.New("Snapshot height before the checkpoint of the chain")
+	}
+
+	latest := make(map[string]*Block)
+	for _, block := range chain.Blocks {
+		if block.Index >= height {
+			continue
+		}
+		key := string(block.ID.PublicKey)
+		if previous, exists := latest[key]; !exists || previous.Index < block.Index {
+			latest[key] = block
+		}
+	}
+
+	blocks := make([]*Block, 0, len(latest))
+	for _, block := range latest {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Index < blocks[j].Index
+	})
+
+	snapshot := &Snapshot{Height: height, Blocks: blocks}
+
+	if chain.Checkpoint != nil && height == chain.Checkpoint.Height {
+		snapshot.LastHash = chain.Checkpoint.LastHash
+		snapshot.LastCreatedAt = chain.Checkpoint.LastCreatedAt
+		return snapshot, nil
+	}
+
+	last := chain.BlockAt(height - 1)
+	lastHash, err := last.Hash()
+	if err != nil {
+		return nil, err
+	}
+	snapshot.LastHash = lastHash
+	snapshot.LastCreatedAt = last.CreatedAt
+
+	return snapshot, nil
+}
+
+//Hash computes the hash of the snapshot, which is signed by the validators. It covers the hashes of its blocks,
+//but not their signatures nor the signature of the snapshot
+func (snapshot *Snapshot) Hash() ([]byte, error) {
+
+	h := sha256.New()
+
+	writeInt(h, int64(snapshot.Height))
+	writeBytes(h, snapshot.LastHash)
+	writeInt(h, snapshot.LastCreatedAt.UnixNano())
+
+	writeInt(h, int64(len(snapshot.Blocks)))
+	for _, block := range snapshot.Blocks {
+		blockHash, err := block.Hash()
+		if err != nil {
+			return nil, err
+		}
+		writeBytes(h, blockHash)
+	}
+
+	return h.Sum(nil), nil
+}
+
+//VerifySnapshot checks that a snapshot matches the blocks of the chain
+func (chain *Chain) VerifySnapshot(snapshot *Snapshot) error {
+
+	expected, err := chain.Snapshot(snapshot.Height)
+	if err != nil {
+		return err
+	}
+
+	expectedHash, err := expected.Hash()
+	if err != nil {
+		return err
+	}
+
+	hash, err := snapshot.Hash()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(expectedHash, hash) {
+		return errors.New("Snapshot does not match the chain")
+	}
+
+	return nil
+}
+
+//ExtendedBy checks that a snapshot, which is not behind the chain, continues it: restarting the chain from the
+//snapshot must not drop or replace any of its blocks. The snapshot has to match the checkpoint of the chain if they
+//have the same height, keep the blocks of the chain it covers, and keep the latest block of every node of the chain
+//unless a later block of the node supersedes it
+func (chain *Chain) ExtendedBy(snapshot *Snapshot) error {
+
+	height := chain.Height()
+	if snapshot.Height < height {
+		return errors.New("Snapshot is behind the chain")
+	}
+
+	if chain.Checkpoint != nil && snapshot.Height == chain.Checkpoint.Height {
+		checkpointHash, err := chain.Checkpoint.Hash()
+		if err != nil {
+			return err
+		}
+		hash, err := snapshot.Hash()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(checkpointHash, hash) {
+			return errors.New("Snapshot does not match the checkpoint of the chain")
+		}
+	}
+
+	if snapshot.Height == height && height > 0 {
+		lastHash, err := chain.LastHash()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(lastHash, snapshot.LastHash) {
+			return errors.New("Snapshot is not linked to the last block of the chain")
+		}
+	}
+
+	//the blocks of the chain, including those of its checkpoint, by index and the latest one of every node
+	byIndex := make(map[int]*Block, len(chain.Blocks))
+	latest := make(map[string]*Block)
+	for _, block := range chain.Blocks {
+		byIndex[block.Index] = block
+		key := string(block.ID.PublicKey)
+		if previous, exists := latest[key]; !exists || previous.Index < block.Index {
+			latest[key] = block
+		}
+	}
+
+	inSnapshot := make(map[string]*Block, len(snapshot.Blocks))
+	for _, block := range snapshot.Blocks {
+		inSnapshot[string(block.ID.PublicKey)] = block
+
+		local, exists := byIndex[block.Index]
+		if !exists {
+			continue
+		}
+		localHash, err := local.Hash()
+		if err != nil {
+			return err
+		}
+		hash, err := block.Hash()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(localHash, hash) {
+			return errors.New("Snapshot replaces a block of the chain")
+		}
+	}
+
+	for key, block := range latest {
+		if superseding, exists := inSnapshot[key]; !exists || superseding.Index < block.Index {
+			return errors.New("Snapshot drops the latest block of a node of the chain")
+		}
+	}
+
+	return nil
+}
+
+//Prune replaces the blocks of the chain below the height of a snapshot by the blocks of the snapshot.
+//The snapshot has to match the chain
+func (chain *Chain) Prune(snapshot *Snapshot) error {
+
+	err := chain.VerifySnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	blocks := make([]*Block, 0, len(snapshot.Blocks))
+	blocks = append(blocks, snapshot.Blocks...)
+	for _, block := range chain.Blocks[chain.nbCheckpointBlocks():] {
+		if block.Index >= snapshot.Height {
+			blocks = append(blocks, block)
+		}
+	}
+
+	chain.Blocks = blocks
+	chain.Checkpoint = snapshot
+
+	return nil
+}
+
+//ChainFromSnapshot creates a chain starting from a snapshot, to which the blocks following the snapshot can be appended
+func ChainFromSnapshot(snapshot *Snapshot, bucketName []byte) *Chain {
+	blocks := make([]*Block, len(snapshot.Blocks))
+	copy(blocks, snapshot.Blocks)
+	return &Chain{Blocks: blocks, BucketName: bucketName, Checkpoint: snapshot}
+}
+
+//encodedSnapshot is the form in which a snapshot is encoded
+type encodedSnapshot struct {
+	Height        int
+	LastHash      []byte
+	LastCreatedAt time.Time
+	Blocks        [][]byte
+	Signature     []byte
+}
+
+//EncodeSnapshot encodes a snapshot with protobuf
+func EncodeSnapshot(snapshot *Snapshot) ([]byte, error) {
+	blocks := make([][]byte, len(snapshot.Blocks))
+	for i, block := range snapshot.Blocks {
+		blockBytes, err := EncodeBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		blocks[i] = blockBytes
+	}
+	return protobuf.Encode(&encodedSnapshot{snapshot.Height, snapshot.LastHash, snapshot.LastCreatedAt, blocks, snapshot.Signature})
+}
+
+//DecodeSnapshot decodes a snapshot encoded with EncodeSnapshot
+func DecodeSnapshot(buf []byte, suite network.Suite) (*Snapshot, error) {
+	encoded := encodedSnapshot{}
+	err := protobuf.Decode(buf, &encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		Height:        encoded.Height,
+		LastHash:      encoded.LastHash,
+		LastCreatedAt: encoded.LastCreatedAt,
+		Blocks:        make([]*Block, len(encoded.Blocks)),
+		Signature:     encoded.Signature,
+	}
+	for i, blockBytes := range encoded.Blocks {
+		snapshot.Blocks[i], err = DecodeBlock(blockBytes, suite)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,172 @@
+package latencyprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+//refreshedChain creates a chain of three nodes in which the first two nodes refreshed their block
+func refreshedChain(t *testing.T) *Chain {
+	chain := linkedChain(t, 3)
+	for i := 0; i < 2; i++ {
+		block := &Block{ID: &NodeID{PublicKey: sigAlg.PublicKey(numbersToNodes(i))}, Latencies: make(map[string]ConfirmedLatency)}
+		require.NoError(t, chain.Link(block))
+		require.NoError(t, chain.Append(block))
+	}
+	return chain
+}
+
+func TestSnapshot(t *testing.T) {
+
+	chain := refreshedChain(t)
+
+	//only the latest block of every node is kept
+	snapshot, err := chain.Snapshot(5)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(snapshot.Blocks))
+	for i, index := range []int{2, 3, 4} {
+		require.Equal(t, index, snapshot.Blocks[i].Index)
+	}
+
+	lastHash, err := chain.LastHash()
+	require.NoError(t, err)
+	require.Equal(t, lastHash, snapshot.LastHash)
+	require.NoError(t, chain.VerifySnapshot(snapshot))
+
+	//the blocks after the height are ignored
+	snapshot, err = chain.Snapshot(4)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(snapshot.Blocks))
+	require.Equal(t, 1, snapshot.Blocks[0].Index)
+
+	_, err = chain.Snapshot(0)
+	require.Error(t, err)
+	_, err = chain.Snapshot(6)
+	require.Error(t, err)
+
+	//the encoding keeps the hash
+	snapshot.Signature = []byte("signature")
+	snapshotBytes, err := EncodeSnapshot(snapshot)
+	require.NoError(t, err)
+	decoded, err := DecodeSnapshot(snapshotBytes, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, snapshot.Signature, decoded.Signature)
+
+	hash, err := snapshot.Hash()
+	require.NoError(t, err)
+	decodedHash, err := decoded.Hash()
+	require.NoError(t, err)
+	require.Equal(t, hash, decodedHash)
+
+	//a snapshot which does not match the chain is refused
+	decoded.Blocks = decoded.Blocks[1:]
+	require.Error(t, chain.VerifySnapshot(decoded))
+	require.Error(t, chain.Prune(decoded))
+}
+
+func TestPrune(t *testing.T) {
+
+	chain := refreshedChain(t)
+	lastHash, err := chain.LastHash()
+	require.NoError(t, err)
+
+	snapshot, err := chain.Snapshot(4)
+	require.NoError(t, err)
+	require.NoError(t, chain.Prune(snapshot))
+
+	//the superseded block of the first node is gone
+	require.Equal(t, 4, len(chain.Blocks))
+	require.Equal(t, 5, chain.Height())
+	require.Nil(t, chain.BlockAt(3))
+	require.Equal(t, 4, chain.BlockAt(4).Index)
+
+	prunedHash, err := chain.LastHash()
+	require.NoError(t, err)
+	require.Equal(t, lastHash, prunedHash)
+
+	//the chain keeps growing after the checkpoint
+	block := &Block{ID: &NodeID{PublicKey: sigAlg.PublicKey(numbersToNodes(2))}, Latencies: make(map[string]ConfirmedLatency)}
+	require.NoError(t, chain.Link(block))
+	require.Equal(t, 5, block.Index)
+	require.NoError(t, chain.Append(block))
+
+	//a later snapshot covers the blocks of the checkpoint
+	snapshot, err = chain.Snapshot(6)
+	require.NoError(t, err)
+	require.NoError(t, chain.Prune(snapshot))
+	require.Equal(t, 3, len(chain.Blocks))
+	require.Equal(t, 6, chain.Height())
+
+	//a chain restarted from the snapshot links the next blocks like the pruned chain
+	restarted := ChainFromSnapshot(snapshot, chain.BucketName)
+	require.Equal(t, 6, restarted.Height())
+	next := &Block{ID: &NodeID{PublicKey: sigAlg.PublicKey(numbersToNodes(0))}, Latencies: make(map[string]ConfirmedLatency)}
+	require.NoError(t, restarted.Link(next))
+	require.NoError(t, chain.Append(next))
+}
+
+func TestValidatePrunedChain(t *testing.T) {
+
+	chain := handshakeChain(t)
+	params := DefaultChainValidationParams()
+	params.Tolerance = params.MaxLatency
+
+	snapshot, err := chain.Snapshot(1)
+	require.NoError(t, err)
+	require.NoError(t, chain.Prune(snapshot))
+
+	report := chain.Validate(nil, params)
+	require.True(t, report.Valid(), report.String())
+
+	//the encoding keeps the checkpoint
+	chainBytes, err := EncodeChain(chain)
+	require.NoError(t, err)
+	decoded, err := DecodeChain(chainBytes, tSuite)
+	require.NoError(t, err)
+	require.NotNil(t, decoded.Checkpoint)
+	require.Equal(t, chain.Height(), decoded.Height())
+	require.True(t, decoded.Validate(nil, params).Valid())
+
+	//the first block after the checkpoint has to be linked to it
+	chain.Blocks[1].CreatedAt = snapshot.LastCreatedAt.Add(-time.Second)
+	requireViolations(t, chain.Validate(nil, params), BrokenLink)
+}
+
+func TestSnapshotExtendsChain(t *testing.T) {
+
+	chain := refreshedChain(t)
+	snapshot, err := chain.Snapshot(5)
+	require.NoError(t, err)
+
+	//a chain which fell behind is extended by the snapshot, whose blocks supersede the old blocks of the first nodes
+	prefix := &Chain{Blocks: chain.Blocks[:3], BucketName: chain.BucketName}
+	require.NoError(t, prefix.ExtendedBy(snapshot))
+	require.NoError(t, chain.ExtendedBy(snapshot))
+	require.NoError(t, (&Chain{Blocks: []*Block{}, BucketName: chain.BucketName}).ExtendedBy(snapshot))
+
+	//a snapshot behind the chain is refused
+	behind, err := chain.Snapshot(4)
+	require.NoError(t, err)
+	require.Error(t, chain.ExtendedBy(behind))
+
+	//a snapshot replacing a block of the chain is refused
+	replaced := *snapshot
+	replaced.Blocks = append([]*Block{copyBlock(snapshot.Blocks[0])}, snapshot.Blocks[1:]...)
+	replaced.Blocks[0].Index = snapshot.Blocks[0].Index
+	replaced.Blocks[0].CreatedAt = snapshot.Blocks[0].CreatedAt
+	modifyLatencies(replaced.Blocks[0], func(l *ConfirmedLatency) { l.Latency += time.Second })
+	require.Error(t, prefix.ExtendedBy(&replaced))
+
+	//a snapshot dropping the latest block of a node is refused
+	dropped := *snapshot
+	dropped.Blocks = snapshot.Blocks[1:]
+	require.Error(t, prefix.ExtendedBy(&dropped))
+
+	//a snapshot at the height of the checkpoint has to be the checkpoint
+	restarted := ChainFromSnapshot(snapshot, chain.BucketName)
+	require.NoError(t, restarted.ExtendedBy(snapshot))
+	require.Error(t, restarted.ExtendedBy(&replaced))
+}
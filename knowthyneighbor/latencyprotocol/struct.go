@@ -22,9 +22,10 @@ type NodeID struct {
 	PublicKey sigAlg.PublicKey
 }
 
-//LatencyWrapper wraps a latency because protobuf needs a struct
+//LatencyWrapper wraps a latency and its spread, which are signed together by the node measuring the latency
 type LatencyWrapper struct {
 	Latency time.Duration
+	Spread  time.Duration
 }
 
 //ConfirmedLatency is a struct that is stored in the block to represent latencies
@@ -33,12 +34,34 @@ type ConfirmedLatency struct {
 	SignedLatency      []byte
 	Timestamp          time.Time
 	SignedConfirmation []byte
+	//Spread is the uncertainty of the latency, such as the deviation of the pings it was measured with, zero if unknown.
+	//It is signed along with the latency, so that the other node confirms it too
+	Spread time.Duration
 }
 
 // Block represents a block with unique identification and a list of latencies of the following form: sigB[tsB, sigA[latABA]]
+// Blocks are linked together by the hash of the previous block in the chain
 type Block struct {
 	ID        *NodeID
 	Latencies map[string]ConfirmedLatency
+	//Index is the height of the block in the chain, starting at 0
+	Index int
+	//PreviousHash is the canonical hash of the previous block, empty for the first block
+	PreviousHash []byte
+	//CreatedAt is the time at which the block was linked to the chain
+	CreatedAt time.Time
+	//Signature is the collective signature of the validators on the hash of the header of the block
+	Signature []byte
+	//Kind tells whether the block records latencies or a change of membership of its node
+	Kind BlockKind
+	//NewKey is the key replacing the key of the node in a key rotation block
+	NewKey sigAlg.PublicKey
+	//NodeSignature is the signature of the node on its leave or key rotation
+	NodeSignature []byte
+	//NewKeySignature is the signature of the new key on a key rotation
+	NewKeySignature []byte
+	//Admission is the proof given by a node with its first block to be admitted, see AdmissionPolicy
+	Admission []byte
 }
 
 //Node represents a block in process of being constructed (latencies)
@@ -57,6 +80,9 @@ type Node struct {
 type Chain struct {
 	Blocks     []*Block
 	BucketName []byte
+	//Checkpoint is the snapshot the chain was pruned to, nil if the chain is complete.
+	//The blocks of the snapshot are then the first blocks of the chain
+	Checkpoint *Snapshot
 }
 
 //Copy creates a deep copy of a chain
@@ -73,7 +99,16 @@ func (chain *Chain) Copy() *Chain {
 				ServerID:  nil,
 				PublicKey: sigAlg.PublicKey(numbersToNodes(i)),
 			},
-			Latencies: latencies,
+			Latencies:       latencies,
+			Index:           chain.Blocks[i].Index,
+			PreviousHash:    append([]byte{}, chain.Blocks[i].PreviousHash...),
+			CreatedAt:       chain.Blocks[i].CreatedAt,
+			Signature:       append([]byte{}, chain.Blocks[i].Signature...),
+			Kind:            chain.Blocks[i].Kind,
+			NewKey:          append(sigAlg.PublicKey{}, chain.Blocks[i].NewKey...),
+			NodeSignature:   append([]byte{}, chain.Blocks[i].NodeSignature...),
+			NewKeySignature: append([]byte{}, chain.Blocks[i].NewKeySignature...),
+			Admission:       append([]byte{}, chain.Blocks[i].Admission...),
 		}
 
 	}
@@ -82,10 +117,18 @@ func (chain *Chain) Copy() *Chain {
 
 	copy(name, chain.BucketName)
 
-	return &Chain{blocksCopy, name}
+	return &Chain{Blocks: blocksCopy, BucketName: name, Checkpoint: chain.Checkpoint}
 
 }
 
+//Head returns a chain sharing the blocks of this chain: blocks appended to this chain or pruned from it later
+//do not change it, so that it can be read while this chain grows
+func (chain *Chain) Head() *Chain {
+	blocks := make([]*Block, len(chain.Blocks))
+	copy(blocks, chain.Blocks)
+	return &Chain{Blocks: blocks, BucketName: chain.BucketName, Checkpoint: chain.Checkpoint}
+}
+
 //LatencyConstructor represents the values used during a latency calculation protocol
 type LatencyConstructor struct {
 	StartedLocally    bool
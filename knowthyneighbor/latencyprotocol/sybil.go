@@ -0,0 +1,212 @@
+/*
+sybil looks for groups of nodes which are probably run by a single operator. Such identities tend to
+
+	measure a near-zero latency to each other, since they run on the same machine or in the same data centre
+	measure near-identical latencies to every other node
+
+Pairs of nodes showing either pattern are linked, and every connected group of linked nodes is a suspected sybil cluster.
+Suspected sybils are not blacklisted, they are a separate category of the blacklist, marked when the blacklist is
+created with BlacklistParams.Sybils. SybilAdmissionPolicy refuses new nodes which would be suspected sybils
+*/
+
+package latencyprotocol
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//SybilParams holds the thresholds under which nodes are suspected to be sybils
+type SybilParams struct {
+	//MaxColocationLatency is the latency under which two nodes are considered to run on a same machine
+	MaxColocationLatency time.Duration
+	//ProfileTolerance is how much the latencies of two nodes to a same node can differ for their profiles to be identical
+	ProfileTolerance time.Duration
+	//MinCommonNodes is the number of nodes both nodes need a latency to for their profiles to be compared
+	MinCommonNodes int
+}
+
+//DefaultSybilParams returns the thresholds used by default. Nodes on a same machine measure a few tens of
+//microseconds to each other, while distinct machines of a same data centre measure a few hundred: only the former
+//are considered co-located, so that honest nodes hosted in a same data centre are not suspected
+func DefaultSybilParams() SybilParams {
+	return SybilParams{
+		MaxColocationLatency: 100 * time.Microsecond,
+		ProfileTolerance:     time.Millisecond,
+		MinCommonNodes:       3,
+	}
+}
+
+//SybilCluster is a group of nodes suspected to be run by a single operator
+type SybilCluster struct {
+	//Nodes are the public keys of the nodes of the cluster, sorted
+	Nodes []string
+	//Colocated tells whether some nodes of the cluster measured a near-zero latency to each other
+	Colocated bool
+	//IdenticalProfiles tells whether some nodes of the cluster measured near-identical latencies to the other nodes
+	IdenticalProfiles bool
+}
+
+//Contains tells whether a node is part of the cluster
+func (cluster *SybilCluster) Contains(key string) bool {
+	index := sort.SearchStrings(cluster.Nodes, key)
+	return index < len(cluster.Nodes) && cluster.Nodes[index] == key
+}
+
+//colocated tells whether two nodes measured a near-zero latency to each other
+func colocated(A *Block, B *Block, params SybilParams) bool {
+	latency, here := A.getLatency(B)
+	if !here {
+		latency, here = B.getLatency(A)
+	}
+	return here && latency <= params.MaxColocationLatency
+}
+
+//identicalProfiles tells whether two nodes measured near-identical latencies to enough common nodes
+func identicalProfiles(A *Block, B *Block, params SybilParams) bool {
+
+	keyA := string(A.ID.PublicKey)
+	keyB := string(B.ID.PublicKey)
+
+	nbCommon := 0
+	for key, latencyA := range A.Latencies {
+		if key == keyA || key == keyB {
+			continue
+		}
+		latencyB, here := B.Latencies[key]
+		if !here {
+			continue
+		}
+		if !acceptableDifference(latencyA.Latency, latencyB.Latency, params.ProfileTolerance) {
+			return false
+		}
+		nbCommon++
+	}
+
+	return nbCommon >= params.MinCommonNodes
+}
+
+//DetectSybils returns the suspected sybil clusters among the active nodes of the chain, ordered by their first node
+func (chain *Chain) DetectSybils(params SybilParams) []SybilCluster {
+
+	latest := make(map[string]*Block)
+	for _, block := range chain.ActiveBlocks() {
+		latest[string(block.ID.PublicKey)] = block
+	}
+
+	keys := make([]string, 0, len(latest))
+	for key := range latest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	//the clusters are the connected components of the suspicious pairs, merged with a union-find
+	parent := make(map[string]string, len(keys))
+	var find func(key string) string
+	find = func(key string) string {
+		if parent[key] != key {
+			parent[key] = find(parent[key])
+		}
+		return parent[key]
+	}
+	for _, key := range keys {
+		parent[key] = key
+	}
+
+	type link struct {
+		A, B                 string
+		colocated, identical bool
+	}
+	links := make([]link, 0)
+
+	for i, keyA := range keys {
+		for _, keyB := range keys[i+1:] {
+			A, B := latest[keyA], latest[keyB]
+			isColocated := colocated(A, B, params)
+			isIdentical := identicalProfiles(A, B, params)
+			if isColocated || isIdentical {
+				links = append(links, link{keyA, keyB, isColocated, isIdentical})
+				parent[find(keyA)] = find(keyB)
+			}
+		}
+	}
+
+	colocatedRoots := make(map[string]bool)
+	identicalRoots := make(map[string]bool)
+	for _, l := range links {
+		root := find(l.A)
+		colocatedRoots[root] = colocatedRoots[root] || l.colocated
+		identicalRoots[root] = identicalRoots[root] || l.identical
+	}
+
+	members := make(map[string][]string)
+	for _, key := range keys {
+		root := find(key)
+		members[root] = append(members[root], key)
+	}
+
+	clusters := make([]SybilCluster, 0)
+	for root, nodes := range members {
+		if len(nodes) < 2 {
+			continue
+		}
+		clusters = append(clusters, SybilCluster{nodes, colocatedRoots[root], identicalRoots[root]})
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Nodes[0] < clusters[j].Nodes[0]
+	})
+
+	return clusters
+}
+
+//SuspectedSybils returns the blacklist of the chain in which the nodes of the suspected sybil clusters are marked
+func (chain *Chain) SuspectedSybils(params SybilParams) Blacklistset {
+	blacklist := NewBlacklistset()
+	blacklist.AddSybilClusters(chain.DetectSybils(params))
+	return blacklist
+}
+
+//SybilAdmissionPolicy refuses the nodes which would form a suspected sybil cluster with nodes of the chain,
+//and leaves the proofs and the other checks to another policy
+type SybilAdmissionPolicy struct {
+	Policy AdmissionPolicy
+	Chain  *Chain
+	Params SybilParams
+}
+
+//NewSybilAdmissionPolicy creates a policy refusing suspected sybils on top of another policy, which can be nil
+func NewSybilAdmissionPolicy(policy AdmissionPolicy, chain *Chain, params SybilParams) *SybilAdmissionPolicy {
+	return &SybilAdmissionPolicy{policy, chain, params}
+}
+
+//Prove creates the proof asked by the underlying policy
+func (policy *SybilAdmissionPolicy) Prove(block *Block) ([]byte, error) {
+	if policy.Policy == nil {
+		return nil, nil
+	}
+	return policy.Policy.Prove(block)
+}
+
+//Admit checks the block with the underlying policy, then refuses it if its node would be part of a sybil cluster
+func (policy *SybilAdmissionPolicy) Admit(block *Block) error {
+
+	if policy.Policy != nil {
+		err := policy.Policy.Admit(block)
+		if err != nil {
+			return err
+		}
+	}
+
+	candidate := &Chain{Blocks: append(policy.Chain.ActiveBlocks(), block)}
+	key := string(block.ID.PublicKey)
+	for _, cluster := range candidate.DetectSybils(policy.Params) {
+		if cluster.Contains(key) {
+			return errors.New("Node suspected to be part of a sybil cluster of " + strconv.Itoa(len(cluster.Nodes)) + " nodes")
+		}
+	}
+
+	return nil
+}
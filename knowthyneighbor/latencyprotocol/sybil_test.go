@@ -0,0 +1,147 @@
+package latencyprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+//profileBlock creates a block of a node with the given latencies in milliseconds to the other nodes
+func profileBlock(id *NodeID, latencies map[*NodeID]int) *Block {
+	block := &Block{ID: id, Latencies: make(map[string]ConfirmedLatency)}
+	for other, ms := range latencies {
+		block.Latencies[string(other.PublicKey)] = ConfirmedLatency{Latency: time.Duration(ms) * time.Millisecond}
+	}
+	return block
+}
+
+//sybilChain creates a chain of 4 honest nodes with distinct latencies, and returns their ids
+func sybilChain(t *testing.T) (*Chain, []*NodeID) {
+	chain, _ := chainOfKeys(t, 4)
+	ids := make([]*NodeID, 4)
+	for i := range ids {
+		ids[i] = chain.Blocks[i].ID
+	}
+
+	for i, id := range ids {
+		latencies := make(map[*NodeID]int)
+		for j, other := range ids {
+			if i != j {
+				latencies[other] = 10 * (i + j + 1)
+			}
+		}
+		appendBlock(t, chain, profileBlock(id, latencies))
+	}
+
+	return chain, ids
+}
+
+func newID(t *testing.T) *NodeID {
+	pubKey, _, err := sigAlg.GenerateKey(nil)
+	require.NoError(t, err)
+	return &NodeID{PublicKey: pubKey}
+}
+
+func TestDetectSybils(t *testing.T) {
+
+	chain, ids := sybilChain(t)
+	params := DefaultSybilParams()
+	require.Empty(t, chain.DetectSybils(params))
+
+	//a node co-located with node 0
+	colocatedID := newID(t)
+	appendBlock(t, chain, profileBlock(colocatedID, map[*NodeID]int{ids[0]: 0, ids[1]: 200}))
+
+	//a node with the same latencies as node 3
+	copyID := newID(t)
+	appendBlock(t, chain, profileBlock(copyID, map[*NodeID]int{ids[0]: 40, ids[1]: 50, ids[2]: 60}))
+
+	clusters := chain.DetectSybils(params)
+	require.Equal(t, 2, len(clusters))
+
+	for _, cluster := range clusters {
+		require.Equal(t, 2, len(cluster.Nodes))
+		if cluster.Contains(string(colocatedID.PublicKey)) {
+			require.True(t, cluster.Contains(string(ids[0].PublicKey)))
+			require.True(t, cluster.Colocated)
+			require.False(t, cluster.IdenticalProfiles)
+		} else {
+			require.True(t, cluster.Contains(string(copyID.PublicKey)))
+			require.True(t, cluster.Contains(string(ids[3].PublicKey)))
+			require.False(t, cluster.Colocated)
+			require.True(t, cluster.IdenticalProfiles)
+		}
+	}
+
+	//suspected sybils are a separate category, without strikes
+	blacklist := chain.SuspectedSybils(params)
+	require.True(t, blacklist.IsEmpty())
+	require.True(t, blacklist.IsSuspectedSybil(string(colocatedID.PublicKey)))
+	require.True(t, blacklist.IsSuspectedSybil(string(ids[3].PublicKey)))
+	require.False(t, blacklist.IsSuspectedSybil(string(ids[1].PublicKey)))
+
+	//and the blacklisting marks them when asked to
+	created, err := CreateBlacklistWithParams(chain, BlacklistParams{Sybils: &params})
+	require.NoError(t, err)
+	require.True(t, created.IsSuspectedSybil(string(colocatedID.PublicKey)))
+	require.True(t, created.IsSuspectedSybil(string(copyID.PublicKey)))
+	require.False(t, created.IsSuspectedSybil(string(ids[1].PublicKey)))
+
+	created, err = CreateBlacklistWithParams(chain, BlacklistParams{})
+	require.NoError(t, err)
+	require.Empty(t, created.SuspectedSybils)
+
+	other := NewBlacklistset()
+	require.False(t, blacklist.Equals(&other))
+	other.CombineWith(&blacklist)
+	require.True(t, blacklist.Equals(&other))
+}
+
+func TestSameDataCentreIsNotColocated(t *testing.T) {
+
+	chain, ids := sybilChain(t)
+
+	//a node of the data centre of the first node, with its own profile
+	neighbourID := newID(t)
+	neighbour := profileBlock(neighbourID, map[*NodeID]int{ids[1]: 25, ids[2]: 55, ids[3]: 85})
+	neighbour.Latencies[string(ids[0].PublicKey)] = ConfirmedLatency{Latency: 400 * time.Microsecond}
+	appendBlock(t, chain, neighbour)
+	require.Empty(t, chain.DetectSybils(DefaultSybilParams()))
+
+	//while a node on the same machine is suspected
+	sameHostID := newID(t)
+	sameHost := profileBlock(sameHostID, map[*NodeID]int{ids[1]: 35, ids[2]: 65, ids[3]: 95})
+	sameHost.Latencies[string(ids[0].PublicKey)] = ConfirmedLatency{Latency: 30 * time.Microsecond}
+	appendBlock(t, chain, sameHost)
+
+	clusters := chain.DetectSybils(DefaultSybilParams())
+	require.Equal(t, 1, len(clusters))
+	require.True(t, clusters[0].Colocated)
+	require.True(t, clusters[0].Contains(string(sameHostID.PublicKey)))
+	require.False(t, clusters[0].Contains(string(neighbourID.PublicKey)))
+}
+
+func TestSybilAdmissionPolicy(t *testing.T) {
+
+	chain, ids := sybilChain(t)
+	policy := NewSybilAdmissionPolicy(NewAllowlistPolicy(), chain, DefaultSybilParams())
+
+	copyID := newID(t)
+	copyBlock := profileBlock(copyID, map[*NodeID]int{ids[0]: 40, ids[1]: 50, ids[2]: 60})
+	honestID := newID(t)
+	honestBlock := profileBlock(honestID, map[*NodeID]int{ids[0]: 15, ids[1]: 45, ids[2]: 75})
+
+	//the underlying policy is checked first
+	require.Error(t, policy.Admit(honestBlock))
+
+	policy.Policy.(*AllowlistPolicy).Allow(copyID.PublicKey)
+	policy.Policy.(*AllowlistPolicy).Allow(honestID.PublicKey)
+
+	require.Error(t, policy.Admit(copyBlock))
+	require.NoError(t, policy.Admit(honestBlock))
+
+	//the chain is left untouched
+	require.Empty(t, chain.DetectSybils(DefaultSybilParams()))
+}
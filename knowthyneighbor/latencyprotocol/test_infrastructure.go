@@ -9,13 +9,27 @@ import (
 	"go.dedis.ch/kyber/v3/pairing"
 	"go.dedis.ch/onet/v3"
 
+	"math"
 	"math/rand"
+	"runtime"
 	"strconv"
 	"time"
 
 	sigAlg "golang.org/x/crypto/ed25519"
 )
 
+//createBlacklistOnAllCores creates a blacklist as CreateBlacklistWithTolerance does, splitting the triangles among
+//all the available cores, for the tests creating many large blacklists
+func createBlacklistOnAllCores(chain *Chain, tolerance TriangleTolerance, verbose bool, policy ThresholdPolicy, withSuspect bool) (Blacklistset, error) {
+	return CreateBlacklistWithParams(chain, BlacklistParams{
+		Tolerance:   tolerance,
+		Policy:      policy,
+		WithSuspect: withSuspect,
+		Verbose:     verbose,
+		Workers:     runtime.NumCPU(),
+	})
+}
+
 type sourceType int
 
 const (
@@ -50,7 +64,7 @@ func chain(N int, x int, src sourceType, nbLiars int, nbVictims int) (*Chain, []
 		privateKeys[h] = priv
 	}
 
-	chain := Chain{[]*Block{}, []byte("testBucket")}
+	chain := Chain{Blocks: []*Block{}, BucketName: []byte("testBucket")}
 
 	for i := 0; i < N; i++ {
 		latencies := make(map[string]ConfirmedLatency)
@@ -65,6 +79,7 @@ func chain(N int, x int, src sourceType, nbLiars int, nbVictims int) (*Chain, []
 						nil,
 						time.Now(),
 						nil,
+						0,
 					}
 				case accurate:
 					latencies[string(nodeIDs[j].PublicKey)] =
@@ -73,6 +88,7 @@ func chain(N int, x int, src sourceType, nbLiars int, nbVictims int) (*Chain, []
 							nil,
 							time.Now(),
 							nil,
+							0,
 						}
 				case variant:
 					//adapt to percentage of distance
@@ -81,6 +97,7 @@ func chain(N int, x int, src sourceType, nbLiars int, nbVictims int) (*Chain, []
 						nil,
 						time.Now(),
 						nil,
+						0,
 					}
 				case inaccurate:
 					if i < nbLiars && (N-nbVictims) <= j {
@@ -89,6 +106,7 @@ func chain(N int, x int, src sourceType, nbLiars int, nbVictims int) (*Chain, []
 							nil,
 							time.Now(),
 							nil,
+							0,
 						}
 					} else {
 						if j < nbLiars && (N-nbVictims) <= i {
@@ -97,6 +115,7 @@ func chain(N int, x int, src sourceType, nbLiars int, nbVictims int) (*Chain, []
 								nil,
 								time.Now(),
 								nil,
+								0,
 							}
 						} else {
 							latencies[string(nodeIDs[j].PublicKey)] =
@@ -105,6 +124,7 @@ func chain(N int, x int, src sourceType, nbLiars int, nbVictims int) (*Chain, []
 									nil,
 									time.Now(),
 									nil,
+									0,
 								}
 						}
 					}
@@ -113,7 +133,7 @@ func chain(N int, x int, src sourceType, nbLiars int, nbVictims int) (*Chain, []
 
 			}
 		}
-		chain.Blocks = append(chain.Blocks, &Block{nodeIDs[i], latencies})
+		chain.Blocks = append(chain.Blocks, &Block{ID: nodeIDs[i], Latencies: latencies})
 	}
 
 	return &chain, nodeIDs
@@ -157,7 +177,7 @@ func chainWithCClusters(C int, clusterSizes []int, distance int) (*Chain, []*Cha
 					for n := 0; n < len(nodes); n++ {
 						node := nodes[n]
 						randAddition := rand.Intn(500)
-						newLat := ConfirmedLatency{time.Duration(distance + randAddition), nil, time.Now(), nil}
+						newLat := ConfirmedLatency{time.Duration(distance + randAddition), nil, time.Now(), nil, 0}
 						block.Latencies[node] = newLat
 						clusters[nl].Blocks[n].Latencies[numbersToNodes(masterIndex)] = newLat
 					}
@@ -174,7 +194,7 @@ func chainWithCClusters(C int, clusterSizes []int, distance int) (*Chain, []*Cha
 
 	//2
 
-	masterChain := Chain{masterBlocks, []byte("testBucketName")}
+	masterChain := Chain{Blocks: masterBlocks, BucketName: []byte("testBucketName")}
 
 	return &masterChain, clusters, nodeLists
 
@@ -198,7 +218,7 @@ func chainWithAllLatenciesSame(nbNodes int, latency int) (*Chain, []sigAlg.Publi
 
 		for j := 0; j < nbNodes; j++ {
 			if j != i {
-				latencies[numbersToNodes(j)] = ConfirmedLatency{time.Duration(latency), nil, time.Now(), nil}
+				latencies[numbersToNodes(j)] = ConfirmedLatency{time.Duration(latency), nil, time.Now(), nil, 0}
 			}
 		}
 
@@ -236,7 +256,7 @@ func chainWithOnlyConsistentLatencies(nbNodes int, startIndex int) (*Chain, []st
 		for j := 0; j < nbNodes; j++ {
 			if j > i {
 				lat := rand.Intn(500) + 500
-				latencies[numbersToNodes(j+startIndex)] = ConfirmedLatency{time.Duration(lat), nil, time.Now(), nil}
+				latencies[numbersToNodes(j+startIndex)] = ConfirmedLatency{time.Duration(lat), nil, time.Now(), nil, 0}
 			} else {
 				if j < i {
 					latencies[numbersToNodes(j+startIndex)] = blocks[j].Latencies[numbersToNodes(i)]
@@ -268,8 +288,8 @@ func chainWithOnlyConsistentLatencies(nbNodes int, startIndex int) (*Chain, []st
 }
 
 func setLiarAndVictim(chain *Chain, liar string, victim string, latency time.Duration) {
-	chain.Blocks[nodesToNumbers(liar)].Latencies[victim] = ConfirmedLatency{time.Duration(latency * time.Nanosecond), nil, time.Now(), nil}
-	chain.Blocks[nodesToNumbers(victim)].Latencies[liar] = ConfirmedLatency{time.Duration(latency * time.Nanosecond), nil, time.Now(), nil}
+	chain.Blocks[nodesToNumbers(liar)].Latencies[victim] = ConfirmedLatency{time.Duration(latency * time.Nanosecond), nil, time.Now(), nil, 0}
+	chain.Blocks[nodesToNumbers(victim)].Latencies[liar] = ConfirmedLatency{time.Duration(latency * time.Nanosecond), nil, time.Now(), nil, 0}
 
 }
 
@@ -285,8 +305,8 @@ func setLiesToClusters(liarID int, consistentChain *Chain) *Chain {
 
 			//Normal range within cluster
 			lat := rand.Intn(500) + 500
-			inconsistentChain.Blocks[liarID].Latencies[numbersToNodes(i)] = ConfirmedLatency{time.Duration(lat), nil, time.Now(), nil}
-			block.Latencies[numbersToNodes(liarID)] = ConfirmedLatency{time.Duration(lat), nil, time.Now(), nil}
+			inconsistentChain.Blocks[liarID].Latencies[numbersToNodes(i)] = ConfirmedLatency{time.Duration(lat), nil, time.Now(), nil, 0}
+			block.Latencies[numbersToNodes(liarID)] = ConfirmedLatency{time.Duration(lat), nil, time.Now(), nil, 0}
 		}
 	}
 
@@ -307,11 +327,61 @@ func setMultipleLiesToClusters(nbLiars int, consistentChain *Chain) *Chain {
 
 				//Normal range within cluster
 				lat := rand.Intn(500) + 500
-				inconsistentChain.Blocks[liarID].Latencies[numbersToNodes(i)] = ConfirmedLatency{time.Duration(lat), nil, time.Now(), nil}
-				block.Latencies[numbersToNodes(liarID)] = ConfirmedLatency{time.Duration(lat), nil, time.Now(), nil}
+				inconsistentChain.Blocks[liarID].Latencies[numbersToNodes(i)] = ConfirmedLatency{time.Duration(lat), nil, time.Now(), nil, 0}
+				block.Latencies[numbersToNodes(liarID)] = ConfirmedLatency{time.Duration(lat), nil, time.Now(), nil, 0}
 			}
 		}
 	}
 
 	return inconsistentChain
 }
+
+//chainWithNoisyLatencies creates a chain of honest nodes placed at random in a square of side maxDistance, whose
+//latencies are their distances measured with an error of at most noise, and carry noise as their spread if withSpread
+func chainWithNoisyLatencies(nbNodes int, maxDistance int, noise int, withSpread bool) *Chain {
+	return chainWithNoisyLatenciesFrom(rand.New(rand.NewSource(rand.Int63())), nbNodes, maxDistance, noise, withSpread)
+}
+
+//chainWithNoisyLatenciesFrom creates a chain as chainWithNoisyLatencies does, drawing the positions and errors from rng
+func chainWithNoisyLatenciesFrom(rng *rand.Rand, nbNodes int, maxDistance int, noise int, withSpread bool) *Chain {
+
+	x := make([]float64, nbNodes)
+	y := make([]float64, nbNodes)
+	for i := 0; i < nbNodes; i++ {
+		x[i] = rng.Float64() * float64(maxDistance)
+		y[i] = rng.Float64() * float64(maxDistance)
+	}
+
+	spread := time.Duration(0)
+	if withSpread {
+		spread = time.Duration(noise)
+	}
+
+	blocks := make([]*Block, nbNodes)
+	for i := 0; i < nbNodes; i++ {
+		blocks[i] = &Block{
+			ID: &NodeID{
+				ServerID:  nil,
+				PublicKey: sigAlg.PublicKey(numbersToNodes(i)),
+			},
+			Latencies: make(map[string]ConfirmedLatency),
+		}
+	}
+
+	for i := 0; i < nbNodes; i++ {
+		for j := i + 1; j < nbNodes; j++ {
+			lat := int(math.Hypot(x[i]-x[j], y[i]-y[j])) + rng.Intn(2*noise+1) - noise
+			if lat < 1 {
+				lat = 1
+			}
+			latency := ConfirmedLatency{time.Duration(lat), nil, time.Now(), nil, spread}
+			blocks[i].Latencies[numbersToNodes(j)] = latency
+			blocks[j].Latencies[numbersToNodes(i)] = latency
+		}
+	}
+
+	return &Chain{
+		Blocks:     blocks,
+		BucketName: []byte("TestBucket"),
+	}
+}
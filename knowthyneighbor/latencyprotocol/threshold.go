@@ -0,0 +1,161 @@
+/*
+threshold decides how many strikes a node can get before it is blacklisted. A policy can be
+
+	fixed, the same number of strikes whatever the chain
+	the maximum number of strikes a victim can get, see UpperThreshold, used by default
+	a percentile of the strikes of the nodes
+	a number of standard deviations above the average strikes of the nodes
+
+The strikes given to a policy are normalised to a full mesh, and nodes without strikes count as having none.
+
+Nodes with more strikes than average but under the threshold are suspects, and a SuspectPolicy decides from the nodes
+accusing a suspect whether it is a liar
+*/
+
+package latencyprotocol
+
+import (
+	"math"
+	"sort"
+)
+
+//ThresholdPolicy decides the number of strikes over which a node is blacklisted
+type ThresholdPolicy interface {
+	//Threshold returns the threshold for the strikes given to the N nodes of a chain
+	Threshold(strikes *Blacklistset, N int) int
+}
+
+//FixedThreshold is a threshold which does not depend on the strikes
+type FixedThreshold int
+
+//Threshold returns the fixed threshold
+func (threshold FixedThreshold) Threshold(strikes *Blacklistset, N int) int {
+	return int(threshold)
+}
+
+//UpperThresholdPolicy blacklists the nodes getting more strikes than a victim can get, see UpperThreshold
+type UpperThresholdPolicy struct{}
+
+//Threshold returns UpperThreshold(N)
+func (policy UpperThresholdPolicy) Threshold(strikes *Blacklistset, N int) int {
+	return UpperThreshold(N)
+}
+
+//PercentileThreshold blacklists the nodes getting more strikes than a given fraction of the nodes
+type PercentileThreshold struct {
+	//Percentile is the fraction of the nodes allowed to have as many strikes as the threshold, e.g. 0.9
+	Percentile float64
+}
+
+//Threshold returns the number of strikes of the node at the percentile
+func (policy PercentileThreshold) Threshold(strikes *Blacklistset, N int) int {
+	counts := strikeCounts(strikes, N)
+	if len(counts) == 0 {
+		return 0
+	}
+	sort.Ints(counts)
+
+	index := int(math.Ceil(policy.Percentile*float64(len(counts)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(counts) {
+		index = len(counts) - 1
+	}
+	return counts[index]
+}
+
+//ZScoreThreshold blacklists the nodes whose strikes are more than Z standard deviations above the average
+type ZScoreThreshold struct {
+	Z float64
+}
+
+//Threshold returns the average strikes plus Z standard deviations, rounded down
+func (policy ZScoreThreshold) Threshold(strikes *Blacklistset, N int) int {
+	counts := strikeCounts(strikes, N)
+	if len(counts) == 0 {
+		return 0
+	}
+
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	mean := float64(total) / float64(len(counts))
+
+	variance := 0.0
+	for _, count := range counts {
+		variance += (float64(count) - mean) * (float64(count) - mean)
+	}
+	deviation := math.Sqrt(variance / float64(len(counts)))
+
+	return int(math.Floor(mean + policy.Z*deviation))
+}
+
+//strikeCounts returns the strikes of the N nodes, the nodes missing from the blacklist having none
+func strikeCounts(strikes *Blacklistset, N int) []int {
+	counts := make([]int, 0, N)
+	for _, nbStrikes := range strikes.Strikes {
+		counts = append(counts, nbStrikes)
+	}
+	for len(counts) < N {
+		counts = append(counts, 0)
+	}
+	return counts
+}
+
+//thresholdOf returns the threshold of a policy, the policy being UpperThresholdPolicy if nil
+func thresholdOf(policy ThresholdPolicy, strikes *Blacklistset, N int) int {
+	if policy == nil {
+		policy = UpperThresholdPolicy{}
+	}
+	return policy.Threshold(strikes, N)
+}
+
+//SuspectPolicy decides when a suspect is a liar from the strikes the other nodes give to the triangles of its block
+type SuspectPolicy interface {
+	//AccuserThreshold returns the number of strikes over which a node accuses a suspect, among N nodes
+	AccuserThreshold(N int) int
+	//NonAccusersNeeded returns the number of nodes not accusing a suspect under which the suspect is a liar
+	NonAccusersNeeded(N int) int
+}
+
+//ThirdsSuspectPolicy finds a suspect to be a liar if 2N/3 nodes cannot be found not giving it more than N/3 strikes,
+//the N/3 strikes possibly given by liars. It is used by default
+type ThirdsSuspectPolicy struct{}
+
+//AccuserThreshold returns N/3
+func (policy ThirdsSuspectPolicy) AccuserThreshold(N int) int {
+	return N / 3
+}
+
+//NonAccusersNeeded returns 2N/3
+func (policy ThirdsSuspectPolicy) NonAccusersNeeded(N int) int {
+	return 2 * N / 3
+}
+
+//FractionSuspectPolicy finds a suspect to be a liar with given fractions of the nodes instead of thirds
+type FractionSuspectPolicy struct {
+	//Accusers is the fraction of N over which the strikes of a node make it an accuser, e.g. 0.25
+	Accusers float64
+	//NonAccusers is the fraction of N of non-accusers under which the suspect is a liar, e.g. 0.75
+	NonAccusers float64
+}
+
+//AccuserThreshold returns Accusers*N, rounded down
+func (policy FractionSuspectPolicy) AccuserThreshold(N int) int {
+	return int(policy.Accusers * float64(N))
+}
+
+//NonAccusersNeeded returns NonAccusers*N, rounded down
+func (policy FractionSuspectPolicy) NonAccusersNeeded(N int) int {
+	return int(policy.NonAccusers * float64(N))
+}
+
+//suspectPolicyOf returns the policy, or ThirdsSuspectPolicy if it is nil
+func suspectPolicyOf(policy SuspectPolicy) SuspectPolicy {
+	if policy == nil {
+		return ThirdsSuspectPolicy{}
+	}
+	return policy
+}
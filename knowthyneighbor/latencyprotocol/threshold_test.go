@@ -0,0 +1,104 @@
+package latencyprotocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestThresholdPolicies(t *testing.T) {
+	N := 10
+
+	strikes := NewBlacklistset()
+	for i := 0; i < 8; i++ {
+		strikes.AddWithStrikesStringKey(numbersToNodes(i), 10)
+	}
+	strikes.AddWithStrikesStringKey("N8", 50)
+	//N9 has no strikes
+
+	require.Equal(t, 7, FixedThreshold(7).Threshold(&strikes, N))
+	require.Equal(t, UpperThreshold(N), UpperThresholdPolicy{}.Threshold(&strikes, N))
+	require.Equal(t, UpperThreshold(N), thresholdOf(nil, &strikes, N))
+
+	require.Equal(t, 10, PercentileThreshold{Percentile: 0.9}.Threshold(&strikes, N))
+	require.Equal(t, 50, PercentileThreshold{Percentile: 1}.Threshold(&strikes, N))
+	require.Equal(t, 0, PercentileThreshold{Percentile: 0}.Threshold(&strikes, N))
+
+	//the average is 13 and the standard deviation about 12.7
+	require.Equal(t, 13, ZScoreThreshold{Z: 0}.Threshold(&strikes, N))
+	require.Equal(t, 38, ZScoreThreshold{Z: 2}.Threshold(&strikes, N))
+
+	empty := NewBlacklistset()
+	require.Equal(t, 0, ZScoreThreshold{Z: 2}.Threshold(&empty, 0))
+	require.Equal(t, 0, PercentileThreshold{Percentile: 0.9}.Threshold(&empty, 0))
+}
+
+func TestBlacklistWithThresholdPolicy(t *testing.T) {
+	N := 7
+
+	chain, _ := chainWithAllLatenciesSame(N, 10)
+
+	setLiarAndVictim(chain, "N0", "N1", 70)
+	setLiarAndVictim(chain, "N0", "N2", 200)
+	setLiarAndVictim(chain, "N0", "N3", 2000)
+	setLiarAndVictim(chain, "N0", "N4", 20000)
+	setLiarAndVictim(chain, "N0", "N5", 200000)
+	setLiarAndVictim(chain, "N0", "N6", 2000000)
+
+	strikes, err := CreateBlacklist(chain, 0, false, FixedThreshold(0), false)
+	require.NoError(t, err)
+	require.Equal(t, N, strikes.Size())
+
+	//the liar is far above the other nodes
+	blacklist, err := CreateBlacklist(chain, 0, false, ZScoreThreshold{Z: 2}, false)
+	require.NoError(t, err)
+	require.Equal(t, 1, blacklist.Size())
+	require.True(t, blacklist.ContainsAsString("N0"))
+
+	blacklist, err = CreateBlacklist(chain, 0, false, PercentileThreshold{Percentile: 6.0 / 7}, false)
+	require.NoError(t, err)
+	require.Equal(t, 1, blacklist.Size())
+	require.True(t, blacklist.ContainsAsString("N0"))
+
+	//by default, the threshold is the one of UpperThreshold
+	withDefault, err := CreateBlacklist(chain, 0, false, nil, true)
+	require.NoError(t, err)
+	withUpper, err := CreateBlacklist(chain, 0, false, UpperThresholdPolicy{}, true)
+	require.NoError(t, err)
+	require.True(t, withDefault.Equals(&withUpper))
+}
+
+func TestSuspectPolicies(t *testing.T) {
+	N := 7
+	tolerance := NewTriangleTolerance(0, 0)
+
+	require.Equal(t, 2, ThirdsSuspectPolicy{}.AccuserThreshold(N))
+	require.Equal(t, 4, ThirdsSuspectPolicy{}.NonAccusersNeeded(N))
+	require.Equal(t, 1, FractionSuspectPolicy{0.25, 0.75}.AccuserThreshold(N))
+	require.Equal(t, 5, FractionSuspectPolicy{0.25, 0.75}.NonAccusersNeeded(N))
+
+	chain, _ := chainWithAllLatenciesSame(N, 10)
+	setLiarAndVictim(chain, "N0", "N1", 100)
+	setLiarAndVictim(chain, "N0", "N2", 100)
+	setLiarAndVictim(chain, "N0", "N3", 100)
+
+	//the suspect is a liar with the default policy
+	require.Equal(t, SuspectTally{"N0", 6, 1, 4}, TallyAccusers(chain, "N0", N, BlacklistParams{Tolerance: tolerance}))
+	params := BlacklistParams{Tolerance: tolerance, WithSuspect: true}
+	blacklist, err := CreateBlacklistWithParams(chain, params)
+	require.NoError(t, err)
+	require.True(t, blacklist.ContainsAsString("N0"))
+
+	//but not with a policy needing fewer non-accusers
+	lenient := FractionSuspectPolicy{Accusers: 1.0 / 3, NonAccusers: 0.1}
+	require.False(t, SuspectIsLiar(chain, "N0", N, BlacklistParams{Tolerance: tolerance, Suspects: lenient}))
+	params.Suspects = lenient
+	require.Empty(t, BlacklistEnhancement(chain, N, params))
+	blacklist, err = CreateBlacklistWithParams(chain, params)
+	require.NoError(t, err)
+	require.False(t, blacklist.ContainsAsString("N0"))
+
+	//and nodes over the threshold of the policy are blacklisted without being suspects
+	params = BlacklistParams{Tolerance: tolerance, Policy: FixedThreshold(0), WithSuspect: true}
+	require.Empty(t, BlacklistEnhancement(chain, N, params))
+}
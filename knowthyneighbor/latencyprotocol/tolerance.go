@@ -0,0 +1,57 @@
+/*
+tolerance decides how far a triangle of latencies can break the triangle inequality before it is considered a lie.
+Honest measures are noisy, so a triangle of nearly aligned nodes can break it by a few nanoseconds without anyone lying
+
+A side of a triangle is accepted if it is not longer than the sum of the two other sides by more than
+
+	the absolute tolerance
+	the relative tolerance times the length of the side
+	the spreads of the three latencies, when they are known. Spreads are signed by both nodes of a latency and bounded
+	by the validators, so that a node cannot widen the tolerance of its own triangles
+*/
+
+package latencyprotocol
+
+import (
+	"time"
+)
+
+//TriangleTolerance is the margin by which the triangles of latencies can break the triangle inequality
+type TriangleTolerance struct {
+	//Absolute is a fixed margin
+	Absolute time.Duration
+	//Relative is a margin proportional to the longest side, e.g. 0.05 for 5%
+	Relative float64
+}
+
+//NewTriangleTolerance creates a tolerance with an absolute and a relative margin
+func NewTriangleTolerance(absolute time.Duration, relative float64) TriangleTolerance {
+	return TriangleTolerance{absolute, relative}
+}
+
+//Satisfied tells whether three latencies forming a triangle satisfy the triangle inequality within the tolerance
+func (tolerance TriangleTolerance) Satisfied(AB ConfirmedLatency, BC ConfirmedLatency, CA ConfirmedLatency) bool {
+	uncertainty := tolerance.Absolute + AB.Spread + BC.Spread + CA.Spread
+	return tolerance.sideSatisfied(AB.Latency, BC.Latency+CA.Latency, uncertainty) &&
+		tolerance.sideSatisfied(BC.Latency, AB.Latency+CA.Latency, uncertainty) &&
+		tolerance.sideSatisfied(CA.Latency, AB.Latency+BC.Latency, uncertainty)
+}
+
+//triangleViolation is by how much the longest side of a triangle exceeds the sum of the two others,
+//negative or zero if the triangle inequality holds
+func triangleViolation(AB time.Duration, BC time.Duration, CA time.Duration) time.Duration {
+	longest := AB
+	if BC > longest {
+		longest = BC
+	}
+	if CA > longest {
+		longest = CA
+	}
+	return 2*longest - (AB + BC + CA)
+}
+
+//sideSatisfied tells whether a side is not too long compared to the sum of the two other sides
+func (tolerance TriangleTolerance) sideSatisfied(side time.Duration, otherSides time.Duration, uncertainty time.Duration) bool {
+	margin := uncertainty + time.Duration(tolerance.Relative*float64(side))
+	return side <= otherSides+margin
+}
@@ -0,0 +1,65 @@
+package latencyprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTriangleTolerance(t *testing.T) {
+
+	side := func(latency time.Duration, spread time.Duration) ConfirmedLatency {
+		return ConfirmedLatency{Latency: latency, Spread: spread}
+	}
+
+	strict := TriangleTolerance{}
+	require.True(t, strict.Satisfied(side(100, 0), side(50, 0), side(50, 0)))
+	require.False(t, strict.Satisfied(side(101, 0), side(50, 0), side(50, 0)))
+	require.False(t, strict.Satisfied(side(50, 0), side(101, 0), side(50, 0)))
+	require.False(t, strict.Satisfied(side(50, 0), side(50, 0), side(101, 0)))
+
+	absolute := NewTriangleTolerance(2, 0)
+	require.True(t, absolute.Satisfied(side(102, 0), side(50, 0), side(50, 0)))
+	require.False(t, absolute.Satisfied(side(103, 0), side(50, 0), side(50, 0)))
+
+	relative := NewTriangleTolerance(0, 0.1)
+	require.True(t, relative.Satisfied(side(110, 0), side(50, 0), side(50, 0)))
+	require.False(t, relative.Satisfied(side(120, 0), side(50, 0), side(50, 0)))
+
+	//the spreads of the three sides add up
+	require.True(t, strict.Satisfied(side(106, 2), side(50, 2), side(50, 2)))
+	require.False(t, strict.Satisfied(side(107, 2), side(50, 2), side(50, 2)))
+	require.True(t, absolute.Satisfied(side(108, 2), side(50, 2), side(50, 2)))
+}
+
+func TestBlacklistHonoursDelta(t *testing.T) {
+	N := 7
+
+	chain, _ := chainWithAllLatenciesSame(N, 10)
+	//noise of a nanosecond between N0 and N1
+	setLiarAndVictim(chain, "N0", "N1", 21)
+
+	strikes, err := CreateBlacklist(chain, 0, false, FixedThreshold(0), false)
+	require.NoError(t, err)
+	require.True(t, strikes.ContainsAsString("N0"))
+	require.True(t, strikes.ContainsAsString("N1"))
+
+	strikes, err = CreateBlacklist(chain, 1, false, FixedThreshold(0), false)
+	require.NoError(t, err)
+	require.True(t, strikes.IsEmpty())
+
+	strikes, err = CreateBlacklistWithTolerance(chain, NewTriangleTolerance(0, 0.05), false, FixedThreshold(0), false)
+	require.NoError(t, err)
+	require.True(t, strikes.IsEmpty())
+
+	//the same noise is absorbed when the latencies carry their spread
+	latency := chain.Blocks[0].Latencies["N1"]
+	latency.Spread = 1
+	chain.Blocks[0].Latencies["N1"] = latency
+	chain.Blocks[1].Latencies["N0"] = latency
+
+	strikes, err = CreateBlacklist(chain, 0, false, FixedThreshold(0), false)
+	require.NoError(t, err)
+	require.True(t, strikes.IsEmpty())
+}
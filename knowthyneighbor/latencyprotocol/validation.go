@@ -0,0 +1,314 @@
+/*
+validation audits a whole chain: for every block, it checks the link to the previous block, the collective signature
+of the validators, the signatures of each latency, and that both ends of a latency agree on its value
+*/
+
+package latencyprotocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.dedis.ch/onet/v3"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+//SignatureVerifier checks the collective signature of the roster on the hash of a block header
+type SignatureVerifier func(roster *onet.Roster, msg []byte, sig []byte) error
+
+//ChainValidationParams holds what a chain is checked against
+type ChainValidationParams struct {
+	//MaxLatency is the longest latency accepted, 0 accepts any positive latency
+	MaxLatency time.Duration
+	//MaxSpread is the largest spread of a latency accepted, 0 accepts any spread which is not negative
+	MaxSpread time.Duration
+	//Tolerance is how much the latencies reported by both ends of a pair can differ
+	Tolerance time.Duration
+	//VerifySignature checks the collective signatures of the blocks, if nil they are not checked and the report says so
+	VerifySignature SignatureVerifier
+	//Admission checks the first block of every node, if nil it is not checked
+	Admission AdmissionPolicy
+}
+
+//DefaultChainValidationParams returns the bounds used to audit a chain by default, without checking collective signatures
+func DefaultChainValidationParams() ChainValidationParams {
+	return ChainValidationParams{
+		MaxLatency: DefaultBlockVerificationParams().MaxLatency,
+		MaxSpread:  DefaultBlockVerificationParams().MaxSpread,
+		Tolerance:  5 * time.Millisecond,
+	}
+}
+
+//Violation is a problem found in a block of a chain
+type Violation struct {
+	Block   int
+	Reason  RejectionReason
+	Message string
+}
+
+//String describes the violation
+func (violation Violation) String() string {
+	return fmt.Sprintf("block %d: %s: %s", violation.Block, violation.Reason, violation.Message)
+}
+
+//ValidationReport lists all the violations found in a chain
+type ValidationReport struct {
+	NbBlocks   int
+	Violations []Violation
+	//SignaturesChecked is false when the collective signatures were not verified, as no verifier was given
+	SignaturesChecked bool
+}
+
+//Valid returns true if no violation was found, even if the collective signatures were not checked
+func (report *ValidationReport) Valid() bool {
+	return len(report.Violations) == 0
+}
+
+//String describes the report with one violation per line
+func (report *ValidationReport) String() string {
+	unchecked := ""
+	if !report.SignaturesChecked {
+		unchecked = " (collective signatures not checked)"
+	}
+	if report.Valid() {
+		return fmt.Sprintf("%d blocks, no violation%s", report.NbBlocks, unchecked)
+	}
+	lines := make([]string, 0, len(report.Violations)+1)
+	lines = append(lines, fmt.Sprintf("%d blocks, %d violations%s:", report.NbBlocks, len(report.Violations), unchecked))
+	for _, violation := range report.Violations {
+		lines = append(lines, violation.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (report *ValidationReport) add(block int, reason RejectionReason, format string, args ...interface{}) {
+	report.Violations = append(report.Violations, Violation{block, reason, fmt.Sprintf(format, args...)})
+}
+
+//shortKey returns a readable prefix of a public key stored as a string
+func shortKey(key string) string {
+	encoded := hex.EncodeToString([]byte(key))
+	if len(encoded) > 16 {
+		return encoded[:16]
+	}
+	return encoded
+}
+
+//reportedLatency is the last latency reported by a node for another one
+type reportedLatency struct {
+	latency time.Duration
+	block   int
+}
+
+//Validate checks every block of the chain and returns a report of all violations found
+func (chain *Chain) Validate(roster *onet.Roster, params ChainValidationParams) *ValidationReport {
+
+	report := &ValidationReport{NbBlocks: len(chain.Blocks), SignaturesChecked: params.VerifySignature != nil}
+
+	members := make(map[string]bool)
+	for _, block := range chain.Blocks {
+		if block != nil && block.ID != nil {
+			members[string(block.ID.PublicKey)] = true
+		}
+	}
+
+	//reported[A][B] is the last latency to B reported by A
+	reported := make(map[string]map[string]reportedLatency)
+
+	//the blocks following the checkpoint are at position index-offset of the chain
+	nbCheckpointBlocks := chain.nbCheckpointBlocks()
+	offset := chain.Height() - len(chain.Blocks)
+	if chain.Checkpoint != nil && params.VerifySignature != nil {
+		hash, err := chain.Checkpoint.Hash()
+		if err != nil {
+			report.add(0, MalformedBlock, "checkpoint: %s", err)
+		} else if err := params.VerifySignature(roster, hash, chain.Checkpoint.Signature); err != nil {
+			report.add(0, BadCollectiveSignature, "checkpoint: %s", err)
+		}
+	}
+
+	//joined holds the keys of the nodes whose first block was already checked
+	joined := make(map[string]bool)
+
+	var previous *Block
+	for i, block := range chain.Blocks {
+
+		if block == nil || block.ID == nil {
+			report.add(i, MalformedBlock, "block without identity")
+			previous = nil
+			continue
+		}
+
+		//the first blocks of the nodes of a checkpoint may have been pruned
+		key := string(block.ID.PublicKey)
+		if params.Admission != nil && !joined[key] && i >= nbCheckpointBlocks && !block.IsMembershipChange() {
+			if err := params.Admission.Admit(block); err != nil {
+				report.add(i, AdmissionRefused, "%s", err)
+			}
+		}
+		joined[key] = true
+		if block.Kind == KeyRotationBlock {
+			joined[string(block.NewKey)] = true
+		}
+
+		switch {
+		case i < nbCheckpointBlocks:
+			//the blocks of the checkpoint follow blocks which were pruned
+			if block.Index >= chain.Checkpoint.Height {
+				report.add(i, BrokenLink, "checkpoint block has index %d", block.Index)
+			}
+		case i == nbCheckpointBlocks && chain.Checkpoint != nil:
+			validateCheckpointLink(report, i, block, chain.Checkpoint)
+		default:
+			validateLink(report, i, offset+i, block, previous)
+		}
+		previous = block
+
+		if params.VerifySignature != nil {
+			header, err := block.Header()
+			if err != nil {
+				report.add(i, MalformedBlock, "%s", err)
+			} else if err := params.VerifySignature(roster, header.Hash(), block.Signature); err != nil {
+				report.add(i, BadCollectiveSignature, "%s", err)
+			}
+		}
+
+		if block.IsMembershipChange() {
+			if len(block.Latencies) != 0 {
+				report.add(i, MalformedBlock, "latencies in a %s block", block.Kind)
+			}
+			if err := verifyMembershipSignatures(block); err != nil {
+				report.add(i, BadMembershipSignature, "%s", err)
+			}
+			continue
+		}
+
+		node := string(block.ID.PublicKey)
+		if reported[node] == nil {
+			reported[node] = make(map[string]reportedLatency)
+		}
+
+		for _, entry := range block.SortedLatencies() {
+			counterpart := entry.PublicKey
+			latency := entry.Latency
+
+			if counterpart == node {
+				report.add(i, MalformedBlock, "latency of %s to itself", shortKey(node))
+				continue
+			}
+
+			if !members[counterpart] {
+				report.add(i, UnknownNode, "latency of %s to unknown node %s", shortKey(node), shortKey(counterpart))
+			}
+
+			if latency.Latency <= 0 || (params.MaxLatency > 0 && latency.Latency > params.MaxLatency) {
+				report.add(i, LatencyOutOfBounds, "latency of %s to %s is %s", shortKey(node), shortKey(counterpart), latency.Latency)
+			}
+
+			if latency.Spread < 0 || (params.MaxSpread > 0 && latency.Spread > params.MaxSpread) {
+				report.add(i, LatencyOutOfBounds, "spread of the latency of %s to %s is %s", shortKey(node), shortKey(counterpart), latency.Spread)
+			}
+
+			err := verifyLatencySignatures(block.ID.PublicKey, sigAlg.PublicKey([]byte(counterpart)), &latency)
+			if err != nil {
+				report.add(i, BadLatencySignature, "latency of %s to %s: %s", shortKey(node), shortKey(counterpart), err)
+			}
+
+			reported[node][counterpart] = reportedLatency{latency.Latency, i}
+		}
+	}
+
+	validateSymmetry(report, reported, params.Tolerance)
+
+	sort.SliceStable(report.Violations, func(i, j int) bool {
+		return report.Violations[i].Block < report.Violations[j].Block
+	})
+
+	return report
+}
+
+//validateLink checks that the block at position i of the chain, which should have the given index,
+//follows the previous block of the chain
+func validateLink(report *ValidationReport, i int, index int, block *Block, previous *Block) {
+
+	if block.Index != index {
+		report.add(i, BrokenLink, "block has index %d", block.Index)
+	}
+
+	if index == 0 {
+		if len(block.PreviousHash) != 0 {
+			report.add(i, BrokenLink, "first block has a previous hash")
+		}
+		return
+	}
+
+	if previous == nil {
+		report.add(i, BrokenLink, "previous block is malformed")
+		return
+	}
+
+	previousHash, err := previous.Hash()
+	if err != nil || !bytes.Equal(previousHash, block.PreviousHash) {
+		report.add(i, BrokenLink, "previous hash does not match block %d", index-1)
+	}
+
+	if block.CreatedAt.Before(previous.CreatedAt) {
+		report.add(i, BrokenLink, "block created before block %d", index-1)
+	}
+}
+
+//validateCheckpointLink checks that the block at position i of the chain is the first block following the checkpoint
+func validateCheckpointLink(report *ValidationReport, i int, block *Block, checkpoint *Snapshot) {
+
+	if block.Index != checkpoint.Height {
+		report.add(i, BrokenLink, "block has index %d", block.Index)
+	}
+
+	if !bytes.Equal(checkpoint.LastHash, block.PreviousHash) {
+		report.add(i, BrokenLink, "previous hash does not match the checkpoint")
+	}
+
+	if block.CreatedAt.Before(checkpoint.LastCreatedAt) {
+		report.add(i, BrokenLink, "block created before the checkpoint")
+	}
+}
+
+//validateSymmetry checks that the latencies reported by both ends of each pair agree within the tolerance
+func validateSymmetry(report *ValidationReport, reported map[string]map[string]reportedLatency, tolerance time.Duration) {
+
+	nodes := make([]string, 0, len(reported))
+	for node := range reported {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	for _, A := range nodes {
+		for _, B := range nodes {
+			if A >= B {
+				continue
+			}
+			AB, existsAB := reported[A][B]
+			BA, existsBA := reported[B][A]
+			if !existsAB || !existsBA {
+				continue
+			}
+
+			difference := AB.latency - BA.latency
+			if difference < 0 {
+				difference = -difference
+			}
+			if difference > tolerance {
+				block := AB.block
+				if BA.block > block {
+					block = BA.block
+				}
+				report.add(block, AsymmetricLatency, "%s reports %s to %s which reports %s",
+					shortKey(A), AB.latency, shortKey(B), BA.latency)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package latencyprotocol
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3"
+)
+
+//handshakeChain links the blocks built by a handshake into a chain
+func handshakeChain(t *testing.T) *Chain {
+	block1, block2 := handshakeBlocks(t)
+
+	chain := &Chain{Blocks: []*Block{}, BucketName: []byte("testBucket")}
+	for _, block := range []*Block{block1, block2} {
+		require.NoError(t, chain.Link(block))
+		require.NoError(t, chain.Append(block))
+	}
+	return chain
+}
+
+//requireViolations checks that a report contains exactly the given reasons
+func requireViolations(t *testing.T, report *ValidationReport, reasons ...RejectionReason) {
+	found := make([]RejectionReason, len(report.Violations))
+	for i, violation := range report.Violations {
+		found[i] = violation.Reason
+	}
+	require.ElementsMatch(t, reasons, found, report.String())
+}
+
+func TestValidateChain(t *testing.T) {
+
+	chain := handshakeChain(t)
+	params := DefaultChainValidationParams()
+	params.Tolerance = params.MaxLatency
+
+	report := chain.Validate(nil, params)
+	require.True(t, report.Valid(), report.String())
+	require.Equal(t, 2, report.NbBlocks)
+
+	//without a verifier, the report says that the collective signatures were not checked
+	require.False(t, report.SignaturesChecked)
+	require.Contains(t, report.String(), "not checked")
+
+	//the collective signatures are checked against the roster
+	refuseAll := params
+	refuseAll.VerifySignature = func(roster *onet.Roster, msg []byte, sig []byte) error {
+		return errors.New("refused")
+	}
+	refused := chain.Validate(nil, refuseAll)
+	require.True(t, refused.SignaturesChecked)
+	require.NotContains(t, refused.String(), "not checked")
+	requireViolations(t, refused, BadCollectiveSignature, BadCollectiveSignature)
+
+	//both ends have to agree on the latency
+	strict := params
+	strict.Tolerance = -1
+	requireViolations(t, chain.Validate(nil, strict), AsymmetricLatency)
+
+	//a modified latency breaks its signatures, the link to the next block and the symmetry
+	modified := &Chain{Blocks: []*Block{copyBlock(chain.Blocks[0]), chain.Blocks[1]}, BucketName: chain.BucketName}
+	modified.Blocks[0].Index = chain.Blocks[0].Index
+	modified.Blocks[0].CreatedAt = chain.Blocks[0].CreatedAt
+	modifyLatencies(modified.Blocks[0], func(l *ConfirmedLatency) { l.Latency += time.Second })
+	requireViolations(t, modified.Validate(nil, params), BadLatencySignature, LatencyOutOfBounds, BrokenLink, AsymmetricLatency)
+
+	//blocks out of order are not linked
+	swapped := &Chain{Blocks: []*Block{chain.Blocks[1], chain.Blocks[0]}, BucketName: chain.BucketName}
+	report = swapped.Validate(nil, params)
+	require.False(t, report.Valid())
+	for _, violation := range report.Violations {
+		require.Equal(t, BrokenLink, violation.Reason, report.String())
+	}
+
+	//a latency to a node outside the chain is reported
+	truncated := &Chain{Blocks: []*Block{chain.Blocks[0]}, BucketName: chain.BucketName}
+	requireViolations(t, truncated.Validate(nil, params), UnknownNode)
+}
+
+func TestEncodeChain(t *testing.T) {
+
+	chain := linkedChain(t, 3)
+
+	buf, err := EncodeChain(chain)
+	require.NoError(t, err)
+
+	decoded, err := DecodeChain(buf, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, chain.Height(), decoded.Height())
+	require.Equal(t, chain.BucketName, decoded.BucketName)
+
+	lastHash, err := chain.LastHash()
+	require.NoError(t, err)
+	decodedHash, err := decoded.LastHash()
+	require.NoError(t, err)
+	require.Equal(t, lastHash, decodedHash)
+}
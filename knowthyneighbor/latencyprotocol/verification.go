@@ -0,0 +1,274 @@
+/*
+verification checks the latencies of a block before validators accept to sign it
+
+Each latency stored in a block by node A for node B has the following form:
+	Latency, Spread, sigA[LatencyWrapper{Latency, Spread}], tsB, sigB[SignedForeignLatency{tsB, sigA[LatencyWrapper{Latency, Spread}]}]
+which is exactly what the five-way messaging protocol signs. The protocol measures a single round trip, so its spread is zero
+*/
+
+package latencyprotocol
+
+import (
+	"errors"
+	"time"
+
+	"go.dedis.ch/protobuf"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+//RejectionReason explains why a block was rejected
+type RejectionReason int
+
+const (
+	//UnspecifiedReason is given when a block is rejected for any other reason
+	UnspecifiedReason RejectionReason = iota
+	//MalformedBlock is given when a block cannot be decoded or has no identity
+	MalformedBlock
+	//UnknownNode is given when a latency was measured with a node not part of the chain
+	UnknownNode
+	//LatencyOutOfBounds is given when a latency is too long or not positive
+	LatencyOutOfBounds
+	//StaleTimestamp is given when the timestamp of a latency is too old or in the future
+	StaleTimestamp
+	//BadLatencySignature is given when one of the signatures of a latency is incorrect
+	BadLatencySignature
+	//BlacklistedNode is given when the block or one of its latencies involves a blacklisted node
+	BlacklistedNode
+	//BrokenLink is given when a block does not follow the previous block of the chain
+	BrokenLink
+	//BadCollectiveSignature is given when the collective signature of a block does not verify against the roster
+	BadCollectiveSignature
+	//AsymmetricLatency is given when both ends of a latency disagree on its value
+	AsymmetricLatency
+	//RetiredNode is given when the block or one of its latencies involves a revoked key or a key replaced by a rotation
+	RetiredNode
+	//BadMembershipSignature is given when a leave or key rotation block is not signed by the keys of the node
+	BadMembershipSignature
+	//UnjustifiedRevocation is given when a revocation block evicts a node the validator did not blacklist
+	UnjustifiedRevocation
+	//AdmissionRefused is given when the first block of a node does not satisfy the admission policy
+	AdmissionRefused
+)
+
+var rejectionReasonNames = []string{
+	"unspecified",
+	"malformed block",
+	"unknown node",
+	"latency out of bounds",
+	"stale timestamp",
+	"bad latency signature",
+	"blacklisted node",
+	"broken link",
+	"bad collective signature",
+	"asymmetric latency",
+	"retired node",
+	"bad membership signature",
+	"unjustified revocation",
+	"admission refused",
+}
+
+//String returns a readable name for the reason
+func (reason RejectionReason) String() string {
+	if reason < 0 || int(reason) >= len(rejectionReasonNames) {
+		return rejectionReasonNames[UnspecifiedReason]
+	}
+	return rejectionReasonNames[reason]
+}
+
+//BlockRejection is the error returned when a block is not accepted
+type BlockRejection struct {
+	Reason  RejectionReason
+	Message string
+}
+
+//Error returns the message of the rejection
+func (rejection *BlockRejection) Error() string {
+	return rejection.Message
+}
+
+//ReasonCode returns the reason of the rejection as a number, so that it can be sent to other validators
+func (rejection *BlockRejection) ReasonCode() int {
+	return int(rejection.Reason)
+}
+
+//reject creates the error rejecting a block for a given reason
+func reject(reason RejectionReason, message string) error {
+	return &BlockRejection{reason, message}
+}
+
+//BlockVerificationParams holds the bounds the latencies of a block have to respect to be accepted
+type BlockVerificationParams struct {
+	//MaxLatency is the longest latency accepted
+	MaxLatency time.Duration
+	//MaxSpread is the largest spread of a latency accepted, as spreads widen the tolerance of the triangles
+	MaxSpread time.Duration
+	//MaxAge is how old the timestamp of a latency can be
+	MaxAge time.Duration
+	//MaxClockSkew is how far in the future the timestamp of a latency can be
+	MaxClockSkew time.Duration
+	//Blacklist optionally gives the nodes whose blocks and latencies are refused, and the only nodes which can be
+	//revoked: without it, revocations are refused. Validators maintain it as blocks arrive, see BlacklistIndex
+	Blacklist *Blacklistset
+	//Admission optionally decides which new nodes can join, every node is admitted if it is nil
+	Admission AdmissionPolicy
+	//Tolerance is the margin of the triangles when the blacklist justifying a revocation is computed
+	Tolerance TriangleTolerance
+}
+
+//DefaultBlockVerificationParams returns the bounds used by validators by default
+func DefaultBlockVerificationParams() BlockVerificationParams {
+	return BlockVerificationParams{
+		MaxLatency:   500 * time.Millisecond,
+		MaxSpread:    50 * time.Millisecond,
+		MaxAge:       60 * time.Second,
+		MaxClockSkew: freshnessDelta,
+	}
+}
+
+//VerifyBlock checks that a block follows the last block of the chain and that every latency of the block was measured
+//with an active node of the chain, respects the given bounds and carries both the signature of the block's node
+//and the confirmation of the other node. Blocks changing the membership of a node are checked with verifyMembershipBlock
+func VerifyBlock(block *Block, chain *Chain, params BlockVerificationParams) error {
+
+	if block == nil || block.ID == nil {
+		return reject(MalformedBlock, "Block without identity")
+	}
+
+	if chain == nil {
+		chain = &Chain{Blocks: []*Block{}}
+	}
+
+	err := chain.verifyLink(block)
+	if err != nil {
+		return err
+	}
+
+	if kind, retired := chain.retiredKeys()[string(block.ID.PublicKey)]; retired {
+		return reject(RetiredNode, "Block of a node whose key was retired by a "+kind.String()+" block")
+	}
+
+	if block.IsMembershipChange() {
+		return verifyMembershipBlock(block, chain, params)
+	}
+
+	if params.Blacklist != nil && params.Blacklist.ContainsAsString(string(block.ID.PublicKey)) {
+		return reject(BlacklistedNode, "Block of a blacklisted node")
+	}
+
+	if params.Admission != nil && chain.IsFirstBlock(block) {
+		err := params.Admission.Admit(block)
+		if err != nil {
+			return reject(AdmissionRefused, err.Error())
+		}
+	}
+
+	members := chain.activeKeys()
+
+	now := time.Now()
+
+	for pubKey, latency := range block.Latencies {
+
+		if pubKey == string(block.ID.PublicKey) {
+			return reject(MalformedBlock, "Latency to itself")
+		}
+
+		if !members[pubKey] {
+			return reject(UnknownNode, "Latency to a node not part of the chain")
+		}
+
+		if params.Blacklist != nil && params.Blacklist.ContainsAsString(pubKey) {
+			return reject(BlacklistedNode, "Latency to a blacklisted node")
+		}
+
+		if latency.Latency <= 0 || latency.Latency > params.MaxLatency {
+			return reject(LatencyOutOfBounds, "Latency out of bounds")
+		}
+
+		if latency.Spread < 0 || latency.Spread > params.MaxSpread {
+			return reject(LatencyOutOfBounds, "Spread out of bounds")
+		}
+
+		if now.Sub(latency.Timestamp) > params.MaxAge {
+			return reject(StaleTimestamp, "Timestamp too old")
+		}
+
+		if latency.Timestamp.Sub(now) > params.MaxClockSkew {
+			return reject(StaleTimestamp, "Timestamp in the future")
+		}
+
+		err := verifyLatencySignatures(block.ID.PublicKey, sigAlg.PublicKey([]byte(pubKey)), &latency)
+		if err != nil {
+			return reject(BadLatencySignature, err.Error())
+		}
+	}
+
+	return nil
+}
+
+//verifyMembershipBlock checks that a node leaving or rotating its key signed the block, and that a revoked node
+//is in the blacklist of the parameters
+func verifyMembershipBlock(block *Block, chain *Chain, params BlockVerificationParams) error {
+
+	if block.Kind != LeaveBlock && block.Kind != KeyRotationBlock && block.Kind != RevocationBlock {
+		return reject(MalformedBlock, "Unknown kind of block")
+	}
+
+	if len(block.Latencies) != 0 {
+		return reject(MalformedBlock, "Latencies in a "+block.Kind.String()+" block")
+	}
+
+	if !chain.activeKeys()[string(block.ID.PublicKey)] {
+		return reject(UnknownNode, "Membership change of a node not part of the chain")
+	}
+
+	err := verifyMembershipSignatures(block)
+	if err != nil {
+		return reject(BadMembershipSignature, err.Error())
+	}
+
+	if block.Kind == KeyRotationBlock && chain.knownKeys()[string(block.NewKey)] {
+		return reject(MalformedBlock, "New key already used in the chain")
+	}
+
+	if block.Kind == RevocationBlock {
+		if params.Blacklist == nil {
+			return reject(UnjustifiedRevocation, "No blacklist to check the revocation against")
+		}
+		if !params.Blacklist.ContainsAsString(string(block.ID.PublicKey)) {
+			return reject(UnjustifiedRevocation, "Revocation of a node which is not blacklisted")
+		}
+	}
+
+	return nil
+}
+
+//verifyLatencySignatures checks the signature of the local node on the latency and the confirmation of the foreign node
+func verifyLatencySignatures(localKey sigAlg.PublicKey, foreignKey sigAlg.PublicKey, latency *ConfirmedLatency) error {
+
+	if len(localKey) != sigAlg.PublicKeySize || len(foreignKey) != sigAlg.PublicKeySize {
+		return errors.New("Invalid public key")
+	}
+
+	encodedLatency, err := protobuf.Encode(&LatencyWrapper{latency.Latency, latency.Spread})
+	if err != nil {
+		return err
+	}
+
+	if !sigAlg.Verify(localKey, encodedLatency, latency.SignedLatency) {
+		return errors.New("Incorrect local signature")
+	}
+
+	confirmedContent, err := protobuf.Encode(&SignedForeignLatency{
+		Timestamp:     latency.Timestamp,
+		SignedLatency: latency.SignedLatency,
+	})
+	if err != nil {
+		return err
+	}
+
+	if !sigAlg.Verify(foreignKey, confirmedContent, latency.SignedConfirmation) {
+		return errors.New("Incorrect foreign signature")
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+/*
+verification_test tests the verification of blocks built with the five-way messaging protocol
+*/
+
+package latencyprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/protobuf"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+//handshakeBlocks runs the messaging protocol between two new nodes and returns the block each of them built
+func handshakeBlocks(t *testing.T) (*Block, *Block) {
+	local := onet.NewTCPTest(tSuite)
+	local.Check = onet.CheckNone
+	_, el, _ := local.GenTree(4, false)
+	defer local.CloseAll()
+
+	chain := &Chain{Blocks: make([]*Block, 1), BucketName: []byte("testBucket")}
+
+	newNode1, finish1, wg1, err := NewNode(el.List[0], el.List[1].Address, tSuite, 1)
+	require.NoError(t, err)
+
+	chain.Blocks[0] = &Block{ID: newNode1.ID, Latencies: make(map[string]ConfirmedLatency, 0)}
+
+	newNode2, finish2, wg2, err := NewNode(el.List[2], el.List[3].Address, tSuite, 1)
+	require.NoError(t, err)
+
+	newNode2.AddBlock(chain)
+
+	block1 := <-newNode1.BlockChannel
+	finish1 <- true
+	wg1.Wait()
+
+	block2 := <-newNode2.BlockChannel
+	finish2 <- true
+	wg2.Wait()
+
+	return &block1, &block2
+}
+
+//copyBlock copies a block so that its latencies can be modified
+func copyBlock(block *Block) *Block {
+	latencies := make(map[string]ConfirmedLatency)
+	for k, v := range block.Latencies {
+		latencies[k] = v
+	}
+	return &Block{ID: block.ID, Latencies: latencies}
+}
+
+//modifyLatencies applies a modification to every latency of a block
+func modifyLatencies(block *Block, modification func(latency *ConfirmedLatency)) {
+	for k, v := range block.Latencies {
+		modification(&v)
+		block.Latencies[k] = v
+	}
+}
+
+func TestVerifyBlock(t *testing.T) {
+
+	block1, block2 := handshakeBlocks(t)
+
+	chain := &Chain{Blocks: []*Block{block1, block2}, BucketName: []byte("testBucket")}
+	emptyChain := &Chain{Blocks: []*Block{}, BucketName: []byte("testBucket")}
+
+	params := DefaultBlockVerificationParams()
+
+	tooLong := params
+	tooLong.MaxLatency = time.Nanosecond
+	tooOld := params
+	tooOld.MaxAge = -time.Second
+	inFuture := params
+	inFuture.MaxClockSkew = -time.Minute
+	blacklisted := params
+	blacklist := NewBlacklistset()
+	blacklist.Add(block1.ID.PublicKey)
+	blacklisted.Blacklist = &blacklist
+
+	tests := []struct {
+		name   string
+		chain  *Chain
+		params BlockVerificationParams
+		modify func(latency *ConfirmedLatency)
+		reason RejectionReason
+		valid  bool
+	}{
+		{"valid", chain, params, nil, UnspecifiedReason, true},
+		{"unknown counterpart", emptyChain, params, nil, UnknownNode, false},
+		{"no chain", nil, params, nil, UnknownNode, false},
+		{"modified latency", chain, params, func(l *ConfirmedLatency) { l.Latency++ }, BadLatencySignature, false},
+		{"modified timestamp", chain, params, func(l *ConfirmedLatency) { l.Timestamp = l.Timestamp.Add(-time.Millisecond) }, BadLatencySignature, false},
+		{"missing local signature", chain, params, func(l *ConfirmedLatency) { l.SignedLatency = nil }, BadLatencySignature, false},
+		{"modified spread", chain, params, func(l *ConfirmedLatency) { l.Spread = time.Millisecond }, BadLatencySignature, false},
+		{"modified confirmation", chain, params, func(l *ConfirmedLatency) { l.SignedConfirmation[0] ^= 0xff }, BadLatencySignature, false},
+		{"latency too long", chain, tooLong, nil, LatencyOutOfBounds, false},
+		{"timestamp too old", chain, tooOld, nil, StaleTimestamp, false},
+		{"timestamp in the future", chain, inFuture, nil, StaleTimestamp, false},
+		{"blacklisted node", chain, blacklisted, nil, BlacklistedNode, false},
+	}
+
+	for _, test := range tests {
+		for _, block := range []*Block{block1, block2} {
+			checkedBlock := copyBlock(block)
+			if test.chain != nil {
+				require.NoError(t, test.chain.Link(checkedBlock))
+			}
+			if test.modify != nil {
+				modifyLatencies(checkedBlock, func(l *ConfirmedLatency) {
+					l.SignedConfirmation = append([]byte{}, l.SignedConfirmation...)
+					test.modify(l)
+				})
+			}
+
+			err := VerifyBlock(checkedBlock, test.chain, test.params)
+			if test.valid {
+				require.NoError(t, err, test.name)
+			} else {
+				require.Error(t, err, test.name)
+				rejection, isRejection := err.(*BlockRejection)
+				require.True(t, isRejection, test.name)
+				require.Equal(t, test.reason, rejection.Reason, test.name)
+			}
+		}
+	}
+}
+
+func TestVerifyBlockSwappedKeys(t *testing.T) {
+
+	block1, block2 := handshakeBlocks(t)
+
+	chain := &Chain{Blocks: []*Block{block1, block2}, BucketName: []byte("testBucket")}
+
+	//a node cannot claim the latency measured by the other node
+	stolen := &Block{ID: block1.ID, Latencies: map[string]ConfirmedLatency{string(block2.ID.PublicKey): block2.Latencies[string(block1.ID.PublicKey)]}}
+	require.NoError(t, chain.Link(stolen))
+
+	require.Error(t, VerifyBlock(stolen, chain, DefaultBlockVerificationParams()))
+}
+
+func TestVerifyBlockLink(t *testing.T) {
+
+	chain, _ := chainOfKeys(t, 3)
+	params := DefaultBlockVerificationParams()
+
+	block := &Block{ID: chain.Blocks[0].ID, Latencies: make(map[string]ConfirmedLatency)}
+	requireRejection(t, VerifyBlock(block, chain, params), BrokenLink)
+
+	require.NoError(t, chain.Link(block))
+	require.NoError(t, VerifyBlock(block, chain, params))
+
+	//a block linked to an older end of the chain is refused once another block was appended
+	appendBlock(t, chain, &Block{ID: chain.Blocks[1].ID, Latencies: make(map[string]ConfirmedLatency)})
+	requireRejection(t, VerifyBlock(block, chain, params), BrokenLink)
+
+	require.NoError(t, chain.Link(block))
+	block.PreviousHash = append([]byte{}, block.PreviousHash...)
+	block.PreviousHash[0] ^= 0xff
+	requireRejection(t, VerifyBlock(block, chain, params), BrokenLink)
+}
+
+//confirmedLatency signs a latency and its spread as the messaging protocol does, the local node measuring it
+//and the foreign node confirming it
+func confirmedLatency(t *testing.T, localKey sigAlg.PrivateKey, foreignKey sigAlg.PrivateKey, latency time.Duration, spread time.Duration) ConfirmedLatency {
+	encodedLatency, err := protobuf.Encode(&LatencyWrapper{latency, spread})
+	require.NoError(t, err)
+	signedLatency := sigAlg.Sign(localKey, encodedLatency)
+
+	timestamp := time.Now()
+	confirmedContent, err := protobuf.Encode(&SignedForeignLatency{Timestamp: timestamp, SignedLatency: signedLatency})
+	require.NoError(t, err)
+
+	return ConfirmedLatency{latency, signedLatency, timestamp, sigAlg.Sign(foreignKey, confirmedContent), spread}
+}
+
+func TestVerifyLatencySpread(t *testing.T) {
+
+	chain, keys := chainOfKeys(t, 2)
+	params := DefaultBlockVerificationParams()
+	counterpart := string(chain.Blocks[1].ID.PublicKey)
+
+	//a spread confirmed by both nodes is accepted within the bound
+	block := linkedBlock(t, chain, chain.Blocks[0].ID)
+	block.Latencies[counterpart] = confirmedLatency(t, keys[0], keys[1], 10*time.Millisecond, params.MaxSpread)
+	require.NoError(t, VerifyBlock(block, chain, params))
+
+	//but not beyond it
+	block.Latencies[counterpart] = confirmedLatency(t, keys[0], keys[1], 10*time.Millisecond, params.MaxSpread+1)
+	requireRejection(t, VerifyBlock(block, chain, params), LatencyOutOfBounds)
+
+	//and the node cannot widen it alone
+	latency := confirmedLatency(t, keys[0], keys[1], 10*time.Millisecond, 0)
+	latency.Spread = params.MaxSpread
+	block.Latencies[counterpart] = latency
+	requireRejection(t, VerifyBlock(block, chain, params), BadLatencySignature)
+}
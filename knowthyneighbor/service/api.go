@@ -3,7 +3,9 @@ package service
 import (
 	"errors"
 
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
 	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3/pairing"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
@@ -46,8 +48,8 @@ func (c *Client) SignatureRequest(r *onet.Roster, msg []byte) (*SignatureRespons
 ProposeNewNode creates a new validator with given address and public key
 
 Every time a new node joins the identity chain, i.e., creates a block, it uses the BLSCoSiService to have the block
-signed by a majority, and then distributes it to other nodes. For now, nodes can join without doing any “work”,
-but later we might add a “work” function, either computing a hash preimage like in Bitcoin or smth else.
+signed by a majority, and then distributes it to other nodes. To join, a node has to satisfy the admission policy
+of the validators: by default it computes a hash preimage like in Bitcoin, see latencyprotocol.AdmissionPolicy.
 
 */
 func (c *Client) ProposeNewNode(id *network.ServerIdentity, roster *onet.Roster) error {
@@ -72,3 +74,48 @@ func (c *Client) ProposeNewNode(id *network.ServerIdentity, roster *onet.Roster)
 
 	return nil
 }
+
+//GetLatency asks the first node of the roster for the latest latency measured by the node from with the node to,
+//and checks that it belongs to a block signed by the roster
+func (c *Client) GetLatency(roster *onet.Roster, from []byte, to []byte) (*latencyprotocol.ConfirmedLatency, error) {
+
+	if len(roster.List) == 0 {
+		return nil, errors.New("Got an empty roster-list")
+	}
+
+	reply := &LatencyProofResponse{}
+	err := c.SendProtobuf(roster.List[0], &LatencyProofRequest{from, to}, reply)
+	if err != nil {
+		return nil, err
+	}
+
+	err = reply.Verify(pairing.NewSuiteBn256(), roster, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply.Latency, nil
+}
+
+//ChangeMembership asks the first node of the roster to have a leave, key rotation or revocation block signed
+//by the roster, see latencyprotocol.NewLeaveBlock, NewKeyRotationBlock and NewRevocationBlock. The node signs
+//its leave or key rotation for the end of the chain, the block is refused if other blocks were added first
+func (c *Client) ChangeMembership(roster *onet.Roster, block *latencyprotocol.Block) (*MembershipResponse, error) {
+
+	if len(roster.List) == 0 {
+		return nil, errors.New("Got an empty roster-list")
+	}
+
+	blockBytes, err := latencyprotocol.EncodeBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &MembershipResponse{}
+	err = c.SendProtobuf(roster.List[0], &MembershipRequest{roster, blockBytes}, reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/blscosiprotocol"
+	"go.dedis.ch/kyber/v3/pairing"
+	"go.dedis.ch/onet/v3"
+)
+
+// This file contains the proofs of single latencies, which light clients can check against the signed block header
+// without getting the whole block.
+
+//LatencyProof returns the latest latency measured by a node with another node, with the signed header of its block
+//and the proof that the latency is committed to by the header
+func (s *BLSCoSiService) LatencyProof(request *LatencyProofRequest) (*LatencyProofResponse, error) {
+	s.chainLock.Lock()
+	defer s.chainLock.Unlock()
+
+	for i := len(s.Chain.Blocks) - 1; i >= 0; i-- {
+		block := s.Chain.Blocks[i]
+		if !bytes.Equal(block.ID.PublicKey, request.From) {
+			continue
+		}
+		if _, exists := block.Latencies[string(request.To)]; !exists {
+			continue
+		}
+
+		header, err := block.Header()
+		if err != nil {
+			return nil, err
+		}
+
+		latency, proof, err := block.ProveLatency(string(request.To))
+		if err != nil {
+			return nil, err
+		}
+
+		return &LatencyProofResponse{header, block.Signature, *latency, proof}, nil
+	}
+
+	return nil, errors.New("No latency known between these nodes")
+}
+
+//Verify checks that the latency of the response was measured by from with to, and belongs to a block
+//signed by a quorum of the roster
+func (response *LatencyProofResponse) Verify(suite *pairing.SuiteBn256, roster *onet.Roster, from []byte, to []byte) error {
+
+	if response.Header == nil || response.Header.ID == nil || !bytes.Equal(response.Header.ID.PublicKey, from) {
+		return errors.New("Header is not from the requested node")
+	}
+
+	err := response.Header.VerifyLatency(string(to), response.Latency, response.Proof)
+	if err != nil {
+		return err
+	}
+
+	return blscosiprotocol.BlockSignatureVerifier(suite)(roster, response.Header.Hash(), response.Signature)
+}
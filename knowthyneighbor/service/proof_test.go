@@ -0,0 +1,44 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3"
+)
+
+func TestLatencyProofService(t *testing.T) {
+
+	local := onet.NewTCPTest(tSuite)
+	local.Check = onet.CheckNone
+	hosts, el, _ := local.GenTree(4, false)
+	defer local.CloseAll()
+
+	services := local.GetServices(hosts, serviceID)
+	s := services[0].(*BLSCoSiService)
+	resetChain(s)
+
+	from := emptyBlock(t)
+	to := emptyBlock(t)
+	from.Latencies[string(to.ID.PublicKey)] = latencyprotocol.ConfirmedLatency{Latency: time.Millisecond, Timestamp: time.Now()}
+
+	//the blocks are appended without being signed
+	require.NoError(t, s.Chain.Link(from))
+	require.NoError(t, s.Chain.Append(from))
+	require.NoError(t, s.Chain.Link(to))
+	require.NoError(t, s.Chain.Append(to))
+
+	reply, err := s.LatencyProof(&LatencyProofRequest{from.ID.PublicKey, to.ID.PublicKey})
+	require.NoError(t, err)
+	require.NoError(t, reply.Header.VerifyLatency(string(to.ID.PublicKey), reply.Latency, reply.Proof))
+
+	//the block is not signed by the roster
+	require.Error(t, reply.Verify(tSuite, el, from.ID.PublicKey, to.ID.PublicKey))
+	//and the latency is not the one measured by to
+	require.Error(t, reply.Verify(tSuite, el, to.ID.PublicKey, from.ID.PublicKey))
+
+	_, err = s.LatencyProof(&LatencyProofRequest{to.ID.PublicKey, from.ID.PublicKey})
+	require.Error(t, err)
+}
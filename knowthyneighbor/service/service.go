@@ -2,7 +2,6 @@
 package service
 
 import (
-	"crypto/sha256"
 	"errors"
 	"sync"
 	"time"
@@ -16,7 +15,6 @@ import (
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
 	"go.dedis.ch/protobuf"
-	bbolt "go.etcd.io/bbolt"
 )
 
 // This file contains all the code to run a BLSCoSi service. It is used to reply to
@@ -28,6 +26,16 @@ const ServiceName = "BLSCoSiService"
 
 const blscosiSigProtocolName = "blscosiproto"
 
+//blscosiBlockProtocolName is the protocol used to sign blocks: validators check the latencies of the block before signing
+const blscosiBlockProtocolName = "blscosiblockproto"
+
+//blscosiSnapshotProtocolName is the protocol used to sign snapshots: validators check the snapshot against their chain
+const blscosiSnapshotProtocolName = "blscosisnapshotproto"
+
+const signingTimeout = 10 * time.Second
+
+const treeBranchingFactor = 2
+
 var serviceID onet.ServiceID
 
 // BLSCoSiService is the service that handles collective signing operations
@@ -39,6 +47,32 @@ type BLSCoSiService struct {
 	Suite               *pairing.SuiteBn256
 	Nodes               []*latencyprotocol.Node
 	ShutdownChannels    map[string]chan bool
+	//blockPropagationFunction sends the signed blocks to all validators
+	blockPropagationFunction messaging.PropagationFunc
+	//chainLock orders the blocks: a block is linked, signed and appended before the next one is linked
+	chainLock sync.Mutex
+	storage   ChainStorage
+	//Retention decides when snapshots are taken and whether older blocks are pruned
+	Retention RetentionPolicy
+	//snapshot is the last snapshot signed by the validators
+	snapshot *latencyprotocol.Snapshot
+	//snapshotPropagationFunction sends the signed snapshots to all validators
+	snapshotPropagationFunction messaging.PropagationFunc
+	//Admission decides which new nodes can join the chain, every node can join if it is nil
+	Admission latencyprotocol.AdmissionPolicy
+	//Sybils, if not nil, are the thresholds with which the nodes of the chain are checked for sybil clusters:
+	//new nodes suspected to be sybils are refused on top of Admission, and the blacklist marks the suspected sybils
+	Sybils *latencyprotocol.SybilParams
+	//blacklistIndex maintains the blacklist of the chain as blocks are appended, it is guarded by blacklistLock
+	//and not by chainLock, as the validators check blocks while the root holds chainLock. For the same reason,
+	//the blocks of Chain are only changed with both locks held, so that validators can copy it under blacklistLock
+	blacklistIndex *latencyprotocol.BlacklistIndex
+	blacklistLock  sync.Mutex
+	//reputation accumulates the strikes of the nodes as blocks are appended, to choose the nodes new blocks measure
+	//their latencies to. It is guarded by blacklistLock
+	reputation *latencyprotocol.Reputation
+	//validators is the roster whose collective signatures are trusted, it is guarded by chainLock
+	validators *onet.Roster
 }
 
 func newBLSCoSiService(c *onet.Context) (onet.Service, error) {
@@ -48,6 +82,8 @@ func newBLSCoSiService(c *onet.Context) (onet.Service, error) {
 		Suite:            pairing.NewSuiteBn256(),
 		Nodes:            make([]*latencyprotocol.Node, 0),
 		ShutdownChannels: make(map[string]chan bool),
+		Retention:        DefaultRetentionPolicy(),
+		Admission:        latencyprotocol.NewHashcashPolicy(latencyprotocol.DefaultHashcashDifficulty),
 	}
 
 	err := s.RegisterHandler(s.SignatureRequest)
@@ -68,12 +104,75 @@ func newBLSCoSiService(c *onet.Context) (onet.Service, error) {
 		return nil, err
 	}
 
+	err = s.RegisterHandlers(s.GetBlocks, s.CatchUp, s.LatencyProof, s.GetSnapshot, s.ChangeMembership)
+	if err != nil {
+		log.Error(err, "Couldn't register handler:")
+		return nil, err
+	}
+
+	//blocks are verified against a copy of the chain of this service
+	_, err = s.ProtocolRegister(blscosiBlockProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		chain, params := s.verificationState()
+		return blscosiprotocol.NewLatencyVerificatingProtocol(n, chain, params)
+	})
+	if err != nil {
+		log.Error(err, "Couldn't register block signing protocol:")
+		return nil, err
+	}
+
+	//snapshots are verified against a copy of the chain of this service
+	_, err = s.ProtocolRegister(blscosiSnapshotProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		chain, _ := s.verificationState()
+		return blscosiprotocol.NewSnapshotVerificationProtocol(n, chain)
+	})
+	if err != nil {
+		log.Error(err, "Couldn't register snapshot signing protocol:")
+		return nil, err
+	}
+
 	s.propagationFunction, err = messaging.NewPropagationFunc(c, "propagateBLSCoSiSignature", s.propagateFuncHandler, -1)
 	if err != nil {
 		log.Error(err, "Couldn't create propagation function:")
 		return nil, err
 	}
 
+	s.blockPropagationFunction, err = messaging.NewPropagationFunc(c, "propagateBLSCoSiBlock", s.propagateBlockHandler, -1)
+	if err != nil {
+		log.Error(err, "Couldn't create propagation function:")
+		return nil, err
+	}
+
+	s.snapshotPropagationFunction, err = messaging.NewPropagationFunc(c, "propagateBLSCoSiSnapshot", s.propagateSnapshotHandler, -1)
+	if err != nil {
+		log.Error(err, "Couldn't create propagation function:")
+		return nil, err
+	}
+
+	//rebuild the chain stored before the last restart
+	db, blocksBucket := s.GetAdditionalBucket(s.Chain.BucketName)
+	_, heightBucket := s.GetAdditionalBucket([]byte(string(s.Chain.BucketName) + "Heights"))
+	_, snapshotBucket := s.GetAdditionalBucket([]byte(string(s.Chain.BucketName) + "Snapshots"))
+	s.storage = NewBoltStorage(db, blocksBucket, heightBucket, snapshotBucket, s.Suite)
+
+	s.snapshot, err = loadChain(s.storage, s.Chain)
+	if err != nil {
+		log.Error(err, "Couldn't load stored chain:")
+		return nil, err
+	}
+	s.rebuildBlacklist()
+
+	s.reputation, err = s.storage.LoadReputation()
+	if err != nil {
+		log.Error(err, "Couldn't load reputation:")
+		return nil, err
+	}
+
+	s.validators, err = s.storage.LoadValidators()
+	if err != nil {
+		log.Error(err, "Couldn't load trusted validators:")
+		return nil, err
+	}
+
 	return s, nil
 }
 
@@ -86,11 +185,17 @@ func init() {
 	network.RegisterMessage(&PropagationFunction{})
 	network.RegisterMessages(&CreateBlockRequest{}, &CreateBlockResponse{})
 	network.RegisterMessages(&CreateNodeRequest{}, &CreateNodeResponse{})
+	network.RegisterMessage(&PropagateBlock{})
+	network.RegisterMessages(&GetBlocksRequest{}, &GetBlocksResponse{}, &CatchUpRequest{}, &CatchUpResponse{})
+	network.RegisterMessages(&LatencyProofRequest{}, &LatencyProofResponse{})
+	network.RegisterMessage(&PropagateSnapshot{})
+	network.RegisterMessages(&GetSnapshotRequest{}, &GetSnapshotResponse{})
+	network.RegisterMessages(&MembershipRequest{}, &MembershipResponse{})
 }
 
 // SignatureRequest treats external requests to this service.
 func (s *BLSCoSiService) SignatureRequest(req *SignatureRequest) (*SignatureResponse, error) {
-	sig, prop, err := s.sign(req.Roster, req.Message)
+	sig, prop, _, err := s.sign(req.Roster, req.Message, nil, blscosiSigProtocolName, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -98,7 +203,11 @@ func (s *BLSCoSiService) SignatureRequest(req *SignatureRequest) (*SignatureResp
 
 }
 
-func (s *BLSCoSiService) sign(Roster *onet.Roster, Message []byte) ([]byte, []byte, error) {
+//sign collectively signs a message with the given protocol, the data being sent to the validators to help them verify
+//the message. If threshold is positive, the protocol runs in threshold mode
+//and the signature is followed by the mask of the signers. The refusals of the validators who did not sign are returned
+//with the signature, or in a RefusalError if the message could not be signed because of them
+func (s *BLSCoSiService) sign(Roster *onet.Roster, Message []byte, Data []byte, protocolName string, threshold int) ([]byte, []byte, []blscosiprotocol.Refusal, error) {
 
 	if Roster.ID.IsNil() {
 		Roster.ID = onet.RosterID(uuid.NewV4())
@@ -106,38 +215,51 @@ func (s *BLSCoSiService) sign(Roster *onet.Roster, Message []byte) ([]byte, []by
 
 	_, root := Roster.Search(s.ServerIdentity().ID)
 	if root == nil {
-		return nil, nil, errors.New("Couldn't find a serverIdentity in Roster")
+		return nil, nil, nil, errors.New("Couldn't find a serverIdentity in Roster")
 	}
 
-	tree := Roster.GenerateNaryTreeWithRoot(2, root)
-	pi, err := s.CreateProtocol(blscosiSigProtocolName, tree)
+	//place validators according to the latencies known in the chain
+	tree := blscosiprotocol.GenerateLatencyTree(Roster, root, s.Chain, treeBranchingFactor)
+	pi, err := s.CreateProtocol(protocolName, tree)
 	if err != nil {
-		return nil, nil, errors.New("Couldn't make new protocol: " + err.Error())
+		return nil, nil, nil, errors.New("Couldn't make new protocol: " + err.Error())
 	}
 
 	//Set message and start signing
 	protocolInstance := pi.(*blscosiprotocol.SimpleBLSCoSi)
 	protocolInstance.Message = Message
+	protocolInstance.Data = Data
+	protocolInstance.Threshold = threshold
 
 	log.Lvl3("BLSCosi Service starting up root protocol")
 
 	if err = pi.Start(); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	//Get signature
-	sig := <-protocolInstance.FinalSignature
+	var sig []byte
+	select {
+	case sig = <-protocolInstance.FinalSignature:
+	case <-time.After(signingTimeout):
+		return nil, nil, nil, errors.New("Signing timed out")
+	}
+
+	refusals := protocolInstance.Refusals
+	if sig == nil {
+		return nil, nil, refusals, &RefusalError{refusals}
+	}
 
 	// We propagate the signature to all nodes
 	err = s.startPropagation(s.propagationFunction, Roster, &PropagationFunction{sig})
 	if err != nil {
 		log.Error(err, "Couldn't propagate signature:")
-		return nil, nil, err
+		return nil, nil, refusals, err
 	}
 
 	prop := s.propagatedSignature
 
-	return sig, prop, nil
+	return sig, prop, refusals, nil
 
 }
 
@@ -186,35 +308,22 @@ func (s *BLSCoSiService) listenForNewBlocks(node latencyprotocol.Node, stopListe
 		return nil
 	case newBlock := <-node.BlockChannel:
 
-		//do some work
-		work(&node)
-
-		blockBytes, err := protobuf.Encode(newBlock)
+		//the node proves it can join
+		err := s.proveAdmission(&node, &newBlock)
 		if err != nil {
+			log.Warn(err.Error() + " - block will not be added")
 			break
 		}
 
-		sig, _, err := s.sign(Roster, blockBytes)
+		_, refusals, err := s.addBlock(Roster, &newBlock)
 		if err != nil {
+			log.Warn(err.Error() + " - block will not be added")
 			break
 		}
 
-		h := sha256.New()
-		h.Write(sig)
-
-		//key is the hash of the block
-		key := h.Sum([]byte{})
-
-		//Add block to chain
-		db, bucket := s.GetAdditionalBucket([]byte(s.Chain.BucketName))
-
-		db.Update(func(tx *bbolt.Tx) error {
-			tx.Bucket(bucket).Put(key, sig)
-			return nil
-		})
-
-		//chain.Roster.Concat(block.id)
-		s.Chain.Blocks = append(s.Chain.Blocks, &newBlock)
+		for _, refusal := range refusals {
+			log.Lvl2("Block signed despite refusal:", refusal.String())
+		}
 	}
 
 	return nil
@@ -229,45 +338,257 @@ func (s *BLSCoSiService) CreateBlock(request *CreateBlockRequest) (*CreateBlockR
 		return nil, err
 	}
 
-	node.AddBlock(s.Chain)
+	s.blacklistLock.Lock()
+	node.AddBlockWithReputation(s.Chain, s.reputation)
+	s.blacklistLock.Unlock()
 
 	newBlock := <-node.BlockChannel
 
-	//do some work
-	work(&node)
+	//the node proves it can join
+	err = s.proveAdmission(&node, &newBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	blockBytes, refusals, err := s.addBlock(request.Roster, &newBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreateBlockResponse{blockBytes, refusals}, nil
+}
 
-	blockBytes, err := protobuf.Encode(newBlock)
+//ChangeMembership adds a leave, key rotation or revocation block to the chain. The validators sign a revocation
+//only if they blacklisted the node
+func (s *BLSCoSiService) ChangeMembership(request *MembershipRequest) (*MembershipResponse, error) {
+
+	block, err := latencyprotocol.DecodeBlock(request.Block, s.Suite)
 	if err != nil {
 		return nil, err
 	}
 
-	sig, _, err := s.sign(request.Roster, blockBytes)
+	if block.ID == nil || !block.IsMembershipChange() {
+		return nil, errors.New("Not a membership block")
+	}
+
+	blockBytes, refusals, err := s.addBlock(request.Roster, block)
 	if err != nil {
 		return nil, err
 	}
 
-	h := sha256.New()
-	h.Write(sig)
+	return &MembershipResponse{blockBytes, refusals}, nil
+}
 
-	//key is the hash of the block
-	key := h.Sum([]byte{})
+//addBlock links a block to the end of the chain, has it signed by the validators, appends it and propagates it
+//to the other validators, taking a snapshot if the retention policy asks for one. It returns the encoding of the signed block
+func (s *BLSCoSiService) addBlock(Roster *onet.Roster, newBlock *latencyprotocol.Block) ([]byte, []blscosiprotocol.Refusal, error) {
 
-	//Add block to chain
-	db, bucket := s.GetAdditionalBucket([]byte(s.Chain.BucketName))
+	signedBytes, refusals, err := s.signAndAppend(Roster, newBlock)
+	if err != nil {
+		return nil, refusals, err
+	}
 
-	db.Update(func(tx *bbolt.Tx) error {
-		tx.Bucket(bucket).Put(key, sig)
+	//validators which miss the block will get it when catching up
+	err = s.propagateBlock(Roster, signedBytes)
+	if err != nil {
+		log.Error(err, "Couldn't propagate block:")
+	}
+
+	//the block is kept even if the snapshot fails, the next snapshot will cover it
+	if s.Retention.snapshotDue(newBlock.Index + 1) {
+		err = s.createSnapshot(Roster, newBlock.Index+1)
+		if err != nil {
+			log.Error(err, "Couldn't create snapshot:")
+		}
+	}
+
+	return signedBytes, refusals, nil
+}
+
+//signAndAppend links a block to the end of the chain, has it signed by the validators, appends it and stores it
+func (s *BLSCoSiService) signAndAppend(Roster *onet.Roster, newBlock *latencyprotocol.Block) ([]byte, []blscosiprotocol.Refusal, error) {
+
+	s.chainLock.Lock()
+	defer s.chainLock.Unlock()
+
+	err := s.Chain.Link(newBlock)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//the signature covers the header of the block, the validators get the whole block to verify it
+	newBlock.Signature = nil
+	blockBytes, err := latencyprotocol.EncodeBlock(newBlock)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header, err := newBlock.Header()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sig, _, refusals, err := s.sign(Roster, header.Hash(), blockBytes, blscosiBlockProtocolName, blscosiprotocol.DefaultQuorum(len(Roster.List)))
+	if err != nil {
+		return nil, refusals, err
+	}
+
+	//the other validators only accept blocks signed by the trusted validators
+	err = s.verifyCollectiveSignature(header.Hash(), sig)
+	if err != nil {
+		return nil, refusals, err
+	}
+	newBlock.Signature = sig
+
+	err = s.appendBlock(newBlock)
+	if err != nil {
+		return nil, refusals, err
+	}
+
+	err = s.storage.StoreBlock(newBlock)
+	if err != nil {
+		log.Error(err, "Couldn't store block:")
+		return nil, refusals, err
+	}
+
+	signedBytes, err := latencyprotocol.EncodeBlock(newBlock)
+	if err != nil {
+		return nil, refusals, err
+	}
+
+	return signedBytes, refusals, nil
+}
+
+//SetValidators sets and stores the roster whose collective signatures are trusted: blocks and snapshots received
+//from other validators are only accepted if a quorum of this roster signed them
+func (s *BLSCoSiService) SetValidators(roster *onet.Roster) error {
+
+	if roster == nil || len(roster.List) == 0 {
+		return errors.New("Empty roster")
+	}
+
+	s.chainLock.Lock()
+	defer s.chainLock.Unlock()
+
+	err := s.storage.StoreValidators(roster)
+	if err != nil {
+		return err
+	}
+	s.validators = roster
+	return nil
+}
+
+//proveAdmission has a node hosted by the service create the admission proof of its first block, against a copy
+//of the chain so that the chain is not locked while the node works
+func (s *BLSCoSiService) proveAdmission(node *latencyprotocol.Node, block *latencyprotocol.Block) error {
+
+	if s.Admission == nil || block.ID == nil {
 		return nil
-	})
+	}
+
+	chain, params := s.verificationState()
+	return node.ProveAdmission(block, chain, params.Admission)
+}
+
+//admissionPolicy returns the admission policy of the service for the given chain, refusing suspected sybils
+//if the service looks for them
+func (s *BLSCoSiService) admissionPolicy(chain *latencyprotocol.Chain) latencyprotocol.AdmissionPolicy {
+	if s.Sybils == nil {
+		return s.Admission
+	}
+	return latencyprotocol.NewSybilAdmissionPolicy(s.Admission, chain, *s.Sybils)
+}
+
+//blockVerificationParams returns the bounds blocks are checked against, with the admission policy of the service
+//and the current blacklist of the chain
+func (s *BLSCoSiService) blockVerificationParams() latencyprotocol.BlockVerificationParams {
+	_, params := s.verificationState()
+	return params
+}
+
+//verificationState returns a copy of the chain and the params blocks are checked against, taken together under
+//blacklistLock so that they match. Validators use it instead of reading the chain, which can change while they check
+func (s *BLSCoSiService) verificationState() (*latencyprotocol.Chain, latencyprotocol.BlockVerificationParams) {
+	s.blacklistLock.Lock()
+	defer s.blacklistLock.Unlock()
+
+	chain := s.Chain.Head()
+	params := latencyprotocol.DefaultBlockVerificationParams()
+	params.Admission = s.admissionPolicy(chain)
+
+	if s.blacklistIndex != nil {
+		blacklist := s.blacklistIndex.Blacklist(false, latencyprotocol.UpperThresholdPolicy{}, false, nil)
+		if s.Sybils != nil {
+			blacklist.AddSybilClusters(chain.DetectSybils(*s.Sybils))
+		}
+		params.Blacklist = &blacklist
+	}
+	return chain, params
+}
+
+//appendBlock appends a verified block to the chain and to the blacklist index, and records the strikes of the index
+//in the reputation of the nodes once per period of the reputation. It has to be called with the chain locked
+func (s *BLSCoSiService) appendBlock(block *latencyprotocol.Block) error {
+	s.blacklistLock.Lock()
+	defer s.blacklistLock.Unlock()
+
+	err := s.Chain.Append(block)
+	if err != nil {
+		return err
+	}
 
-	//chain.Roster.Concat(block.id)
-	s.Chain.Blocks = append(s.Chain.Blocks, &newBlock)
+	if s.blacklistIndex == nil {
+		s.blacklistIndex = latencyprotocol.NewBlacklistIndex(s.Chain, latencyprotocol.DefaultBlockVerificationParams().Tolerance)
+	} else {
+		s.blacklistIndex.Append(block)
+	}
 
-	return &CreateBlockResponse{blockBytes}, nil
+	if s.reputation == nil {
+		s.reputation = latencyprotocol.NewReputation(latencyprotocol.DefaultReputationParams())
+	}
+	if !s.reputation.UpdateFromIndex(s.blacklistIndex, block.CreatedAt) {
+		return nil
+	}
+	return s.storage.StoreReputation(s.reputation)
 }
 
-func work(node *latencyprotocol.Node) {
+//rebuildBlacklist indexes the whole chain again, after it was replaced. It has to be called with the chain locked
+func (s *BLSCoSiService) rebuildBlacklist() {
+	s.blacklistLock.Lock()
+	defer s.blacklistLock.Unlock()
+	s.blacklistIndex = latencyprotocol.NewBlacklistIndex(s.Chain, latencyprotocol.DefaultBlockVerificationParams().Tolerance)
+}
+
+//replaceChain replaces the blocks of the chain, and indexes them and restarts the reputation from them.
+//It has to be called with the chain locked
+func (s *BLSCoSiService) replaceChain(chain *latencyprotocol.Chain) error {
+	s.blacklistLock.Lock()
+	defer s.blacklistLock.Unlock()
+	*s.Chain = *chain
+	return s.reindex()
+}
 
+//pruneChain prunes the blocks of the chain superseded by a snapshot, and indexes the remaining blocks and restarts
+//the reputation from them. It has to be called with the chain locked
+func (s *BLSCoSiService) pruneChain(snapshot *latencyprotocol.Snapshot) error {
+	s.blacklistLock.Lock()
+	defer s.blacklistLock.Unlock()
+	err := s.Chain.Prune(snapshot)
+	if err != nil {
+		return err
+	}
+	return s.reindex()
+}
+
+//reindex rebuilds the blacklist index and the reputation from the blocks of the chain, so that they do not hold
+//blocks which are not in the chain anymore. It has to be called with the chain locked and blacklistLock held
+func (s *BLSCoSiService) reindex() error {
+	s.blacklistIndex = latencyprotocol.NewBlacklistIndex(s.Chain, latencyprotocol.DefaultBlockVerificationParams().Tolerance)
+	s.reputation = latencyprotocol.NewReputation(latencyprotocol.DefaultReputationParams())
+	if nbBlocks := len(s.Chain.Blocks); nbBlocks > 0 {
+		s.reputation.UpdateFromIndex(s.blacklistIndex, s.Chain.Blocks[nbBlocks-1].CreatedAt)
+	}
+	return s.storage.StoreReputation(s.reputation)
 }
 
 //startPropagation propagates the final signature to all the other nodes
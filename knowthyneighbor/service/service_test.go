@@ -1,12 +1,15 @@
 package service
 
 import (
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/kyber/v3/pairing"
 	"go.dedis.ch/kyber/v3/sign/bls"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
+	"strconv"
 	"testing"
+	"time"
 )
 
 const blocksName = "testBlocks"
@@ -92,6 +95,28 @@ func TestSignatureRequestApi(t *testing.T) {
 	require.Nil(t, err, "Propagation incorrect")
 }
 
+func TestBlockSigningRefusesInvalidBlock(t *testing.T) {
+
+	local := onet.NewTCPTest(tSuite)
+	local.Check = onet.CheckNone
+	hosts, el, _ := local.GenTree(3, false)
+	defer local.CloseAll()
+
+	services := local.GetServices(hosts, serviceID)
+	s := services[0].(*BLSCoSiService)
+
+	//the round ends as soon as the root refuses, not when signing times out
+	start := time.Now()
+	_, _, _, err := s.sign(el, []byte("not a block"), []byte("not a block"), blscosiBlockProtocolName, 0)
+	require.Error(t, err)
+	require.True(t, time.Since(start) < signingTimeout/2)
+
+	//generic messages are still signed
+	sig, _, _, err := s.sign(el, []byte("not a block"), nil, blscosiSigProtocolName, 0)
+	require.NoError(t, err)
+	require.NotEmpty(t, sig)
+}
+
 func TestNewNodeService(t *testing.T) {
 
 	local := onet.NewTCPTest(tSuite)
@@ -103,6 +128,9 @@ func TestNewNodeService(t *testing.T) {
 	defer local.CloseAll()
 
 	services := local.GetServices(hosts, serviceID)
+	for _, service := range services {
+		require.NoError(t, service.(*BLSCoSiService).SetValidators(el))
+	}
 	s := services[0].(*BLSCoSiService)
 
 	roster := &onet.Roster{List: el.List}
@@ -146,3 +174,211 @@ func TestNewNodeService(t *testing.T) {
 	require.NoError(t, err)
 
 }*/
+
+func TestBlacklistInVerificationParams(t *testing.T) {
+
+	s := &BLSCoSiService{}
+	resetChain(s)
+
+	//N0 lies about all its latencies, as in the blacklisting tests of latencyprotocol
+	N := 7
+	lies := []time.Duration{0, 70, 200, 2000, 20000, 200000, 2000000}
+	latency := func(i int, j int) time.Duration {
+		if i == 0 {
+			return lies[j]
+		}
+		if j == 0 {
+			return lies[i]
+		}
+		return 10
+	}
+
+	for i := 0; i < N; i++ {
+		block := &latencyprotocol.Block{
+			ID:        &latencyprotocol.NodeID{PublicKey: []byte("N" + strconv.Itoa(i))},
+			Latencies: make(map[string]latencyprotocol.ConfirmedLatency),
+		}
+		for j := 0; j < N; j++ {
+			if i != j {
+				block.Latencies["N"+strconv.Itoa(j)] = latencyprotocol.ConfirmedLatency{Latency: latency(i, j)}
+			}
+		}
+		require.NoError(t, s.Chain.Link(block))
+		require.NoError(t, s.appendBlock(block))
+	}
+
+	params := s.blockVerificationParams()
+	require.NotNil(t, params.Blacklist)
+	require.Equal(t, 1, params.Blacklist.Size())
+	require.True(t, params.Blacklist.ContainsAsString("N0"))
+
+	//the index follows the chain when it is replaced
+	resetChain(s)
+	params = s.blockVerificationParams()
+	require.True(t, params.Blacklist.IsEmpty())
+}
+
+func TestReputationFollowsBlocks(t *testing.T) {
+
+	s := &BLSCoSiService{}
+	resetChain(s)
+
+	//blocks arrive one period of the reputation apart, so that each of them records a run
+	period := latencyprotocol.DefaultReputationParams().Period
+	start := time.Now()
+
+	//N0 lies about its latencies to half of the other nodes
+	N := 7
+	for i := 0; i < N; i++ {
+		block := &latencyprotocol.Block{
+			ID:        &latencyprotocol.NodeID{PublicKey: []byte("N" + strconv.Itoa(i))},
+			Latencies: make(map[string]latencyprotocol.ConfirmedLatency),
+		}
+		for j := 0; j < N; j++ {
+			latency := time.Duration(10)
+			if (i == 0 && j%2 == 1) || (j == 0 && i%2 == 1) {
+				latency = time.Duration(1000 * (i + j))
+			}
+			if i != j {
+				block.Latencies["N"+strconv.Itoa(j)] = latencyprotocol.ConfirmedLatency{Latency: latency}
+			}
+		}
+		require.NoError(t, s.Chain.Link(block))
+		block.CreatedAt = start.Add(time.Duration(i) * period)
+		require.NoError(t, s.appendBlock(block))
+	}
+
+	require.True(t, s.reputation.Trust("N0") < s.reputation.Trust("N2"))
+	require.True(t, s.reputation.Trust("N0") < s.reputation.Trust("N1"))
+	require.Equal(t, s.Chain.Blocks[N-1].CreatedAt, s.reputation.Updated)
+
+	//the reputation is stored along with the chain
+	stored, err := s.storage.LoadReputation()
+	require.NoError(t, err)
+	require.Equal(t, s.reputation.Penalties, stored.Penalties)
+
+	//and keeps accumulating the strikes, once per period
+	penalty := s.reputation.Penalties["N0"]
+	block := &latencyprotocol.Block{
+		ID:        &latencyprotocol.NodeID{PublicKey: []byte("N1")},
+		Latencies: map[string]latencyprotocol.ConfirmedLatency{"N2": {Latency: 10}},
+	}
+	require.NoError(t, s.Chain.Link(block))
+	require.NoError(t, s.appendBlock(block))
+	require.Equal(t, penalty, s.reputation.Penalties["N0"])
+
+	block = &latencyprotocol.Block{
+		ID:        &latencyprotocol.NodeID{PublicKey: []byte("N2")},
+		Latencies: map[string]latencyprotocol.ConfirmedLatency{"N1": {Latency: 10}},
+	}
+	require.NoError(t, s.Chain.Link(block))
+	block.CreatedAt = start.Add(time.Duration(N) * period)
+	require.NoError(t, s.appendBlock(block))
+	require.True(t, s.reputation.Penalties["N0"] > penalty)
+}
+
+func TestPruningRebuildsIndex(t *testing.T) {
+
+	s := &BLSCoSiService{}
+	resetChain(s)
+
+	//N0 first lies about all its latencies, then every node sends a new block with consistent latencies.
+	//The blocks arrive one period of the reputation apart, so that each of them records a run
+	period := latencyprotocol.DefaultReputationParams().Period
+	start := time.Now()
+	N := 7
+	for round := 0; round < 2; round++ {
+		for i := 0; i < N; i++ {
+			block := &latencyprotocol.Block{
+				ID:        &latencyprotocol.NodeID{PublicKey: []byte("N" + strconv.Itoa(i))},
+				Latencies: make(map[string]latencyprotocol.ConfirmedLatency),
+			}
+			for j := 0; j < N; j++ {
+				latency := time.Duration(10)
+				if round == 0 && (i == 0 || j == 0) {
+					latency = time.Duration(1000 * (i + j))
+				}
+				if i != j {
+					block.Latencies["N"+strconv.Itoa(j)] = latencyprotocol.ConfirmedLatency{Latency: latency}
+				}
+			}
+			require.NoError(t, s.Chain.Link(block))
+			block.CreatedAt = start.Add(time.Duration(round*N+i) * period)
+			require.NoError(t, s.appendBlock(block))
+		}
+	}
+	require.True(t, s.reputation.Trust("N0") < 1)
+
+	snapshot, err := s.Chain.Snapshot(s.Chain.Height())
+	require.NoError(t, err)
+	require.NoError(t, s.pruneChain(snapshot))
+	require.Equal(t, N, len(s.Chain.Blocks))
+
+	//the index and the reputation only know the blocks left in the chain
+	params := s.blockVerificationParams()
+	require.True(t, params.Blacklist.IsEmpty())
+	require.Equal(t, 1.0, s.reputation.Trust("N0"))
+	stored, err := s.storage.LoadReputation()
+	require.NoError(t, err)
+	require.Empty(t, stored.Penalties)
+
+	//and so do they when the chain is replaced by a snapshot
+	require.NoError(t, s.replaceChain(latencyprotocol.ChainFromSnapshot(snapshot, s.Chain.BucketName)))
+	require.True(t, s.blockVerificationParams().Blacklist.IsEmpty())
+	require.Empty(t, s.reputation.Penalties)
+}
+
+func TestSybilsInVerificationParams(t *testing.T) {
+
+	s := &BLSCoSiService{}
+	resetChain(s)
+
+	//four nodes in distinct places, and a fifth one on the machine of the first
+	N := 5
+	for i := 0; i < N; i++ {
+		block := &latencyprotocol.Block{
+			ID:        &latencyprotocol.NodeID{PublicKey: []byte("N" + strconv.Itoa(i))},
+			Latencies: make(map[string]latencyprotocol.ConfirmedLatency),
+		}
+		for j := 0; j < i; j++ {
+			latency := time.Duration(10*(i+j)) * time.Millisecond
+			if i == N-1 && j == 0 {
+				latency = 20 * time.Microsecond
+			}
+			block.Latencies["N"+strconv.Itoa(j)] = latencyprotocol.ConfirmedLatency{Latency: latency}
+		}
+		require.NoError(t, s.Chain.Link(block))
+		require.NoError(t, s.appendBlock(block))
+	}
+
+	//sybils are only looked for when the service is configured to
+	params := s.blockVerificationParams()
+	require.Nil(t, params.Admission)
+	require.Empty(t, params.Blacklist.SuspectedSybils)
+
+	sybilParams := latencyprotocol.DefaultSybilParams()
+	s.Sybils = &sybilParams
+	params = s.blockVerificationParams()
+	require.True(t, params.Blacklist.IsSuspectedSybil("N0"))
+	require.True(t, params.Blacklist.IsSuspectedSybil("N4"))
+	require.False(t, params.Blacklist.IsSuspectedSybil("N1"))
+
+	//and new nodes on the machine of a node are refused
+	sameHost := &latencyprotocol.Block{
+		ID: &latencyprotocol.NodeID{PublicKey: []byte("N5")},
+		Latencies: map[string]latencyprotocol.ConfirmedLatency{
+			"N1": {Latency: 10 * time.Microsecond},
+			"N2": {Latency: 30 * time.Millisecond},
+		},
+	}
+	require.Error(t, params.Admission.Admit(sameHost))
+
+	elsewhere := &latencyprotocol.Block{
+		ID: &latencyprotocol.NodeID{PublicKey: []byte("N6")},
+		Latencies: map[string]latencyprotocol.ConfirmedLatency{
+			"N1": {Latency: 45 * time.Millisecond},
+			"N2": {Latency: 75 * time.Millisecond},
+		},
+	}
+	require.NoError(t, params.Admission.Admit(elsewhere))
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/blscosiprotocol"
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// This file contains the snapshots of the chain: every few blocks, the latest block of every node is collectively
+// signed as a checkpoint, from which new validators can bootstrap and below which the blocks can be pruned.
+
+//RetentionPolicy decides when snapshots are taken and whether the blocks they supersede are deleted
+type RetentionPolicy struct {
+	//SnapshotInterval is the number of blocks between two snapshots, no snapshot is taken if it is not positive
+	SnapshotInterval int
+	//Prune deletes the blocks below a snapshot once it is signed
+	Prune bool
+}
+
+//DefaultRetentionPolicy takes a snapshot every 100 blocks and keeps all blocks
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{SnapshotInterval: 100, Prune: false}
+}
+
+//snapshotDue tells whether a snapshot has to be taken once the chain reaches the given height
+func (policy RetentionPolicy) snapshotDue(height int) bool {
+	return policy.SnapshotInterval > 0 && height > 0 && height%policy.SnapshotInterval == 0
+}
+
+//createSnapshot has the snapshot of the chain at the given height signed by the validators and propagates it
+func (s *BLSCoSiService) createSnapshot(Roster *onet.Roster, height int) error {
+
+	s.chainLock.Lock()
+	snapshot, err := s.Chain.Snapshot(height)
+	s.chainLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	hash, err := snapshot.Hash()
+	if err != nil {
+		return err
+	}
+
+	snapshotBytes, err := latencyprotocol.EncodeSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	sig, _, _, err := s.sign(Roster, hash, snapshotBytes, blscosiSnapshotProtocolName, blscosiprotocol.DefaultQuorum(len(Roster.List)))
+	if err != nil {
+		return err
+	}
+	snapshot.Signature = sig
+
+	signedBytes, err := latencyprotocol.EncodeSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	return s.startPropagation(s.snapshotPropagationFunction, Roster, &PropagateSnapshot{signedBytes})
+}
+
+//propagateSnapshotHandler stores a snapshot signed by the trusted validators, catching up first if blocks are missing
+//before it
+func (s *BLSCoSiService) propagateSnapshotHandler(msg network.Message) error {
+	propagated := msg.(*PropagateSnapshot)
+
+	snapshot, err := latencyprotocol.DecodeSnapshot(propagated.Snapshot, s.Suite)
+	if err != nil {
+		log.Error(err, "Couldn't decode propagated snapshot:")
+		return err
+	}
+
+	s.chainLock.Lock()
+	height := s.Chain.Height()
+	validators := s.validators
+	s.chainLock.Unlock()
+
+	if snapshot.Height > height && validators != nil {
+		_, err = s.catchUp(validators)
+		if err != nil {
+			log.Warn(s.ServerIdentity(), "couldn't catch up:", err)
+		}
+	}
+
+	s.chainLock.Lock()
+	defer s.chainLock.Unlock()
+
+	err = s.applySnapshot(snapshot)
+	if err != nil {
+		log.Error(err, "Couldn't apply propagated snapshot:")
+	}
+	return err
+}
+
+//verifySnapshotSignature checks that a snapshot was signed by a quorum of the trusted validators.
+//It has to be called with the chain locked
+func (s *BLSCoSiService) verifySnapshotSignature(snapshot *latencyprotocol.Snapshot) error {
+
+	hash, err := snapshot.Hash()
+	if err != nil {
+		return err
+	}
+
+	return s.verifyCollectiveSignature(hash, snapshot.Signature)
+}
+
+//applySnapshot verifies a signed snapshot against the chain, stores it and prunes the blocks it supersedes
+//if the retention policy asks for it. It has to be called with the chain locked
+func (s *BLSCoSiService) applySnapshot(snapshot *latencyprotocol.Snapshot) error {
+
+	if s.snapshot != nil && snapshot.Height <= s.snapshot.Height {
+		//the snapshot is already known
+		return nil
+	}
+
+	err := s.verifySnapshotSignature(snapshot)
+	if err != nil {
+		return err
+	}
+
+	if s.Retention.Prune {
+		err = s.pruneChain(snapshot)
+	} else {
+		err = s.Chain.VerifySnapshot(snapshot)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.storage.StoreSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+	s.snapshot = snapshot
+
+	if s.Retention.Prune {
+		return s.storage.PruneBefore(snapshot.Height)
+	}
+	return nil
+}
+
+//bootstrap restarts the chain from a snapshot signed by the trusted validators which extends the chain, so that
+//no block of the chain is lost. It has to be called with the chain locked
+func (s *BLSCoSiService) bootstrap(snapshot *latencyprotocol.Snapshot) error {
+
+	err := s.verifySnapshotSignature(snapshot)
+	if err != nil {
+		return err
+	}
+
+	err = s.Chain.ExtendedBy(snapshot)
+	if err != nil {
+		return err
+	}
+
+	err = s.storage.StoreSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	//the blocks of the chain are all below the snapshot
+	err = s.storage.PruneBefore(snapshot.Height)
+	if err != nil {
+		return err
+	}
+
+	err = s.replaceChain(latencyprotocol.ChainFromSnapshot(snapshot, s.Chain.BucketName))
+	if err != nil {
+		return err
+	}
+	s.snapshot = snapshot
+
+	return nil
+}
+
+//GetSnapshot sends the last snapshot signed by the validators, if any
+func (s *BLSCoSiService) GetSnapshot(request *GetSnapshotRequest) (*GetSnapshotResponse, error) {
+	s.chainLock.Lock()
+	defer s.chainLock.Unlock()
+
+	if s.snapshot == nil {
+		return &GetSnapshotResponse{}, nil
+	}
+
+	snapshotBytes, err := latencyprotocol.EncodeSnapshot(s.snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetSnapshotResponse{snapshotBytes}, nil
+}
+
+//bootstrapFrom asks a validator for its last snapshot and restarts the chain from it if the snapshot is ahead
+//of the chain, so that blocks pruned by the validator are not needed
+func (s *BLSCoSiService) bootstrapFrom(client *Client, si *network.ServerIdentity) error {
+
+	reply := &GetSnapshotResponse{}
+	err := client.SendProtobuf(si, &GetSnapshotRequest{}, reply)
+	if err != nil {
+		return err
+	}
+
+	if len(reply.Snapshot) == 0 {
+		return nil
+	}
+
+	snapshot, err := latencyprotocol.DecodeSnapshot(reply.Snapshot, s.Suite)
+	if err != nil {
+		return err
+	}
+
+	s.chainLock.Lock()
+	defer s.chainLock.Unlock()
+
+	if snapshot.Height <= s.Chain.Height() {
+		return nil
+	}
+
+	return s.bootstrap(snapshot)
+}
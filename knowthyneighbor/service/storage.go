@@ -0,0 +1,465 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
+	"go.dedis.ch/kyber/v3/pairing"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// This file contains the storage of the chain, so that a service can rebuild its chain after a restart.
+
+//ChainStorage persists the signed blocks of a chain
+type ChainStorage interface {
+	//StoreBlock saves a block with its signature under its height
+	StoreBlock(block *latencyprotocol.Block) error
+	//LoadBlocks returns all stored blocks ordered by height, starting at the first height which was not pruned
+	LoadBlocks() ([]*latencyprotocol.Block, error)
+	//StoreSnapshot saves a signed snapshot, replacing the previous one
+	StoreSnapshot(snapshot *latencyprotocol.Snapshot) error
+	//LoadSnapshot returns the last stored snapshot, or nil if there is none
+	LoadSnapshot() (*latencyprotocol.Snapshot, error)
+	//PruneBefore deletes the blocks below the given height
+	PruneBefore(height int) error
+	//StoreValidators saves the roster whose collective signatures are trusted, replacing the previous one
+	StoreValidators(roster *onet.Roster) error
+	//LoadValidators returns the stored roster, or nil if there is none
+	LoadValidators() (*onet.Roster, error)
+	//StoreReputation saves the reputation of the nodes of the chain, replacing the previous one
+	StoreReputation(reputation *latencyprotocol.Reputation) error
+	//LoadReputation returns the stored reputation, or nil if there is none
+	LoadReputation() (*latencyprotocol.Reputation, error)
+}
+
+//latestSnapshotKey is the key of the last snapshot in the snapshot bucket
+var latestSnapshotKey = []byte("latest")
+
+//validatorsKey is the key of the trusted roster in the snapshot bucket
+var validatorsKey = []byte("validators")
+
+//reputationKey is the key of the reputation of the nodes in the snapshot bucket
+var reputationKey = []byte("reputation")
+
+//BoltStorage stores the blocks in a bbolt database: encoded blocks are keyed by their hash,
+//a second bucket maps each height to the hash of the block at that height and a third one holds the last snapshot,
+//the trusted roster and the reputation of the nodes
+type BoltStorage struct {
+	db             *bbolt.DB
+	blocksBucket   []byte
+	heightBucket   []byte
+	snapshotBucket []byte
+	suite          *pairing.SuiteBn256
+}
+
+//NewBoltStorage creates a storage using three existing buckets of a bbolt database
+func NewBoltStorage(db *bbolt.DB, blocksBucket []byte, heightBucket []byte, snapshotBucket []byte, suite *pairing.SuiteBn256) *BoltStorage {
+	return &BoltStorage{db, blocksBucket, heightBucket, snapshotBucket, suite}
+}
+
+//heightKey encodes a height so that the keys of the height bucket are sorted by height
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	return key
+}
+
+//StoreBlock saves the encoded block and indexes it by height
+func (storage *BoltStorage) StoreBlock(block *latencyprotocol.Block) error {
+
+	hash, err := block.Hash()
+	if err != nil {
+		return err
+	}
+
+	blockBytes, err := latencyprotocol.EncodeBlock(block)
+	if err != nil {
+		return err
+	}
+
+	return storage.db.Update(func(tx *bbolt.Tx) error {
+		blocks := tx.Bucket(storage.blocksBucket)
+		heights := tx.Bucket(storage.heightBucket)
+		if blocks == nil || heights == nil {
+			return errors.New("Missing bucket")
+		}
+
+		err := blocks.Put(hash, blockBytes)
+		if err != nil {
+			return err
+		}
+		return heights.Put(heightKey(block.Index), hash)
+	})
+}
+
+//LoadBlocks reads the blocks in order of height
+func (storage *BoltStorage) LoadBlocks() ([]*latencyprotocol.Block, error) {
+
+	blocks := make([]*latencyprotocol.Block, 0)
+
+	err := storage.db.View(func(tx *bbolt.Tx) error {
+		blocksBucket := tx.Bucket(storage.blocksBucket)
+		heights := tx.Bucket(storage.heightBucket)
+		if blocksBucket == nil || heights == nil {
+			return errors.New("Missing bucket")
+		}
+
+		first := uint64(0)
+		if key, _ := heights.Cursor().First(); key != nil {
+			first = binary.BigEndian.Uint64(key)
+		}
+
+		return heights.ForEach(func(key []byte, hash []byte) error {
+			if binary.BigEndian.Uint64(key) != first+uint64(len(blocks)) {
+				return errors.New("Missing block in stored chain")
+			}
+
+			blockBytes := blocksBucket.Get(hash)
+			if blockBytes == nil {
+				return errors.New("Stored height refers to an unknown block")
+			}
+
+			block, err := latencyprotocol.DecodeBlock(blockBytes, storage.suite)
+			if err != nil {
+				return err
+			}
+
+			blocks = append(blocks, block)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+//StoreSnapshot saves the encoded snapshot
+func (storage *BoltStorage) StoreSnapshot(snapshot *latencyprotocol.Snapshot) error {
+
+	snapshotBytes, err := latencyprotocol.EncodeSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	return storage.db.Update(func(tx *bbolt.Tx) error {
+		snapshots := tx.Bucket(storage.snapshotBucket)
+		if snapshots == nil {
+			return errors.New("Missing bucket")
+		}
+		return snapshots.Put(latestSnapshotKey, snapshotBytes)
+	})
+}
+
+//LoadSnapshot reads the last snapshot
+func (storage *BoltStorage) LoadSnapshot() (*latencyprotocol.Snapshot, error) {
+
+	var snapshotBytes []byte
+
+	err := storage.db.View(func(tx *bbolt.Tx) error {
+		snapshots := tx.Bucket(storage.snapshotBucket)
+		if snapshots == nil {
+			return errors.New("Missing bucket")
+		}
+		//the value is only valid during the transaction
+		snapshotBytes = append([]byte{}, snapshots.Get(latestSnapshotKey)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(snapshotBytes) == 0 {
+		return nil, nil
+	}
+
+	return latencyprotocol.DecodeSnapshot(snapshotBytes, storage.suite)
+}
+
+//PruneBefore deletes the blocks below the given height along with their heights
+func (storage *BoltStorage) PruneBefore(height int) error {
+	return storage.db.Update(func(tx *bbolt.Tx) error {
+		blocks := tx.Bucket(storage.blocksBucket)
+		heights := tx.Bucket(storage.heightBucket)
+		if blocks == nil || heights == nil {
+			return errors.New("Missing bucket")
+		}
+
+		//keys cannot be deleted while iterating with ForEach
+		pruned := make([][]byte, 0)
+		cursor := heights.Cursor()
+		for key, _ := cursor.First(); key != nil && binary.BigEndian.Uint64(key) < uint64(height); key, _ = cursor.Next() {
+			pruned = append(pruned, append([]byte{}, key...))
+		}
+
+		for _, key := range pruned {
+			err := blocks.Delete(heights.Get(key))
+			if err != nil {
+				return err
+			}
+			err = heights.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+//StoreValidators saves the encoded roster
+func (storage *BoltStorage) StoreValidators(roster *onet.Roster) error {
+
+	rosterBytes, err := protobuf.Encode(roster)
+	if err != nil {
+		return err
+	}
+
+	return storage.db.Update(func(tx *bbolt.Tx) error {
+		snapshots := tx.Bucket(storage.snapshotBucket)
+		if snapshots == nil {
+			return errors.New("Missing bucket")
+		}
+		return snapshots.Put(validatorsKey, rosterBytes)
+	})
+}
+
+//LoadValidators reads the trusted roster
+func (storage *BoltStorage) LoadValidators() (*onet.Roster, error) {
+
+	var rosterBytes []byte
+
+	err := storage.db.View(func(tx *bbolt.Tx) error {
+		snapshots := tx.Bucket(storage.snapshotBucket)
+		if snapshots == nil {
+			return errors.New("Missing bucket")
+		}
+		rosterBytes = append([]byte{}, snapshots.Get(validatorsKey)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rosterBytes) == 0 {
+		return nil, nil
+	}
+
+	roster := &onet.Roster{}
+	err = protobuf.DecodeWithConstructors(rosterBytes, roster, network.DefaultConstructors(storage.suite))
+	if err != nil {
+		return nil, err
+	}
+	return roster, nil
+}
+
+//StoreReputation saves the encoded reputation
+func (storage *BoltStorage) StoreReputation(reputation *latencyprotocol.Reputation) error {
+
+	reputationBytes, err := latencyprotocol.EncodeReputation(reputation)
+	if err != nil {
+		return err
+	}
+
+	return storage.db.Update(func(tx *bbolt.Tx) error {
+		snapshots := tx.Bucket(storage.snapshotBucket)
+		if snapshots == nil {
+			return errors.New("Missing bucket")
+		}
+		return snapshots.Put(reputationKey, reputationBytes)
+	})
+}
+
+//LoadReputation reads the reputation
+func (storage *BoltStorage) LoadReputation() (*latencyprotocol.Reputation, error) {
+
+	var reputationBytes []byte
+
+	err := storage.db.View(func(tx *bbolt.Tx) error {
+		snapshots := tx.Bucket(storage.snapshotBucket)
+		if snapshots == nil {
+			return errors.New("Missing bucket")
+		}
+		reputationBytes = append([]byte{}, snapshots.Get(reputationKey)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(reputationBytes) == 0 {
+		return nil, nil
+	}
+
+	return latencyprotocol.DecodeReputation(reputationBytes)
+}
+
+//MemoryStorage keeps the blocks in memory, it is meant to be used in tests
+type MemoryStorage struct {
+	sync.Mutex
+	blocks     map[int]*latencyprotocol.Block
+	snapshot   *latencyprotocol.Snapshot
+	validators *onet.Roster
+	reputation []byte
+}
+
+//NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{blocks: make(map[int]*latencyprotocol.Block)}
+}
+
+//StoreBlock keeps the block under its height
+func (storage *MemoryStorage) StoreBlock(block *latencyprotocol.Block) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if block == nil {
+		return errors.New("Cannot store an empty block")
+	}
+
+	storage.blocks[block.Index] = block
+	return nil
+}
+
+//LoadBlocks returns the blocks in order of height
+func (storage *MemoryStorage) LoadBlocks() ([]*latencyprotocol.Block, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	first := -1
+	for height := range storage.blocks {
+		if first < 0 || height < first {
+			first = height
+		}
+	}
+
+	blocks := make([]*latencyprotocol.Block, len(storage.blocks))
+	for i := range blocks {
+		block, exists := storage.blocks[first+i]
+		if !exists {
+			return nil, errors.New("Missing block in stored chain")
+		}
+		blocks[i] = block
+	}
+
+	return blocks, nil
+}
+
+//StoreSnapshot keeps the snapshot, replacing the previous one
+func (storage *MemoryStorage) StoreSnapshot(snapshot *latencyprotocol.Snapshot) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if snapshot == nil {
+		return errors.New("Cannot store an empty snapshot")
+	}
+
+	storage.snapshot = snapshot
+	return nil
+}
+
+//LoadSnapshot returns the last snapshot
+func (storage *MemoryStorage) LoadSnapshot() (*latencyprotocol.Snapshot, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	return storage.snapshot, nil
+}
+
+//PruneBefore deletes the blocks below the given height
+func (storage *MemoryStorage) PruneBefore(height int) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	for index := range storage.blocks {
+		if index < height {
+			delete(storage.blocks, index)
+		}
+	}
+	return nil
+}
+
+//StoreValidators keeps the roster
+func (storage *MemoryStorage) StoreValidators(roster *onet.Roster) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if roster == nil {
+		return errors.New("Cannot store an empty roster")
+	}
+
+	storage.validators = roster
+	return nil
+}
+
+//LoadValidators returns the roster
+func (storage *MemoryStorage) LoadValidators() (*onet.Roster, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	return storage.validators, nil
+}
+
+//StoreReputation keeps the encoded reputation, so that later updates of the reputation do not change it
+func (storage *MemoryStorage) StoreReputation(reputation *latencyprotocol.Reputation) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	reputationBytes, err := latencyprotocol.EncodeReputation(reputation)
+	if err != nil {
+		return err
+	}
+
+	storage.reputation = reputationBytes
+	return nil
+}
+
+//LoadReputation returns the reputation
+func (storage *MemoryStorage) LoadReputation() (*latencyprotocol.Reputation, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if storage.reputation == nil {
+		return nil, nil
+	}
+	return latencyprotocol.DecodeReputation(storage.reputation)
+}
+
+//loadChain appends the stored blocks to the chain, checking that they are correctly linked.
+//If the blocks were pruned, the chain starts from the stored snapshot. The snapshot is returned, or nil if there is none
+func loadChain(storage ChainStorage, chain *latencyprotocol.Chain) (*latencyprotocol.Snapshot, error) {
+
+	snapshot, err := storage.LoadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := storage.LoadBlocks()
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := len(blocks) == 0 || blocks[0].Index != 0
+	if snapshot != nil && pruned {
+		*chain = *latencyprotocol.ChainFromSnapshot(snapshot, chain.BucketName)
+	}
+
+	for _, block := range blocks {
+		if block.Index < chain.Height() {
+			//the block is below the snapshot
+			continue
+		}
+		err = chain.Append(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return snapshot, nil
+}
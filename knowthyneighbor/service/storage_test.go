@@ -0,0 +1,207 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+	bbolt "go.etcd.io/bbolt"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+//storeLinkedBlocks appends nbBlocks signed blocks to a new chain and stores them
+func storeLinkedBlocks(t *testing.T, storage ChainStorage, nbBlocks int) *latencyprotocol.Chain {
+	chain := &latencyprotocol.Chain{Blocks: []*latencyprotocol.Block{}, BucketName: []byte(blocksName)}
+
+	for i := 0; i < nbBlocks; i++ {
+		latencies := make(map[string]latencyprotocol.ConfirmedLatency)
+		for j := 0; j < i; j++ {
+			latencies[string(rune('A'+j))] = latencyprotocol.ConfirmedLatency{
+				Latency:            time.Duration(10 * (i + j)),
+				SignedLatency:      []byte("signedLatency"),
+				Timestamp:          time.Now(),
+				SignedConfirmation: []byte("signedConfirmation"),
+			}
+		}
+		block := &latencyprotocol.Block{
+			ID:        &latencyprotocol.NodeID{PublicKey: sigAlg.PublicKey(string(rune('A' + i)))},
+			Latencies: latencies,
+		}
+
+		require.NoError(t, chain.Link(block))
+		block.Signature = []byte("signature")
+		require.NoError(t, chain.Append(block))
+		require.NoError(t, storage.StoreBlock(block))
+	}
+
+	return chain
+}
+
+//checkReloadedChain checks that a chain loaded from the storage has the same blocks as the original chain
+func checkReloadedChain(t *testing.T, storage ChainStorage, chain *latencyprotocol.Chain) {
+	reloaded := &latencyprotocol.Chain{Blocks: []*latencyprotocol.Block{}, BucketName: []byte(blocksName)}
+	_, err := loadChain(storage, reloaded)
+	require.NoError(t, err)
+
+	require.Equal(t, chain.Height(), reloaded.Height())
+	for i, block := range chain.Blocks {
+		hash, err := block.Hash()
+		require.NoError(t, err)
+		reloadedHash, err := reloaded.Blocks[i].Hash()
+		require.NoError(t, err)
+		require.Equal(t, hash, reloadedHash)
+		require.Equal(t, block.Signature, reloaded.Blocks[i].Signature)
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+	chain := storeLinkedBlocks(t, storage, 4)
+	checkReloadedChain(t, storage, chain)
+
+	//a missing height cannot be loaded
+	delete(storage.blocks, 2)
+	_, err := storage.LoadBlocks()
+	require.Error(t, err)
+
+	storage = NewMemoryStorage()
+	chain = storeLinkedBlocks(t, storage, 4)
+	checkPrunedStorage(t, storage, chain)
+
+	checkStoredReputation(t, NewMemoryStorage())
+}
+
+func TestBoltStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chainstorage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "chain.db")
+	blocksBucket := []byte(blocksName)
+	heightBucket := []byte(blocksName + "Heights")
+	snapshotBucket := []byte(blocksName + "Snapshots")
+
+	open := func() *bbolt.DB {
+		db, err := bbolt.Open(path, 0600, nil)
+		require.NoError(t, err)
+		require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+			if _, err := tx.CreateBucketIfNotExists(blocksBucket); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucketIfNotExists(heightBucket); err != nil {
+				return err
+			}
+			_, err := tx.CreateBucketIfNotExists(snapshotBucket)
+			return err
+		}))
+		return db
+	}
+
+	db := open()
+	chain := storeLinkedBlocks(t, NewBoltStorage(db, blocksBucket, heightBucket, snapshotBucket, tSuite), 4)
+	require.NoError(t, db.Close())
+
+	//the chain survives reopening the database
+	db = open()
+	defer db.Close()
+	checkReloadedChain(t, NewBoltStorage(db, blocksBucket, heightBucket, snapshotBucket, tSuite), chain)
+
+	//a reloaded chain which is not linked is refused
+	storage := NewBoltStorage(db, blocksBucket, heightBucket, snapshotBucket, tSuite)
+	unlinked := &latencyprotocol.Chain{Blocks: []*latencyprotocol.Block{}, BucketName: []byte(blocksName)}
+	require.NoError(t, unlinked.Append(&latencyprotocol.Block{ID: &latencyprotocol.NodeID{PublicKey: sigAlg.PublicKey("Z")}}))
+	_, err = loadChain(storage, unlinked)
+	require.Error(t, err)
+
+	checkPrunedStorage(t, storage, chain)
+	checkStoredReputation(t, storage)
+}
+
+func TestStoredValidators(t *testing.T) {
+	checkStoredValidators(t, NewMemoryStorage())
+
+	dir, err := ioutil.TempDir("", "chainstorage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := bbolt.Open(filepath.Join(dir, "chain.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	snapshotBucket := []byte(blocksName + "Snapshots")
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(snapshotBucket)
+		return err
+	}))
+	checkStoredValidators(t, NewBoltStorage(db, []byte(blocksName), []byte(blocksName+"Heights"), snapshotBucket, tSuite))
+}
+
+//checkStoredValidators checks that the trusted roster is stored and replaced
+func checkStoredValidators(t *testing.T, storage ChainStorage) {
+	validators, err := storage.LoadValidators()
+	require.NoError(t, err)
+	require.Nil(t, validators)
+
+	for _, name := range []string{"first", "second"} {
+		si := network.NewServerIdentity(tSuite.Point().Pick(tSuite.RandomStream()), network.NewAddress(network.Local, name))
+		roster := onet.NewRoster([]*network.ServerIdentity{si})
+		require.NoError(t, storage.StoreValidators(roster))
+
+		validators, err = storage.LoadValidators()
+		require.NoError(t, err)
+		require.True(t, roster.ID.Equal(validators.ID))
+		require.Equal(t, 1, len(validators.List))
+		require.True(t, si.Public.Equal(validators.List[0].Public))
+	}
+}
+
+//checkStoredReputation checks that the reputation is stored and replaced
+func checkStoredReputation(t *testing.T, storage ChainStorage) {
+	stored, err := storage.LoadReputation()
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	reputation := latencyprotocol.NewReputation(latencyprotocol.DefaultReputationParams())
+	for i := 1; i <= 2; i++ {
+		reputation.Penalties["N"+strconv.Itoa(i)] = float64(i)
+		require.NoError(t, storage.StoreReputation(reputation))
+
+		stored, err = storage.LoadReputation()
+		require.NoError(t, err)
+		require.Equal(t, reputation.Penalties, stored.Penalties)
+	}
+}
+
+//checkPrunedStorage prunes the stored blocks below a snapshot of the chain and checks that the reloaded chain
+//starts from the snapshot
+func checkPrunedStorage(t *testing.T, storage ChainStorage, chain *latencyprotocol.Chain) {
+	snapshot, err := chain.Snapshot(2)
+	require.NoError(t, err)
+	require.NoError(t, storage.StoreSnapshot(snapshot))
+	require.NoError(t, storage.PruneBefore(snapshot.Height))
+
+	blocks, err := storage.LoadBlocks()
+	require.NoError(t, err)
+	require.Equal(t, chain.Height()-snapshot.Height, len(blocks))
+	require.Equal(t, snapshot.Height, blocks[0].Index)
+
+	reloaded := &latencyprotocol.Chain{Blocks: []*latencyprotocol.Block{}, BucketName: []byte(blocksName)}
+	reloadedSnapshot, err := loadChain(storage, reloaded)
+	require.NoError(t, err)
+	require.Equal(t, snapshot.Height, reloadedSnapshot.Height)
+	require.NotNil(t, reloaded.Checkpoint)
+	require.Equal(t, chain.Height(), reloaded.Height())
+
+	lastHash, err := chain.LastHash()
+	require.NoError(t, err)
+	reloadedHash, err := reloaded.LastHash()
+	require.NoError(t, err)
+	require.Equal(t, lastHash, reloadedHash)
+}
@@ -1,6 +1,10 @@
 package service
 
 import (
+	"strings"
+
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/blscosiprotocol"
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/network"
 )
@@ -44,4 +48,85 @@ type CreateBlockRequest struct {
 //CreateBlockResponse is what a BLSCosi service replies to clients trying to store blocks
 type CreateBlockResponse struct {
 	Block []byte
+	//Refusals explains why some validators did not sign the block
+	Refusals []blscosiprotocol.Refusal
+}
+
+//RefusalError is returned when a message could not be signed because validators refused it
+type RefusalError struct {
+	Refusals []blscosiprotocol.Refusal
+}
+
+func (e *RefusalError) Error() string {
+	reasons := make([]string, len(e.Refusals))
+	for i, refusal := range e.Refusals {
+		reasons[i] = refusal.String()
+	}
+	return "Message refused by validators: " + strings.Join(reasons, "; ")
+}
+
+//PropagateBlock is propagated to the validators so that they all store the signed blocks
+type PropagateBlock struct {
+	Block []byte
+}
+
+//GetBlocksRequest is sent by a validator which fell behind to get the blocks of the chain starting at a given height
+type GetBlocksRequest struct {
+	From int
+}
+
+//GetBlocksResponse contains the encoded signed blocks following the requested height
+type GetBlocksResponse struct {
+	Blocks [][]byte
+}
+
+//CatchUpRequest asks a validator to fetch the blocks it is missing from the other members of the roster
+type CatchUpRequest struct {
+	Roster *onet.Roster
+}
+
+//CatchUpResponse gives the height of the chain of the validator after catching up
+type CatchUpResponse struct {
+	Height int
+}
+
+//LatencyProofRequest asks for the latest latency measured by the node From with the node To, given by their public keys
+type LatencyProofRequest struct {
+	From []byte
+	To   []byte
+}
+
+//LatencyProofResponse contains a latency with the signed header of its block and the proof that it belongs to the block
+type LatencyProofResponse struct {
+	Header    *latencyprotocol.BlockHeader
+	Signature []byte
+	Latency   latencyprotocol.ConfirmedLatency
+	Proof     *latencyprotocol.LatencyProof
+}
+
+//PropagateSnapshot is propagated to the validators so that they all store the signed snapshots
+type PropagateSnapshot struct {
+	Snapshot []byte
+}
+
+//GetSnapshotRequest asks a validator for the last snapshot signed by the roster
+type GetSnapshotRequest struct {
+}
+
+//GetSnapshotResponse contains the encoded signed snapshot, empty if the validator has none
+type GetSnapshotResponse struct {
+	Snapshot []byte
+}
+
+//MembershipRequest asks the validators to sign a block by which a node leaves, rotates its key or is revoked
+type MembershipRequest struct {
+	Roster *onet.Roster
+	Block  []byte
+}
+
+//MembershipResponse contains the signed membership block
+type MembershipResponse struct {
+	Block []byte
+	//Refusals explains why some validators did not sign the block
+	Refusals []blscosiprotocol.Refusal
 }
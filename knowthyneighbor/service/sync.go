@@ -0,0 +1,236 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/blscosiprotocol"
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// This file contains the synchronisation of the chain between validators: signed blocks are propagated to
+// the whole roster, and a validator missing blocks asks the other validators for them. The signatures are always
+// checked against the validators trusted by the service, never against a roster sent along with the blocks.
+
+//maxBlocksPerRequest is the largest number of blocks sent in reply to a GetBlocksRequest
+const maxBlocksPerRequest = 50
+
+//propagateBlock sends a signed block to all the validators of the roster
+func (s *BLSCoSiService) propagateBlock(Roster *onet.Roster, blockBytes []byte) error {
+	return s.startPropagation(s.blockPropagationFunction, Roster, &PropagateBlock{blockBytes})
+}
+
+//propagateBlockHandler stores a block signed by the trusted validators, catching up first if blocks are missing before it
+func (s *BLSCoSiService) propagateBlockHandler(msg network.Message) error {
+	propagated := msg.(*PropagateBlock)
+
+	block, err := latencyprotocol.DecodeBlock(propagated.Block, s.Suite)
+	if err != nil {
+		log.Error(err, "Couldn't decode propagated block:")
+		return err
+	}
+
+	s.chainLock.Lock()
+	height := s.Chain.Height()
+	validators := s.validators
+	s.chainLock.Unlock()
+
+	if block.Index > height && validators != nil {
+		_, err = s.catchUp(validators)
+		if err != nil {
+			log.Warn(s.ServerIdentity(), "couldn't catch up:", err)
+		}
+	}
+
+	s.chainLock.Lock()
+	defer s.chainLock.Unlock()
+
+	if block.Index < s.Chain.Height() {
+		//the block is already known, for example by the validator which created it
+		return nil
+	}
+
+	err = s.applyBlock(block)
+	if err != nil {
+		log.Error(err, "Couldn't add propagated block:")
+	}
+	return err
+}
+
+//verifySignedBlock checks that a block received from another validator was signed by a quorum of the trusted
+//validators and that its latencies are correct. It has to be called with the chain locked
+func (s *BLSCoSiService) verifySignedBlock(block *latencyprotocol.Block) error {
+
+	header, err := block.Header()
+	if err != nil {
+		return err
+	}
+
+	err = s.verifyCollectiveSignature(header.Hash(), block.Signature)
+	if err != nil {
+		return err
+	}
+
+	//blocks received when catching up can be old
+	chain, params := s.verificationState()
+	params.MaxAge = time.Duration(math.MaxInt64)
+
+	return latencyprotocol.VerifyBlock(block, chain, params)
+}
+
+//applyBlock verifies a block received from another validator, appends it to the chain and stores it.
+//It has to be called with the chain locked
+func (s *BLSCoSiService) applyBlock(block *latencyprotocol.Block) error {
+
+	err := s.verifySignedBlock(block)
+	if err != nil {
+		return err
+	}
+
+	err = s.appendBlock(block)
+	if err != nil {
+		return err
+	}
+
+	return s.storage.StoreBlock(block)
+}
+
+//verifyCollectiveSignature checks that a message was signed by a quorum of the validators trusted by the service.
+//It has to be called with the chain locked
+func (s *BLSCoSiService) verifyCollectiveSignature(message []byte, signature []byte) error {
+
+	if s.validators == nil {
+		return errors.New("No trusted validators to check the signature against")
+	}
+
+	err := blscosiprotocol.Verify(s.Suite, s.validators.Publics(), message, signature, blscosiprotocol.DefaultQuorum(len(s.validators.List)))
+	if err != nil {
+		return errors.New("Invalid collective signature: " + err.Error())
+	}
+	return nil
+}
+
+//GetBlocks sends the blocks of the chain starting at the requested height
+func (s *BLSCoSiService) GetBlocks(request *GetBlocksRequest) (*GetBlocksResponse, error) {
+	s.chainLock.Lock()
+	defer s.chainLock.Unlock()
+
+	if request.From < 0 {
+		return nil, errors.New("Negative height")
+	}
+
+	blocks := make([][]byte, 0)
+	for i := request.From; i < s.Chain.Height() && len(blocks) < maxBlocksPerRequest; i++ {
+		block := s.Chain.BlockAt(i)
+		if block == nil {
+			return nil, errors.New("Blocks were pruned, bootstrap from the snapshot")
+		}
+		blockBytes, err := latencyprotocol.EncodeBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, blockBytes)
+	}
+
+	return &GetBlocksResponse{blocks}, nil
+}
+
+//CatchUp fetches the blocks this validator is missing from the other members of the roster. The roster only tells
+//which validators to ask, the blocks must be signed by the trusted validators
+func (s *BLSCoSiService) CatchUp(request *CatchUpRequest) (*CatchUpResponse, error) {
+	height, err := s.catchUp(request.Roster)
+	if err != nil {
+		return nil, err
+	}
+	return &CatchUpResponse{height}, nil
+}
+
+//catchUp asks the validators of the roster for the blocks following the last block of the chain, until none of them
+//has newer blocks. A validator whose snapshot is ahead of the chain is asked for it first, so that the chain restarts
+//from the snapshot. Every block is verified against the trusted validators before being appended. It returns the height
+//of the chain
+func (s *BLSCoSiService) catchUp(Roster *onet.Roster) (int, error) {
+
+	client := NewClient()
+	defer client.Close()
+
+	var lastErr error
+
+	for _, si := range Roster.List {
+		if si.Equal(s.ServerIdentity()) {
+			continue
+		}
+
+		err := s.bootstrapFrom(client, si)
+		if err != nil {
+			log.Warn(s.ServerIdentity(), "couldn't bootstrap from", si, ":", err)
+			lastErr = err
+		}
+
+		for {
+			s.chainLock.Lock()
+			from := s.Chain.Height()
+			s.chainLock.Unlock()
+
+			reply := &GetBlocksResponse{}
+			err := client.SendProtobuf(si, &GetBlocksRequest{from}, reply)
+			if err != nil {
+				lastErr = err
+				break
+			}
+
+			err = s.applyBlocks(reply.Blocks, from)
+			if err != nil {
+				log.Warn(s.ServerIdentity(), "refused blocks from", si, ":", err)
+				lastErr = err
+				break
+			}
+
+			if len(reply.Blocks) < maxBlocksPerRequest {
+				break
+			}
+		}
+	}
+
+	s.chainLock.Lock()
+	defer s.chainLock.Unlock()
+
+	if s.Chain.Height() == 0 && lastErr != nil {
+		return 0, lastErr
+	}
+
+	return s.Chain.Height(), nil
+}
+
+//applyBlocks decodes and applies the blocks received from another validator, which should start at the given height
+func (s *BLSCoSiService) applyBlocks(blocks [][]byte, from int) error {
+	s.chainLock.Lock()
+	defer s.chainLock.Unlock()
+
+	for i, blockBytes := range blocks {
+		block, err := latencyprotocol.DecodeBlock(blockBytes, s.Suite)
+		if err != nil {
+			return err
+		}
+
+		if block.Index != from+i {
+			return errors.New("Received blocks out of order")
+		}
+
+		if block.Index < s.Chain.Height() {
+			//the block was added meanwhile
+			continue
+		}
+
+		err = s.applyBlock(block)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/dedis/student_19_proof-of-loc/knowthyneighbor/latencyprotocol"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3"
+	sigAlg "golang.org/x/crypto/ed25519"
+)
+
+//emptyBlock creates a block without latencies for a new node
+func emptyBlock(t *testing.T) *latencyprotocol.Block {
+	pubKey, _, err := sigAlg.GenerateKey(nil)
+	require.NoError(t, err)
+	return &latencyprotocol.Block{
+		ID:        &latencyprotocol.NodeID{PublicKey: pubKey},
+		Latencies: make(map[string]latencyprotocol.ConfirmedLatency),
+	}
+}
+
+//joiningBlock creates a block without latencies for a new node, with the admission proof asked by the service
+func joiningBlock(t *testing.T, s *BLSCoSiService) *latencyprotocol.Block {
+	block := emptyBlock(t)
+	require.NoError(t, s.proveAdmission(&latencyprotocol.Node{ID: block.ID}, block))
+	return block
+}
+
+//resetChain empties the chain and storage of a service
+func resetChain(s *BLSCoSiService) {
+	s.Chain = &latencyprotocol.Chain{Blocks: []*latencyprotocol.Block{}, BucketName: []byte(blocksName)}
+	s.storage = NewMemoryStorage()
+	s.snapshot = nil
+	s.reputation = nil
+	s.rebuildBlacklist()
+}
+
+func TestChainSync(t *testing.T) {
+
+	local := onet.NewTCPTest(tSuite)
+	local.Check = onet.CheckNone
+	hosts, el, _ := local.GenTree(4, false)
+	defer local.CloseAll()
+
+	services := local.GetServices(hosts, serviceID)
+	for _, service := range services {
+		resetChain(service.(*BLSCoSiService))
+		require.NoError(t, service.(*BLSCoSiService).SetValidators(el))
+	}
+	s := services[0].(*BLSCoSiService)
+
+	nbBlocks := 3
+	for i := 0; i < nbBlocks; i++ {
+		_, _, err := s.addBlock(el, joiningBlock(t, s))
+		require.NoError(t, err)
+	}
+
+	//every validator stored the blocks
+	for _, service := range services {
+		validator := service.(*BLSCoSiService)
+		require.Equal(t, nbBlocks, validator.Chain.Height())
+
+		stored, err := validator.storage.LoadBlocks()
+		require.NoError(t, err)
+		require.Equal(t, nbBlocks, len(stored))
+
+		lastHash, err := validator.Chain.LastHash()
+		require.NoError(t, err)
+		expectedHash, err := s.Chain.LastHash()
+		require.NoError(t, err)
+		require.Equal(t, expectedHash, lastHash)
+	}
+
+	//a validator which lost its chain catches up
+	late := services[3].(*BLSCoSiService)
+	resetChain(late)
+
+	reply, err := late.CatchUp(&CatchUpRequest{el})
+	require.NoError(t, err)
+	require.Equal(t, nbBlocks, reply.Height)
+	require.Equal(t, nbBlocks, late.Chain.Height())
+}
+
+func TestSyncRefusesUnsignedBlock(t *testing.T) {
+
+	local := onet.NewTCPTest(tSuite)
+	local.Check = onet.CheckNone
+	hosts, el, _ := local.GenTree(4, false)
+	defer local.CloseAll()
+
+	services := local.GetServices(hosts, serviceID)
+	for _, service := range services {
+		resetChain(service.(*BLSCoSiService))
+		require.NoError(t, service.(*BLSCoSiService).SetValidators(el))
+	}
+	s := services[0].(*BLSCoSiService)
+
+	_, _, err := s.addBlock(el, joiningBlock(t, s))
+	require.NoError(t, err)
+
+	//a block linked to the chain but signed by nobody is refused
+	other := services[1].(*BLSCoSiService)
+	block := emptyBlock(t)
+	require.NoError(t, other.Chain.Link(block))
+	block.Signature = append([]byte{}, s.Chain.Blocks[0].Signature...)
+
+	other.chainLock.Lock()
+	err = other.applyBlock(block)
+	other.chainLock.Unlock()
+	require.Error(t, err)
+	require.Equal(t, 1, other.Chain.Height())
+}
+
+func TestSyncRefusesForeignRoster(t *testing.T) {
+
+	local := onet.NewTCPTest(tSuite)
+	local.Check = onet.CheckNone
+	hosts, el, _ := local.GenTree(4, false)
+	foreignHosts, foreign, _ := local.GenTree(4, false)
+	defer local.CloseAll()
+
+	services := local.GetServices(hosts, serviceID)
+	for _, service := range services {
+		resetChain(service.(*BLSCoSiService))
+		require.NoError(t, service.(*BLSCoSiService).SetValidators(el))
+	}
+	foreignServices := local.GetServices(foreignHosts, serviceID)
+	for _, service := range foreignServices {
+		resetChain(service.(*BLSCoSiService))
+		require.NoError(t, service.(*BLSCoSiService).SetValidators(foreign))
+	}
+
+	//a block correctly signed by another roster
+	f := foreignServices[0].(*BLSCoSiService)
+	blockBytes, _, err := f.addBlock(foreign, joiningBlock(t, f))
+	require.NoError(t, err)
+
+	//is refused by the validators, which only trust their own roster
+	validator := services[1].(*BLSCoSiService)
+	require.Error(t, validator.propagateBlockHandler(&PropagateBlock{blockBytes}))
+	require.Equal(t, 0, validator.Chain.Height())
+
+	block, err := latencyprotocol.DecodeBlock(blockBytes, validator.Suite)
+	require.NoError(t, err)
+	validator.chainLock.Lock()
+	err = validator.applyBlock(block)
+	validator.chainLock.Unlock()
+	require.Error(t, err)
+	require.Equal(t, 0, validator.Chain.Height())
+}
+
+func TestSnapshotPruning(t *testing.T) {
+
+	local := onet.NewTCPTest(tSuite)
+	local.Check = onet.CheckNone
+	hosts, el, _ := local.GenTree(4, false)
+	defer local.CloseAll()
+
+	services := local.GetServices(hosts, serviceID)
+	for _, service := range services {
+		validator := service.(*BLSCoSiService)
+		resetChain(validator)
+		require.NoError(t, validator.SetValidators(el))
+		validator.Retention = RetentionPolicy{SnapshotInterval: 2, Prune: true}
+	}
+	s := services[0].(*BLSCoSiService)
+
+	nbBlocks := 3
+	for i := 0; i < nbBlocks; i++ {
+		_, _, err := s.addBlock(el, joiningBlock(t, s))
+		require.NoError(t, err)
+	}
+
+	//every validator signed the snapshot and pruned the blocks below it
+	for _, service := range services {
+		validator := service.(*BLSCoSiService)
+		require.NotNil(t, validator.snapshot)
+		require.Equal(t, 2, validator.snapshot.Height)
+		require.Equal(t, nbBlocks, validator.Chain.Height())
+		require.NotNil(t, validator.Chain.Checkpoint)
+		require.Nil(t, validator.Chain.BlockAt(0))
+
+		stored, err := validator.storage.LoadBlocks()
+		require.NoError(t, err)
+		require.Equal(t, nbBlocks-2, len(stored))
+	}
+
+	//pruned blocks cannot be requested
+	_, err := s.GetBlocks(&GetBlocksRequest{0})
+	require.Error(t, err)
+
+	//a validator which lost its chain bootstraps from the snapshot
+	late := services[3].(*BLSCoSiService)
+	resetChain(late)
+
+	reply, err := late.CatchUp(&CatchUpRequest{el})
+	require.NoError(t, err)
+	require.Equal(t, nbBlocks, reply.Height)
+	require.NotNil(t, late.Chain.Checkpoint)
+
+	lastHash, err := late.Chain.LastHash()
+	require.NoError(t, err)
+	expectedHash, err := s.Chain.LastHash()
+	require.NoError(t, err)
+	require.Equal(t, expectedHash, lastHash)
+}
+
+func TestBootstrapRefusesUntrustedSnapshot(t *testing.T) {
+
+	local := onet.NewTCPTest(tSuite)
+	local.Check = onet.CheckNone
+	hosts, el, _ := local.GenTree(4, false)
+	foreignHosts, foreign, _ := local.GenTree(4, false)
+	defer local.CloseAll()
+
+	services := local.GetServices(hosts, serviceID)
+	foreignServices := local.GetServices(foreignHosts, serviceID)
+	for i, service := range append(services, foreignServices...) {
+		validator := service.(*BLSCoSiService)
+		resetChain(validator)
+		if i < len(services) {
+			require.NoError(t, validator.SetValidators(el))
+		} else {
+			require.NoError(t, validator.SetValidators(foreign))
+		}
+		validator.Retention = RetentionPolicy{SnapshotInterval: 2, Prune: true}
+	}
+	s := services[0].(*BLSCoSiService)
+	f := foreignServices[0].(*BLSCoSiService)
+
+	for i := 0; i < 2; i++ {
+		_, _, err := s.addBlock(el, joiningBlock(t, s))
+		require.NoError(t, err)
+		_, _, err = f.addBlock(foreign, joiningBlock(t, f))
+		require.NoError(t, err)
+	}
+	require.NotNil(t, s.snapshot)
+	require.NotNil(t, f.snapshot)
+
+	//a snapshot signed by another roster does not replace the chain
+	validator := services[1].(*BLSCoSiService)
+	validator.chainLock.Lock()
+	lastHash, err := validator.Chain.LastHash()
+	require.NoError(t, err)
+	require.Error(t, validator.bootstrap(f.snapshot))
+	bootstrappedHash, err := validator.Chain.LastHash()
+	require.NoError(t, err)
+	validator.chainLock.Unlock()
+	require.Equal(t, lastHash, bootstrappedHash)
+
+	//a snapshot of the trusted validators which does not extend the chain does not replace it either
+	diverging := services[3].(*BLSCoSiService)
+	resetChain(diverging)
+	block := emptyBlock(t)
+	diverging.chainLock.Lock()
+	require.NoError(t, diverging.Chain.Link(block))
+	require.NoError(t, diverging.appendBlock(block))
+	require.Error(t, diverging.bootstrap(s.snapshot))
+	diverging.chainLock.Unlock()
+	require.Equal(t, 1, diverging.Chain.Height())
+	require.Equal(t, block, diverging.Chain.Blocks[0])
+
+	//while an empty chain restarts from it
+	resetChain(diverging)
+	diverging.chainLock.Lock()
+	require.NoError(t, diverging.bootstrap(s.snapshot))
+	diverging.chainLock.Unlock()
+	require.Equal(t, 2, diverging.Chain.Height())
+}
+
+func TestAdmission(t *testing.T) {
+
+	local := onet.NewTCPTest(tSuite)
+	local.Check = onet.CheckNone
+	hosts, el, _ := local.GenTree(4, false)
+	defer local.CloseAll()
+
+	services := local.GetServices(hosts, serviceID)
+	for _, service := range services {
+		resetChain(service.(*BLSCoSiService))
+		require.NoError(t, service.(*BLSCoSiService).SetValidators(el))
+	}
+	s := services[0].(*BLSCoSiService)
+
+	//a node without proof of work is refused by the validators
+	_, _, err := s.addBlock(el, emptyBlock(t))
+	require.Error(t, err)
+	require.Equal(t, 0, s.Chain.Height())
+
+	_, _, err = s.addBlock(el, joiningBlock(t, s))
+	require.NoError(t, err)
+
+	//with an allowlist, only the allowed nodes join
+	allowed := emptyBlock(t)
+	allowlist := latencyprotocol.NewAllowlistPolicy(allowed.ID.PublicKey)
+	for _, service := range services {
+		service.(*BLSCoSiService).Admission = allowlist
+	}
+
+	_, _, err = s.addBlock(el, joiningBlock(t, s))
+	require.Error(t, err)
+
+	require.NoError(t, s.proveAdmission(&latencyprotocol.Node{ID: allowed.ID}, allowed))
+	_, _, err = s.addBlock(el, allowed)
+	require.NoError(t, err)
+	require.Equal(t, 2, s.Chain.Height())
+}